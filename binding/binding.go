@@ -0,0 +1,271 @@
+// Package binding derives the message definitions from Go struct types and
+// copies the values between the struct instances and dynamic entities.
+//
+// The fields of the struct are bound to the message fields according to the
+// `dymessage` struct tag, which has the following format:
+//
+//	Field int32 `dymessage:"name,tag,options"`
+//
+// The name and tag are optional and default to the name of the Go field and
+// the position of the field among the bound fields of the struct respectively.
+// The options are separated by commas and may be either "zigzag" or "varint",
+// which alter the protocol buffers encoding of the integers. The fields with
+// the "-" tag and the unexported fields are ignored.
+//
+// The int32, int64, uint32, uint64, float32, float64, bool, string and []byte
+// types are bound to the fields of corresponding data types, while int and
+// uint are bound to 64-bit integers. The nested structs, either by value or by
+// pointer, are bound to the nested entities, and the slices of any of the
+// types above are bound to the repeated fields.
+package binding
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+type (
+	// Derives the message definitions from the Go struct types, adding
+	// them to the registry builder. The nested struct types get their
+	// own message definitions, which are shared between all of the
+	// struct types that refer them.
+	Binder struct {
+		rb        *RegistryBuilder
+		namespace string
+
+		defs     map[reflect.Type]*MessageDef
+		builders map[reflect.Type]*MessageDefBuilder
+		// The struct types, which have been referenced but not yet
+		// defined.
+		queue []reflect.Type
+	}
+
+	// A field of the struct as described by the struct tag.
+	structField struct {
+		reflect.StructField
+		name    string
+		tag     uint64
+		options []string
+	}
+)
+
+// NewBinder creates a binder, which adds the message definitions to the
+// registry builder. If the binder returns an error, the registry builder is
+// left in an undefined state.
+func NewBinder(rb *RegistryBuilder) *Binder {
+	return &Binder{
+		rb:       rb,
+		defs:     make(map[reflect.Type]*MessageDef),
+		builders: make(map[reflect.Type]*MessageDefBuilder),
+	}
+}
+
+// WithNamespace sets the namespace of the message definitions, which will be
+// derived from the struct types after this call.
+func (b *Binder) WithNamespace(namespace string) *Binder {
+	b.namespace = namespace
+	return b
+}
+
+// Define derives the message definition from the type of provided value, which
+// must be either a struct or a pointer to struct, or a reflect.Type of either
+// of them. If the message definition for the type has already been derived,
+// the method returns the existing one.
+func (b *Binder) Define(v interface{}) (*MessageDef, error) {
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	t, err := structType(t)
+	if err != nil {
+		return nil, err
+	}
+	b.getBuilder(t)
+	for len(b.queue) > 0 {
+		next := b.queue[0]
+		b.queue = b.queue[1:]
+		if err := b.define(next); err != nil {
+			return nil, err
+		}
+	}
+	return b.defs[t], nil
+}
+
+func (b *Binder) define(t reflect.Type) error {
+	fields, err := getStructFields(t)
+	if err != nil {
+		return err
+	}
+	mb := b.builders[t].
+		WithNamespace(b.namespace).
+		WithName(t.Name())
+	for _, f := range fields {
+		dt, repeated, err := b.getDataType(f.Type)
+		if err != nil {
+			return fmt.Errorf("dymessage: field %s of %v: %v", f.Name, t, err)
+		}
+		if repeated {
+			mb.WithArrayField(f.name, f.tag, dt)
+		} else {
+			mb.WithField(f.name, f.tag, dt)
+		}
+		for _, opt := range f.options {
+			ext, err := getExtension(opt, dt)
+			if err != nil {
+				return fmt.Errorf("dymessage: field %s of %v: %v", f.Name, t, err)
+			}
+			mb.ExtendField(ext)
+		}
+	}
+	b.defs[t] = mb.Build()
+	return nil
+}
+
+// getBuilder gets the builder of the message definition for the struct type,
+// scheduling the type to be defined if it has not been referenced yet.
+func (b *Binder) getBuilder(t reflect.Type) *MessageDefBuilder {
+	if mb, ok := b.builders[t]; ok {
+		return mb
+	}
+	mb := b.rb.ForMessageDef(t)
+	b.builders[t] = mb
+	b.queue = append(b.queue, t)
+	return mb
+}
+
+// getDataType gets the data type of the message field, which corresponds to
+// the type of the struct field, and a value indicating whether the field is
+// repeated.
+func (b *Binder) getDataType(t reflect.Type) (dt DataType, repeated bool, err error) {
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		repeated, t = true, t.Elem()
+	}
+	if dt, ok := getPrimitiveDataType(t); ok {
+		return dt, repeated, nil
+	}
+	st, err := structType(t)
+	if err != nil {
+		return DtNone, false, err
+	}
+	return b.getBuilder(st).GetDataType(), repeated, nil
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func getPrimitiveDataType(t reflect.Type) (DataType, bool) {
+	switch t.Kind() {
+	case reflect.Int32:
+		return DtInt32, true
+	case reflect.Int64, reflect.Int:
+		return DtInt64, true
+	case reflect.Uint32:
+		return DtUint32, true
+	case reflect.Uint64, reflect.Uint:
+		return DtUint64, true
+	case reflect.Float32:
+		return DtFloat32, true
+	case reflect.Float64:
+		return DtFloat64, true
+	case reflect.Bool:
+		return DtBool, true
+	case reflect.String:
+		return DtString, true
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return DtBytes, true
+		}
+	}
+	return DtNone, false
+}
+
+// structType gets the struct type, which is either the provided type or the
+// type it points to.
+func structType(t reflect.Type) (reflect.Type, error) {
+	if t == nil {
+		return nil, fmt.Errorf("dymessage: type is not a struct")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dymessage: type %v is not supported", t)
+	}
+	return t, nil
+}
+
+func getExtension(opt string, dt DataType) (func(*MessageFieldDef), error) {
+	var ext func(*MessageFieldDef)
+	var types []DataType
+	switch opt {
+	case "zigzag":
+		ext, types = protobuf.WithZigZag(), []DataType{DtInt32, DtInt64}
+	case "varint":
+		ext, types = protobuf.WithVarint(), []DataType{DtInt32, DtInt64, DtUint32, DtUint64}
+	default:
+		return nil, fmt.Errorf("unknown option %q", opt)
+	}
+	for _, t := range types {
+		if t == dt {
+			return ext, nil
+		}
+	}
+	return nil, fmt.Errorf("option %q is not applicable to the field", opt)
+}
+
+// getStructFields gets the fields of the struct, which must be bound to the
+// message fields, along with the names and tags of the message fields.
+func getStructFields(t reflect.Type) ([]structField, error) {
+	var fields []structField
+	tags := make(map[uint64]string)
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("dymessage")
+		if sf.PkgPath != "" || tag == "-" {
+			continue
+		}
+		f := structField{StructField: sf, name: sf.Name}
+		if ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				f.name = parts[0]
+			}
+			if len(parts) > 1 && parts[1] != "" {
+				n, err := strconv.ParseUint(parts[1], 10, 64)
+				if err != nil || n == 0 {
+					return nil, fmt.Errorf(
+						"dymessage: field %s of %v has invalid tag %q", sf.Name, t, parts[1])
+				}
+				f.tag = n
+			}
+			if len(parts) > 2 {
+				f.options = parts[2:]
+			}
+		}
+		if names[f.name] {
+			return nil, fmt.Errorf("dymessage: duplicate field name %q in %v", f.name, t)
+		}
+		names[f.name] = true
+		fields = append(fields, f)
+	}
+	// The fields without the explicit tags are numbered by their
+	// position among the bound fields.
+	for i := range fields {
+		if fields[i].tag == 0 {
+			fields[i].tag = uint64(i + 1)
+		}
+		f := &fields[i]
+		if name, ok := tags[f.tag]; ok {
+			return nil, fmt.Errorf(
+				"dymessage: fields %s and %s of %v have the same tag %d", name, f.Name, t, f.tag)
+		}
+		tags[f.tag] = f.Name
+	}
+	return fields, nil
+}
@@ -0,0 +1,166 @@
+package binding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+type (
+	testMessage struct {
+		Int32   int32 `dymessage:"int32,1,zigzag"`
+		Int64   int64 `dymessage:",2,varint"`
+		Uint32  uint32
+		Uint64  uint64
+		Float32 float32
+		Float64 float64
+		Bool    bool
+		String  string
+		Bytes   []byte
+		Int     int
+		Child   testChild
+		Ptr     *testMessage
+		Strings []string
+		Items   []*testChild
+		Blobs   [][]byte
+
+		Ignored  string `dymessage:"-"`
+		internal string
+	}
+
+	testChild struct {
+		Name   string  `dymessage:"name,1"`
+		Values []int32 `dymessage:"values,2"`
+	}
+)
+
+func TestDefine(t *testing.T) {
+	b := NewBinder(NewRegistryBuilder()).WithNamespace("koala.goshawk")
+	def, err := b.Define((*testMessage)(nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, "koala.goshawk", def.Namespace)
+	assert.Equal(t, "testMessage", def.Name)
+	require.Len(t, def.Fields, 15)
+
+	assert.Equal(t, "int32", def.GetField(1).Name)
+	assert.Equal(t, "Int64", def.GetField(2).Name)
+	assert.Equal(t, DtUint32, def.GetFieldByName("Uint32").DataType)
+	assert.Equal(t, uint64(3), def.GetFieldByName("Uint32").Tag)
+	assert.Equal(t, DtInt64, def.GetFieldByName("Int").DataType)
+	assert.Equal(t, DtBytes, def.GetFieldByName("Bytes").DataType)
+	assert.True(t, def.GetFieldByName("Strings").Repeated)
+	assert.True(t, def.GetFieldByName("Blobs").Repeated)
+	assert.Equal(t, DtBytes, def.GetFieldByName("Blobs").DataType)
+	assert.Equal(t, def.DataType, def.GetFieldByName("Ptr").DataType)
+
+	child := def.Registry.GetMessageDef(def.GetFieldByName("Child").DataType)
+	assert.Equal(t, "testChild", child.Name)
+	assert.Equal(t, child.DataType, def.GetFieldByName("Items").DataType)
+
+	// The definitions of the types are reused.
+	def2, err := b.Define(testChild{})
+	require.NoError(t, err)
+	assert.Equal(t, child, def2)
+}
+
+func TestDefineErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"not struct", 1},
+		{"unsupported type", struct{ A map[string]int }{}},
+		{"duplicate tag", struct {
+			A int32
+			B int32 `dymessage:",1"`
+		}{}},
+		{"duplicate name", struct {
+			A int32
+			B int32 `dymessage:"A"`
+		}{}},
+		{"invalid tag", struct {
+			A int32 `dymessage:",x"`
+		}{}},
+		{"invalid option", struct {
+			A string `dymessage:",1,zigzag"`
+		}{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewBinder(NewRegistryBuilder()).Define(test.v)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	def, err := NewBinder(NewRegistryBuilder()).Define(testMessage{})
+	require.NoError(t, err)
+
+	expected := testMessage{
+		Int32:   -1,
+		Int64:   -2,
+		Uint32:  3,
+		Uint64:  4,
+		Float32: 5.5,
+		Float64: 6.5,
+		Bool:    true,
+		String:  "string",
+		Bytes:   []byte{1, 2, 3},
+		Int:     7,
+		Child:   testChild{Name: "child", Values: []int32{1, 2}},
+		Ptr: &testMessage{
+			String: "nested",
+			Items:  []*testChild{{Name: "item"}, {}},
+		},
+		Strings: []string{"a", "b"},
+		Blobs:   [][]byte{{4}, {5, 6}},
+		Ignored: "ignored",
+	}
+	e, err := ToEntity(&expected, def)
+	require.NoError(t, err)
+
+	// Passing the entity through the protocol buffers encoding to make
+	// sure the extensions are applied properly.
+	data, err := protobuf.Encode(e, def)
+	require.NoError(t, err)
+	e, err = protobuf.DecodeNew(data, def)
+	require.NoError(t, err)
+
+	var actual testMessage
+	require.NoError(t, ToStruct(e, def, &actual))
+	expected.Ignored = ""
+	assert.Equal(t, expected, actual)
+}
+
+func TestCopyToCustomDef(t *testing.T) {
+	def := NewRegistryBuilder().ForMessageDef("child").
+		WithName("Child").
+		WithField("name", 10, DtString).
+		WithArrayField("values", 20, DtInt32).
+		WithField("extra", 30, DtBool).
+		Build()
+
+	e, err := ToEntity(testChild{Name: "name", Values: []int32{1}}, def)
+	require.NoError(t, err)
+	assert.Equal(t, "name", def.GetField(10).GetReference(e).ToString())
+	assert.Equal(t, 1, def.GetField(20).Len(e))
+
+	var actual testChild
+	require.NoError(t, ToStruct(e, def, &actual))
+	assert.Equal(t, testChild{Name: "name", Values: []int32{1}}, actual)
+
+	// The struct fields must be bound to the message fields.
+	_, err = ToEntity(struct{ Missing string }{}, def)
+	assert.Error(t, err)
+	_, err = ToEntity(struct {
+		Name int32 `dymessage:"name"`
+	}{}, def)
+	assert.Error(t, err)
+	assert.Error(t, ToStruct(e, def, actual))
+}
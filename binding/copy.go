@@ -0,0 +1,215 @@
+package binding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/helpers"
+)
+
+var errNotStructPtr = errors.New("dymessage: value must be a non-nil pointer to struct")
+
+// ToEntity creates an entity of the message definition from the struct, which
+// is provided either by value or by pointer. The struct fields are bound to
+// the message fields by names, so the message definition doesn't have to be
+// derived from the struct type, as long as it contains the fields of the
+// matching data types. The message fields, which are not bound to any of the
+// struct fields, are left with default values.
+func ToEntity(v interface{}, md *MessageDef) (*Entity, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errNotStructPtr
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("dymessage: type %v is not supported", rv.Type())
+	}
+	return toEntity(rv, md)
+}
+
+// ToStruct copies the values of the entity to the struct, which must be
+// provided by pointer. The struct fields are bound to the message fields by
+// names the same way as for the ToEntity function. If the entity type doesn't
+// correspond the data type of the message definition, the function will
+// panic.
+func ToStruct(e *Entity, md *MessageDef, v interface{}) error {
+	helpers.DataTypesMustMatch(e, md)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errNotStructPtr
+	}
+	return toStruct(e, md, rv.Elem())
+}
+
+// -----------------------------------------------------------------------------
+// Struct to entity
+
+func toEntity(v reflect.Value, md *MessageDef) (*Entity, error) {
+	sp, err := getStructPlan(v.Type(), md)
+	if err != nil {
+		return nil, err
+	}
+	e := md.NewEntity()
+	for i := range sp.fields {
+		fp := &sp.fields[i]
+		fv := v.Field(fp.index)
+		switch {
+		case fp.Repeated:
+			n := fv.Len()
+			if n == 0 {
+				continue
+			}
+			fp.Reserve(e, n)
+			for i := 0; i < n; i++ {
+				if fp.DataType.IsRefType() {
+					ref, err := fp.toReference(fv.Index(i))
+					if err != nil {
+						return nil, err
+					}
+					fp.SetReferenceAt(e, i, ref)
+				} else {
+					fp.SetPrimitiveAt(e, i, fp.toPrimitive(fv.Index(i)))
+				}
+			}
+		case fp.DataType.IsRefType():
+			ref, err := fp.toReference(fv)
+			if err != nil {
+				return nil, err
+			}
+			fp.SetReference(e, ref)
+		default:
+			fp.SetPrimitive(e, fp.toPrimitive(fv))
+		}
+	}
+	return e, nil
+}
+
+func (fp *fieldPlan) toPrimitive(v reflect.Value) Primitive {
+	switch fp.DataType {
+	case DtInt32:
+		return FromInt32(int32(v.Int()))
+	case DtInt64:
+		return FromInt64(v.Int())
+	case DtUint32:
+		return FromUint32(uint32(v.Uint()))
+	case DtUint64:
+		return FromUint64(v.Uint())
+	case DtFloat32:
+		return FromFloat32(float32(v.Float()))
+	case DtFloat64:
+		return FromFloat64(v.Float())
+	case DtBool:
+		return FromBool(v.Bool())
+	default:
+		panic(fmt.Sprintf("unexpected primitive data type %d", fp.DataType))
+	}
+}
+
+func (fp *fieldPlan) toReference(v reflect.Value) (ref Reference, err error) {
+	switch {
+	case fp.DataType == DtString:
+		ref = FromString(v.String())
+	case fp.DataType == DtBytes:
+		if !v.IsNil() {
+			ref = FromBytes(v.Bytes(), true)
+		}
+	case fp.ptr:
+		if !v.IsNil() {
+			var e *Entity
+			e, err = toEntity(v.Elem(), fp.def)
+			ref = FromEntity(e)
+		}
+	default:
+		var e *Entity
+		e, err = toEntity(v, fp.def)
+		ref = FromEntity(e)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Entity to struct
+
+func toStruct(e *Entity, md *MessageDef, v reflect.Value) error {
+	sp, err := getStructPlan(v.Type(), md)
+	if err != nil {
+		return err
+	}
+	for i := range sp.fields {
+		fp := &sp.fields[i]
+		fv := v.Field(fp.index)
+		switch {
+		case fp.Repeated:
+			n := fp.Len(e)
+			if n == 0 {
+				fv.Set(reflect.Zero(fv.Type()))
+				continue
+			}
+			items := reflect.MakeSlice(fv.Type(), n, n)
+			for i := 0; i < n; i++ {
+				if fp.DataType.IsRefType() {
+					if err := fp.setReference(items.Index(i), fp.GetReferenceAt(e, i)); err != nil {
+						return err
+					}
+				} else {
+					fp.setPrimitive(items.Index(i), fp.GetPrimitiveAt(e, i))
+				}
+			}
+			fv.Set(items)
+		case fp.DataType.IsRefType():
+			if err := fp.setReference(fv, fp.GetReference(e)); err != nil {
+				return err
+			}
+		default:
+			fp.setPrimitive(fv, fp.GetPrimitive(e))
+		}
+	}
+	return nil
+}
+
+func (fp *fieldPlan) setPrimitive(v reflect.Value, p Primitive) {
+	switch fp.DataType {
+	case DtInt32:
+		v.SetInt(int64(p.ToInt32()))
+	case DtInt64:
+		v.SetInt(p.ToInt64())
+	case DtUint32:
+		v.SetUint(uint64(p.ToUint32()))
+	case DtUint64:
+		v.SetUint(p.ToUint64())
+	case DtFloat32:
+		v.SetFloat(float64(p.ToFloat32()))
+	case DtFloat64:
+		v.SetFloat(p.ToFloat64())
+	case DtBool:
+		v.SetBool(p.ToBool())
+	default:
+		panic(fmt.Sprintf("unexpected primitive data type %d", fp.DataType))
+	}
+}
+
+func (fp *fieldPlan) setReference(v reflect.Value, ref Reference) error {
+	switch {
+	case ref.Entity == nil:
+		v.Set(reflect.Zero(v.Type()))
+	case fp.DataType == DtString:
+		v.SetString(ref.ToString())
+	case fp.DataType == DtBytes:
+		b := make([]byte, len(ref.ToBytes()))
+		copy(b, ref.ToBytes())
+		v.Set(reflect.ValueOf(b).Convert(v.Type()))
+	case fp.ptr:
+		p := reflect.New(fp.elem)
+		if err := toStruct(ref.ToEntity(), fp.def, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	default:
+		return toStruct(ref.ToEntity(), fp.def, v)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package binding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// The plans of copying the values between the struct types and the
+	// entities of a single message definition.
+	bindings struct {
+		plans sync.Map // A mapping from the struct type to its *structPlan
+	}
+
+	// A plan compiled once for a pair of the struct type and message
+	// definition. The plan provides the struct fields bound to the message
+	// fields, so the struct tags and the types of the fields are not
+	// inspected every time the values are copied.
+	structPlan struct {
+		fields []fieldPlan
+		err    error // An error occurred when compiling the plan
+	}
+
+	// A plan to copy the value of a single struct field.
+	fieldPlan struct {
+		*MessageFieldDef
+
+		index int // Index of the field in the struct
+
+		// The message definition and struct type of the nested
+		// entities, and a value indicating whether the struct is
+		// referenced by pointer. Not set for other fields.
+		def  *MessageDef
+		elem reflect.Type
+		ptr  bool
+	}
+)
+
+var planMarker PlanMarker
+
+func init() {
+	planMarker = RegisterPlan()
+}
+
+// getStructPlan gets the plan of copying the values between the struct type
+// and the entities of the message definition, compiling it if necessary.
+func getStructPlan(t reflect.Type, md *MessageDef) (*structPlan, error) {
+	b := md.GetPlan(planMarker, func(*MessageDef) interface{} {
+		return &bindings{}
+	}).(*bindings)
+	if sp, ok := b.plans.Load(t); ok {
+		return sp.(*structPlan), sp.(*structPlan).err
+	}
+	sp := compileStructPlan(t, md)
+	b.plans.Store(t, sp)
+	return sp, sp.err
+}
+
+func compileStructPlan(t reflect.Type, md *MessageDef) *structPlan {
+	fields, err := getStructFields(t)
+	if err != nil {
+		return &structPlan{err: err}
+	}
+	sp := &structPlan{fields: make([]fieldPlan, len(fields))}
+	for i, f := range fields {
+		if sp.fields[i], err = compileField(f, md); err != nil {
+			return &structPlan{err: fmt.Errorf("dymessage: field %s of %v: %v", f.Name, t, err)}
+		}
+	}
+	return sp
+}
+
+func compileField(f structField, md *MessageDef) (fp fieldPlan, err error) {
+	def, ok := md.TryGetFieldByName(f.name)
+	if !ok {
+		return fp, fmt.Errorf("message %s doesn't contain field %q", md.Name, f.name)
+	}
+	fp.MessageFieldDef, fp.index = def, f.Index[0]
+	t := f.Type
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		t = t.Elem()
+		if !def.Repeated {
+			return fp, fmt.Errorf("field %q is not repeated", def.Name)
+		}
+	} else if def.Repeated {
+		return fp, fmt.Errorf("field %q is repeated", def.Name)
+	}
+	if dt, ok := getPrimitiveDataType(t); ok {
+		if dt != def.DataType {
+			return fp, fmt.Errorf("type %v doesn't match the data type of field %q", t, def.Name)
+		}
+		return
+	}
+	if !def.DataType.IsEntity() {
+		return fp, fmt.Errorf("type %v doesn't match the data type of field %q", t, def.Name)
+	}
+	fp.ptr = t.Kind() == reflect.Ptr
+	if fp.elem, err = structType(t); err != nil {
+		return
+	}
+	fp.def = md.Registry.GetMessageDef(def.DataType)
+	return
+}
@@ -53,6 +53,14 @@ func (rb *RegistryBuilder) ForMessageDef(key interface{}) *MessageDefBuilder {
 	return def
 }
 
+// TryGetMessageDef gets the builder for the message definition, which
+// corresponds to the provided key, if the ForMessageDef method has already
+// been called with the key. Otherwise it returns the false flag.
+func (rb *RegistryBuilder) TryGetMessageDef(key interface{}) (*MessageDefBuilder, bool) {
+	def, ok := rb.defs[key]
+	return def, ok
+}
+
 // Build creates the registry. Any subsequent calls to the builder will lead to
 // undefined behavior.
 func (rb *RegistryBuilder) Build() *Registry {
@@ -106,6 +114,13 @@ func (mb *MessageDefBuilder) ExtendField(ext func(*MessageFieldDef)) *MessageDef
 	return mb
 }
 
+// ExtendMessage updates the message definition with an extension, which may
+// alter the way the messages are processed.
+func (mb *MessageDefBuilder) ExtendMessage(ext func(*MessageDef)) *MessageDefBuilder {
+	ext(mb.message)
+	return mb
+}
+
 func (mb *MessageDefBuilder) GetDataType() DataType { return mb.message.DataType }
 
 // Build builds the message definition. If not called, the Build method of the
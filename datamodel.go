@@ -17,6 +17,24 @@ type (
 
 		Data     []byte    // Memory for storing the primitive values
 		Entities []*Entity // The entities referenced from the current one
+
+		// The encoded data of the entity, which is decoded when the
+		// entity is accessed for the first time. Nil if the entity is
+		// not lazy or has already been decoded.
+		lazy *lazyEntity
+		// Indicates whether the data of the entity refers the memory
+		// the entity doesn't own, like a slice of the input of a
+		// decoder, so the data must not be overwritten when reused.
+		borrowed bool
+	}
+
+	// The encoded data of the lazy entity along with the function, which
+	// decodes the data into the entity. Once the decoding has failed, the
+	// decode function is nil and the error is kept.
+	lazyEntity struct {
+		data   []byte
+		decode func(data []byte, e *Entity) error
+		err    error
 	}
 
 	// A generic representation of the primitive values that provides
@@ -50,10 +68,107 @@ func GetDefaultReference() Reference { return Reference{} }
 // Reset resets the entity type and content, making it available for reuse.
 func (e *Entity) Reset() {
 	if e.DataType == DtNone {
+		if e.borrowed {
+			// The borrowed memory is dropped rather than reused.
+			e.Data, e.borrowed = nil, false
+		}
 		e.Data, e.Entities = e.Data[:0], e.Entities[:0]
 	}
 }
 
+// Borrow sets the data of the entity to the memory, which the entity doesn't
+// own, like a slice of the input of a decoder. The borrowed data is never
+// reused by the decoders, which drop it when the entity is reset.
+func (e *Entity) Borrow(data []byte) {
+	e.Data, e.borrowed = data, true
+}
+
+// Clone creates a deep copy of the entity, which shares neither the memory of
+// the primitive values nor the referenced entities with the original one.
+func (e *Entity) Clone() *Entity {
+	if e == nil {
+		return nil
+	}
+	clone := &Entity{DataType: e.DataType, lazy: e.lazy}
+	if e.Data != nil {
+		clone.Data = make([]byte, len(e.Data))
+		copy(clone.Data, e.Data)
+	}
+	if e.Entities != nil {
+		clone.Entities = make([]*Entity, len(e.Entities))
+		for i, nested := range e.Entities {
+			clone.Entities[i] = nested.Clone()
+		}
+	}
+	return clone
+}
+
+// -----------------------------------------------------------------------------
+// Lazy entities
+
+// SetLazy makes the entity lazy, so its content is decoded from the data by the
+// decode function when the entity is accessed for the first time with the
+// GetReference or GetReferenceAt methods of the message field, or when the Load
+// method is called. The entity must have the buffers reserved as if it was
+// created by the NewEntity method of the message definition. If the decode
+// function is nil, the entity stops being lazy, keeping its current content.
+//
+// Since reading the lazy entity modifies it, neither the lazy entity nor the
+// entities referring it are safe for concurrent reads until the entity is
+// loaded.
+func (e *Entity) SetLazy(data []byte, decode func(data []byte, e *Entity) error) {
+	if decode == nil {
+		e.lazy = nil
+	} else {
+		e.lazy = &lazyEntity{data: data, decode: decode}
+	}
+}
+
+// LazyData gets the encoded data of the lazy entity, which hasn't been decoded
+// yet or has failed to decode. The flag is false if the content of the entity
+// is available.
+func (e *Entity) LazyData() ([]byte, bool) {
+	if e.lazy == nil {
+		return nil, false
+	}
+	return e.lazy.data, true
+}
+
+// Load decodes the content of the lazy entity if it hasn't been decoded yet and
+// returns the error of the decoding if any. If the decoding fails, the entity
+// remains lazy and the subsequent calls return the same error. The method does
+// nothing for the entities, which are not lazy. The nested lazy entities are
+// not loaded by the method, and the method must not be called concurrently
+// with the reads of the entity.
+func (e *Entity) Load() error {
+	l := e.lazy
+	if l == nil {
+		return nil
+	}
+	if l.decode == nil {
+		return l.err
+	}
+	// The entity stops being lazy before it's decoded, so the decoder
+	// could access the entity as a regular one.
+	e.lazy = nil
+	if err := l.decode(l.data, e); err != nil {
+		e.lazy = &lazyEntity{data: l.data, err: err}
+		return err
+	}
+	return nil
+}
+
+// mustLoad decodes the content of the lazy entity, panicking if the entity
+// cannot be decoded.
+func mustLoad(e *Entity) *Entity {
+	if e != nil && e.lazy != nil {
+		if err := e.Load(); err != nil {
+			panic(err)
+		}
+	}
+	return e
+}
+
 // -----------------------------------------------------------------------------
 // Primitive value conversions
 
@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// Apply applies the changes to the entity of the message definition in order,
+// turning the entity the changes have been produced from into the one they
+// have been produced to. The old values of the changes must match the values
+// of the entity, otherwise the function returns an error. If an error occurs,
+// the changes preceding the failed one remain applied. The entity shares the
+// values of reference types with the changes.
+func Apply(e *Entity, changes Changes, md *MessageDef) error {
+	for _, c := range changes {
+		if err := applyChange(e, c, md); err != nil {
+			return fmt.Errorf("dymessage: cannot apply change of %s: %v", c.Path, err)
+		}
+	}
+	return nil
+}
+
+func applyChange(e *Entity, c Change, md *MessageDef) error {
+	if len(c.Path) == 0 {
+		return fmt.Errorf("path is empty")
+	}
+	// Getting the entity, which holds the field the change applies to.
+	for _, s := range c.Path[:len(c.Path)-1] {
+		if !s.Field.DataType.IsEntity() {
+			return fmt.Errorf("field %s is not a nested entity", s.Field.Name)
+		}
+		if s.Index >= 0 {
+			if s.Index >= s.Field.Len(e) {
+				return fmt.Errorf("item %d of field %s doesn't exist", s.Index, s.Field.Name)
+			}
+			e = s.Field.GetReferenceAt(e, s.Index).Entity
+		} else {
+			e = s.Field.GetReference(e).Entity
+		}
+		if e == nil {
+			return fmt.Errorf("field %s is null", s.Field.Name)
+		}
+		md = md.Registry.GetMessageDef(s.Field.DataType)
+	}
+	last := c.Path[len(c.Path)-1]
+	f := last.Field
+	if f.Repeated != (last.Index >= 0) {
+		return fmt.Errorf("index of item doesn't match field %s", f.Name)
+	}
+	n := 0
+	if f.Repeated {
+		n = f.Len(e)
+	}
+	switch c.Kind {
+	case Set:
+		if f.Repeated {
+			if last.Index >= n {
+				return fmt.Errorf("item %d doesn't exist", last.Index)
+			}
+			if !equalValues(getItem(e, f, last.Index), c.Old, f, md) {
+				return fmt.Errorf("value has been changed")
+			}
+			setItem(e, f, last.Index, c.New)
+		} else {
+			if !equalValues(getValue(e, f), c.Old, f, md) {
+				return fmt.Errorf("value has been changed")
+			}
+			setValue(e, f, c.New)
+		}
+	case Insert:
+		if last.Index > n {
+			return fmt.Errorf("item %d cannot be inserted into %d items", last.Index, n)
+		}
+		f.Insert(e, last.Index, 1)
+		setItem(e, f, last.Index, c.New)
+	case Delete:
+		if last.Index >= n {
+			return fmt.Errorf("item %d doesn't exist", last.Index)
+		}
+		if !equalValues(getItem(e, f, last.Index), c.Old, f, md) {
+			return fmt.Errorf("value has been changed")
+		}
+		f.Remove(e, last.Index, 1)
+	default:
+		return fmt.Errorf("unknown kind of change %d", c.Kind)
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func setValue(e *Entity, f *MessageFieldDef, v Value) {
+	if f.DataType.IsRefType() {
+		f.SetReference(e, v.Reference)
+	} else {
+		f.SetPrimitive(e, v.Primitive)
+	}
+}
+
+func setItem(e *Entity, f *MessageFieldDef, n int, v Value) {
+	if f.DataType.IsRefType() {
+		f.SetReferenceAt(e, n, v.Reference)
+	} else {
+		f.SetPrimitiveAt(e, n, v.Primitive)
+	}
+}
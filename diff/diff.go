@@ -0,0 +1,268 @@
+// Package diff finds the differences between two entities of the same message
+// definition and replays them against other entities.
+//
+// The changes are produced for the individual fields of the entity and of its
+// nested entities, so changing a field of a nested entity doesn't produce a
+// change of the whole nested entity. The items of the repeated fields are
+// matched by their values, so the changes include the items, which have been
+// inserted or deleted, while the pairs of the items of entity types, which
+// replace each other, are compared field by field.
+package diff
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// The kind of a change.
+	ChangeKind int
+
+	// A single change of a value of the entity.
+	Change struct {
+		Kind ChangeKind
+		// The path to the changed value. For the changes of the items
+		// of the repeated fields the last step of the path holds the
+		// index of the item.
+		Path Path
+		// The value before and after the change. Not set for the
+		// insertions and deletions respectively.
+		Old, New Value
+	}
+
+	// A single value of the field. Depending on the data type of the
+	// field, either the primitive value or the reference is set.
+	Value struct {
+		Primitive Primitive
+		Reference Reference
+	}
+
+	// A path to the value of the entity, which starts at the field of the
+	// entity and goes through the nested entities.
+	Path []Step
+
+	// A step of the path, which represents either the field of the entity
+	// or an item of the repeated field.
+	Step struct {
+		Field *MessageFieldDef
+		Index int // The index of the item, or -1 if not an item
+	}
+
+	// A sequence of changes, which turns one entity into another when the
+	// changes are applied in order.
+	Changes []Change
+)
+
+const (
+	Set    ChangeKind = iota // The value has been replaced with a new one
+	Insert                   // The item has been inserted into the repeated field
+	Delete                   // The item has been deleted from the repeated field
+)
+
+// Diff gets the changes, which turn the entity a into the entity b of the same
+// message definition. The entities must not be nil. If the entities are equal,
+// the result is empty.
+func Diff(a, b *Entity, md *MessageDef) Changes {
+	var d differ
+	d.diffEntities(a, b, md)
+	return d.changes
+}
+
+func (p Path) String() string {
+	var sb strings.Builder
+	for i, s := range p {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(s.Field.Name)
+		if s.Index >= 0 {
+			sb.WriteByte('[')
+			sb.WriteString(strconv.Itoa(s.Index))
+			sb.WriteByte(']')
+		}
+	}
+	return sb.String()
+}
+
+// Sprint gets a human-readable representation of the changes of the entities of
+// the message definition, writing each change on its own line:
+//
+//	~ name: "Alice" -> "Alicia"
+//	+ tags[1]: "vip"
+//	- address.lines[0]: "Main St."
+func (cs Changes) Sprint(md *MessageDef, opts ...PrintOption) string {
+	var sb strings.Builder
+	for _, c := range cs {
+		// Getting the definition of the entity, which holds the field
+		// the values of which are printed.
+		def := md
+		for _, s := range c.Path[:len(c.Path)-1] {
+			def = def.Registry.GetMessageDef(s.Field.DataType)
+		}
+		f := c.Path[len(c.Path)-1].Field
+		switch c.Kind {
+		case Set:
+			sb.WriteString("~ ")
+		case Insert:
+			sb.WriteString("+ ")
+		case Delete:
+			sb.WriteString("- ")
+		}
+		sb.WriteString(c.Path.String())
+		sb.WriteString(": ")
+		if c.Kind != Insert {
+			sb.WriteString(SprintValue(c.Old.Primitive, c.Old.Reference, f, def, opts...))
+		}
+		if c.Kind == Set {
+			sb.WriteString(" -> ")
+		}
+		if c.Kind != Delete {
+			sb.WriteString(SprintValue(c.New.Primitive, c.New.Reference, f, def, opts...))
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+type differ struct {
+	path    Path
+	changes Changes
+}
+
+func (d *differ) diffEntities(a, b *Entity, md *MessageDef) {
+	for _, f := range md.Fields {
+		d.path = append(d.path, Step{Field: f, Index: -1})
+		if f.Repeated {
+			d.diffRepeated(a, b, f, md)
+		} else {
+			va, vb := getValue(a, f), getValue(b, f)
+			d.diffValues(va, vb, f, md)
+		}
+		d.path = d.path[:len(d.path)-1]
+	}
+}
+
+// diffValues produces the changes of the value at the current path, going into
+// the nested entities if both of them are set.
+func (d *differ) diffValues(a, b Value, f *MessageFieldDef, md *MessageDef) {
+	if f.DataType.IsEntity() && a.Reference.Entity != nil && b.Reference.Entity != nil {
+		d.diffEntities(a.Reference.Entity, b.Reference.Entity, md.Registry.GetMessageDef(f.DataType))
+	} else if !equalValues(a, b, f, md) {
+		d.add(Set, a, b)
+	}
+}
+
+// diffRepeated produces the changes of the items of the repeated field, which
+// are found from the longest common subsequence of the items. The changes are
+// ordered and indexed, so they could be applied one after another.
+func (d *differ) diffRepeated(a, b *Entity, f *MessageFieldDef, md *MessageDef) {
+	n, m := f.Len(a), f.Len(b)
+	equal := func(i, j int) bool {
+		return equalValues(getItem(a, f, i), getItem(b, f, j), f, md)
+	}
+	// Skipping the common prefix and suffix, which are typical for the
+	// small changes of long collections.
+	prefix := 0
+	for prefix < n && prefix < m && equal(prefix, prefix) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && equal(n-suffix-1, m-suffix-1) {
+		suffix++
+	}
+	// The lengths of the longest common subsequences of the remaining
+	// items, starting at the positions of both collections.
+	rn, rm := n-prefix-suffix, m-prefix-suffix
+	lcs := make([][]int, rn+1)
+	for i := range lcs {
+		lcs[i] = make([]int, rm+1)
+	}
+	for i := rn - 1; i >= 0; i-- {
+		for j := rm - 1; j >= 0; j-- {
+			if equal(prefix+i, prefix+j) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	// Walking the collections, where pos is the index of the item in the
+	// collection with the changes applied so far. The runs of the deleted
+	// and inserted items are collected until the next common item.
+	pos := prefix
+	var deleted, inserted []int
+	flush := func() {
+		// The items, which replace each other, produce the changes
+		// of the items rather than the deletions and insertions.
+		k := 0
+		for ; k < len(deleted) && k < len(inserted); k++ {
+			d.path[len(d.path)-1].Index = pos
+			d.diffValues(getItem(a, f, deleted[k]), getItem(b, f, inserted[k]), f, md)
+			pos++
+		}
+		d.path[len(d.path)-1].Index = pos
+		for _, i := range deleted[k:] {
+			d.add(Delete, getItem(a, f, i), Value{})
+		}
+		for _, j := range inserted[k:] {
+			d.path[len(d.path)-1].Index = pos
+			d.add(Insert, Value{}, getItem(b, f, j))
+			pos++
+		}
+		deleted, inserted = deleted[:0], inserted[:0]
+	}
+	for i, j := 0, 0; i < rn || j < rm; {
+		switch {
+		case i < rn && j < rm && equal(prefix+i, prefix+j):
+			flush()
+			i, j, pos = i+1, j+1, pos+1
+		case j == rm || (i < rn && lcs[i+1][j] >= lcs[i][j+1]):
+			deleted = append(deleted, prefix+i)
+			i++
+		default:
+			inserted = append(inserted, prefix+j)
+			j++
+		}
+	}
+	flush()
+	d.path[len(d.path)-1].Index = -1
+}
+
+func (d *differ) add(kind ChangeKind, a, b Value) {
+	path := make(Path, len(d.path))
+	copy(path, d.path)
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Old: a, New: b})
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func getValue(e *Entity, f *MessageFieldDef) Value {
+	if f.DataType.IsRefType() {
+		return Value{Reference: f.GetReference(e)}
+	}
+	return Value{Primitive: f.GetPrimitive(e)}
+}
+
+func getItem(e *Entity, f *MessageFieldDef, n int) Value {
+	if f.DataType.IsRefType() {
+		return Value{Reference: f.GetReferenceAt(e, n)}
+	}
+	return Value{Primitive: f.GetPrimitiveAt(e, n)}
+}
+
+// equalValues gets a value indicating whether the values of the field, which
+// belongs to the message definition, are equal.
+func equalValues(a, b Value, f *MessageFieldDef, md *MessageDef) bool {
+	if f.DataType.IsRefType() {
+		return EqualReferences(a.Reference, b.Reference, f.DataType, md.Registry)
+	}
+	return a.Primitive == b.Primitive
+}
@@ -0,0 +1,76 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/json"
+)
+
+func decode(t *testing.T, s string, def *MessageDef) *Entity {
+	e, err := json.DecodeNew([]byte(s), def)
+	require.NoError(t, err)
+	return e
+}
+
+func TestDiff(t *testing.T) {
+	def := ArrangeOrder()
+	a := decode(t, `{"name":"Alice","total":10,"paid":false,"tags":["a","b","c","d"],"codes":[1,2,3],`+
+		`"lines":[{"sku":"x","quantity":1},{"sku":"y","quantity":2}],"address":{"city":"Oslo","zip":150},"data":"AQI="}`, def)
+	b := decode(t, `{"name":"Alicia","total":10,"paid":true,"tags":["a","c","e","d","f"],"codes":[3,4],`+
+		`"lines":[{"sku":"x","quantity":5},{"sku":"y","quantity":2},{"sku":"z"}],"billing":{"city":"Bergen"},"data":"AQI="}`, def)
+
+	changes := Diff(a, b, def)
+	assert.Equal(t, `~ name: "Alice" -> "Alicia"
+~ paid: false -> true
+- tags[1]: "b"
++ tags[2]: "e"
++ tags[4]: "f"
+- codes[0]: 1
+- codes[0]: 2
++ codes[1]: 4
+~ lines[0].quantity: 1 -> 5
++ lines[2]: Line{sku: "z", quantity: 0, address: <nil>}
+~ address: Address{city: "Oslo", zip: 150} -> <nil>
+~ billing: <nil> -> Address{city: "Bergen", zip: 0}
+`, changes.Sprint(def))
+	assert.Equal(t, "lines[0].quantity", changes[8].Path.String())
+
+	require.NoError(t, Apply(a, changes, def))
+	assert.True(t, Equal(a, b, def))
+	assert.Empty(t, Diff(a, b, def))
+}
+
+func TestDiffReplaced(t *testing.T) {
+	def := ArrangeOrder()
+	a := decode(t, `{"codes":[1,2,3],"lines":[{"sku":"x"},{"sku":"y"}]}`, def)
+	b := decode(t, `{"codes":[1,5,3],"lines":[{"sku":"z"}]}`, def)
+	changes := Diff(a, b, def)
+	assert.Equal(t, `~ codes[1]: 2 -> 5
+~ lines[0].sku: "x" -> "z"
+- lines[1]: Line{sku: "y", quantity: 0, address: <nil>}
+`, changes.Sprint(def))
+	require.NoError(t, Apply(a, changes, def))
+	assert.True(t, Equal(a, b, def))
+}
+
+func TestApplyConflict(t *testing.T) {
+	def := ArrangeOrder()
+	a := decode(t, `{"name":"Alice","tags":["a"]}`, def)
+	b := decode(t, `{"name":"Bob","tags":[]}`, def)
+	changes := Diff(a, b, def)
+
+	c := decode(t, `{"name":"Carol","tags":["a"]}`, def)
+	err := Apply(c, changes, def)
+	assert.EqualError(t, err, "dymessage: cannot apply change of name: value has been changed")
+
+	c = decode(t, `{"name":"Alice"}`, def)
+	err = Apply(c, changes, def)
+	assert.EqualError(t, err, "dymessage: cannot apply change of tags[0]: item 0 doesn't exist")
+	// The changes preceding the failed one remain applied.
+	assert.Equal(t, "Bob", def.GetFieldByName("name").GetReference(c).ToString())
+}
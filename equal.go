@@ -0,0 +1,54 @@
+package dymessage
+
+import "bytes"
+
+// Equal gets a value indicating whether the entities of the message definition
+// are equal, comparing the values of their fields and the nested entities. The
+// strings and bytes, which are null, are equal to the empty ones.
+func Equal(a, b *Entity, md *MessageDef) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	for _, f := range md.Fields {
+		if !EqualField(a, b, f, md) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualField gets a value indicating whether the values of the field in two
+// entities of the message definition are equal. The repeated fields are equal
+// if they have the same items in the same order.
+func EqualField(a, b *Entity, f *MessageFieldDef, md *MessageDef) bool {
+	if !f.Repeated {
+		if f.DataType.IsRefType() {
+			return EqualReferences(f.GetReference(a), f.GetReference(b), f.DataType, md.Registry)
+		}
+		return f.GetPrimitive(a) == f.GetPrimitive(b)
+	}
+	n := f.Len(a)
+	if n != f.Len(b) {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if f.DataType.IsRefType() {
+			if !EqualReferences(f.GetReferenceAt(a, i), f.GetReferenceAt(b, i), f.DataType, md.Registry) {
+				return false
+			}
+		} else if f.GetPrimitiveAt(a, i) != f.GetPrimitiveAt(b, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualReferences gets a value indicating whether the reference values of the
+// data type are equal. The message definitions of the nested entities are
+// looked up in the registry.
+func EqualReferences(a, b Reference, dt DataType, r *Registry) bool {
+	if dt.IsEntity() {
+		return Equal(a.Entity, b.Entity, r.GetMessageDef(dt))
+	}
+	return bytes.Equal(a.ToBytes(), b.ToBytes())
+}
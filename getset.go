@@ -23,13 +23,18 @@ func (f *MessageFieldDef) SetPrimitiveAt(e *Entity, n int, value Primitive) {
 	f.setPrimitive(data, f.DataType.GetWidthInBytes()*n, value)
 }
 
+// GetReference gets the reference value of the field. If the value is a lazy
+// entity, it gets decoded, and the method panics if the entity cannot be
+// decoded. Call the Load method of the entity beforehand to handle the error.
 func (f *MessageFieldDef) GetReference(e *Entity) Reference {
-	return Reference{e.Entities[f.Offset]}
+	return Reference{mustLoad(e.Entities[f.Offset])}
 }
 
+// GetReferenceAt gets the reference value of the item of the repeated field.
+// The lazy entities are decoded just like by the GetReference method.
 func (f *MessageFieldDef) GetReferenceAt(e *Entity, n int) Reference {
 	data := e.Entities[f.Offset]
-	return Reference{data.Entities[n]}
+	return Reference{mustLoad(data.Entities[n])}
 }
 
 func (f *MessageFieldDef) SetReference(e *Entity, value Reference) {
@@ -61,6 +66,44 @@ func (f *MessageFieldDef) Reserve(e *Entity, count int) int {
 	}
 }
 
+// Insert inserts a room for specified number of items into the repeated message
+// field at position n, shifting the following items. The inserted items are
+// set to default values.
+func (f *MessageFieldDef) Insert(e *Entity, n, count int) {
+	total := f.Reserve(e, count)
+	data := e.Entities[f.Offset]
+	if f.DataType.IsRefType() {
+		copy(data.Entities[n+count:], data.Entities[n:total])
+		for i := n; i < n+count; i++ {
+			data.Entities[i] = nil
+		}
+	} else {
+		sz := f.DataType.GetWidthInBytes()
+		copy(data.Data[(n+count)*sz:], data.Data[n*sz:total*sz])
+		for i := n * sz; i < (n+count)*sz; i++ {
+			data.Data[i] = 0
+		}
+	}
+}
+
+// Remove removes specified number of items of the repeated message field
+// starting at position n, shifting the following items.
+func (f *MessageFieldDef) Remove(e *Entity, n, count int) {
+	data := e.Entities[f.Offset]
+	if f.DataType.IsRefType() {
+		total := len(data.Entities)
+		copy(data.Entities[n:], data.Entities[n+count:])
+		for i := total - count; i < total; i++ {
+			data.Entities[i] = nil
+		}
+		data.Entities = data.Entities[:total-count]
+	} else {
+		sz := f.DataType.GetWidthInBytes()
+		copy(data.Data[n*sz:], data.Data[(n+count)*sz:])
+		data.Data = data.Data[:len(data.Data)-count*sz]
+	}
+}
+
 func (f *MessageFieldDef) Len(e *Entity) int {
 	data := e.Entities[f.Offset]
 	if data == nil {
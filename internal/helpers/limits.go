@@ -0,0 +1,69 @@
+package helpers
+
+import (
+	"fmt"
+
+	"github.com/umk/go-dymessage"
+)
+
+// The maximum nesting depth of entities the decoders accept by default.
+const DefaultMaxDepth = 10000
+
+// Limits restricts the amount of resources the decoders may consume while
+// processing an untrusted input. A limit, which is zero or negative, means no
+// restriction.
+type Limits struct {
+	MaxDepth        int // The maximum nesting depth of entities
+	MaxBytes        int // The maximum size of the input in bytes
+	MaxRepeated     int // The maximum number of items in a repeated field
+	MaxStringLength int // The maximum length of a string or bytes value
+}
+
+// DefaultLimits gets the limits the decoders apply unless configured
+// otherwise.
+func DefaultLimits() Limits {
+	return Limits{MaxDepth: DefaultMaxDepth}
+}
+
+// CheckBytes checks whether the input of specified size can be decoded.
+func (l *Limits) CheckBytes(n int) error {
+	if l.MaxBytes > 0 && n > l.MaxBytes {
+		return fmt.Errorf(
+			"dymessage: input of %d bytes exceeds the maximum size of %d bytes",
+			n, l.MaxBytes)
+	}
+	return nil
+}
+
+// CheckDepth checks whether the entity at specified nesting depth can be
+// decoded. The depth of the root entity is one.
+func (l *Limits) CheckDepth(depth int) error {
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return fmt.Errorf(
+			"dymessage: entities are nested deeper than the maximum depth of %d",
+			l.MaxDepth)
+	}
+	return nil
+}
+
+// CheckRepeated checks whether the repeated field may contain specified number
+// of items.
+func (l *Limits) CheckRepeated(f *dymessage.MessageFieldDef, n int) error {
+	if l.MaxRepeated > 0 && n > l.MaxRepeated {
+		return fmt.Errorf(
+			"dymessage: field %q contains more than the maximum of %d items",
+			f.Name, l.MaxRepeated)
+	}
+	return nil
+}
+
+// CheckString checks whether the string or bytes field may contain a value of
+// specified length.
+func (l *Limits) CheckString(f *dymessage.MessageFieldDef, n int) error {
+	if l.MaxStringLength > 0 && n > l.MaxStringLength {
+		return fmt.Errorf(
+			"dymessage: field %q contains a value of %d bytes, which exceeds the maximum length of %d bytes",
+			f.Name, n, l.MaxStringLength)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Package lexer splits the sources of the expressions and the filters into the
+// tokens and provides the helpers for parsing them.
+package lexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// A single token of the source.
+	Token struct {
+		Kind  Kind
+		Text  string
+		Start int // The offset of the token in the source
+	}
+
+	Kind int
+
+	// An error at the position of the source.
+	Error struct {
+		Pos     int    // The position in the source, starting from 1
+		Message string // The description of the error
+	}
+
+	// Enumerates the tokens of the source while parsing it.
+	Scanner struct {
+		tokens []Token
+		i      int
+		// Describes the end of the source in the errors, like "end of
+		// expression".
+		eof string
+	}
+)
+
+const (
+	Eof Kind = iota
+	Number
+	String
+	Ident
+	Operator
+)
+
+// NewError creates an error at the offset of the source.
+func NewError(offset int, format string, a ...interface{}) *Error {
+	return &Error{Pos: offset + 1, Message: fmt.Sprintf(format, a...)}
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("dymessage: (%d): %s", err.Pos, err.Message)
+}
+
+// -----------------------------------------------------------------------------
+// Tokenizer
+
+// Tokenize splits the source into the identifiers, the numbers with optional
+// fractional parts and exponents, the quoted strings and the operators, which
+// must be ordered so that the longer ones go first. The last token is always
+// of the Eof kind.
+func Tokenize(src string, operators []string) (tokens []Token, err error) {
+	i := 0
+	for {
+		for i < len(src) && strings.IndexByte(" \t\r\n", src[i]) >= 0 {
+			i++
+		}
+		if i == len(src) {
+			tokens = append(tokens, Token{Kind: Eof, Start: i})
+			return
+		}
+		start, c := i, src[i]
+		switch {
+		case isIdentStart(c):
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, Token{Ident, src[start:i], start})
+		case isDigit(c):
+			for ; i < len(src) && (isDigit(src[i]) || strings.IndexByte(".eE", src[i]) >= 0); i++ {
+				if (src[i] == 'e' || src[i] == 'E') && i+1 < len(src) && strings.IndexByte("+-", src[i+1]) >= 0 {
+					i++
+				}
+			}
+			tokens = append(tokens, Token{Number, src[start:i], start})
+		case c == '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(src) {
+				return nil, NewError(start, "unterminated string")
+			}
+			i++
+			tokens = append(tokens, Token{String, src[start:i], start})
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, NewError(start, "unexpected character %q", c)
+			}
+			i += len(op)
+			tokens = append(tokens, Token{Operator, op, start})
+		}
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// -----------------------------------------------------------------------------
+// Scanner
+
+// NewScanner tokenizes the source for being parsed. The eof parameter describes
+// the end of the source in the errors.
+func NewScanner(src string, operators []string, eof string) (*Scanner, error) {
+	tokens, err := Tokenize(src, operators)
+	if err != nil {
+		return nil, err
+	}
+	return &Scanner{tokens: tokens, eof: eof}, nil
+}
+
+// Peek gets the current token without moving to the next one.
+func (s *Scanner) Peek() Token { return s.tokens[s.i] }
+
+// Prev gets the token, which precedes the current one.
+func (s *Scanner) Prev() Token { return s.tokens[s.i-1] }
+
+// Next gets the current token and moves to the next one, unless the end of the
+// source has been reached.
+func (s *Scanner) Next() Token {
+	t := s.tokens[s.i]
+	if t.Kind != Eof {
+		s.i++
+	}
+	return t
+}
+
+// TryOperator moves to the next token if the current one is one of specified
+// operators.
+func (s *Scanner) TryOperator(ops ...string) (Token, bool) {
+	t := s.Peek()
+	if t.Kind == Operator {
+		for _, op := range ops {
+			if t.Text == op {
+				s.i++
+				return t, true
+			}
+		}
+	}
+	return t, false
+}
+
+// AcceptOperator moves to the next token if the current one is specified
+// operator, or returns an error otherwise.
+func (s *Scanner) AcceptOperator(op string) error {
+	if t, ok := s.TryOperator(op); !ok {
+		return NewError(t.Start, "expected %q, but got %s", op, s.Describe(t))
+	}
+	return nil
+}
+
+// TryKeyword moves to the next token if the current one is specified keyword,
+// which is matched regardless of the case.
+func (s *Scanner) TryKeyword(kw string) bool {
+	if IsKeyword(s.Peek(), kw) {
+		s.i++
+		return true
+	}
+	return false
+}
+
+// AcceptKeyword moves to the next token if the current one is specified
+// keyword, or returns an error otherwise.
+func (s *Scanner) AcceptKeyword(kw string) error {
+	if t := s.Peek(); !s.TryKeyword(kw) {
+		return NewError(t.Start, "expected %s, but got %s", kw, s.Describe(t))
+	}
+	return nil
+}
+
+// Unexpected gets the error, which reports the unexpected token.
+func (s *Scanner) Unexpected(t Token) error {
+	return NewError(t.Start, "unexpected %s", s.Describe(t))
+}
+
+// Describe gets the description of the token for the errors.
+func (s *Scanner) Describe(t Token) string {
+	if t.Kind == Eof {
+		return s.eof
+	}
+	return strconv.Quote(t.Text)
+}
+
+// IsKeyword gets a value indicating whether the token is specified keyword,
+// which is matched regardless of the case.
+func IsKeyword(t Token, kw string) bool {
+	return t.Kind == Ident && strings.EqualFold(t.Text, kw)
+}
+
+// Sign gets -1, 1 or 0 depending on whether the first value is less than,
+// greater than or equal to the second one.
+func Sign(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -150,6 +150,38 @@ func ArrangeEncodeDecode() (*MessageDef, *Entity) {
 	return def, entity
 }
 
+// ArrangeOrder creates the definition of an order with the lines and the
+// addresses, which covers the regular and repeated fields of the primitive
+// types and the entities nested into the entities and the items.
+func ArrangeOrder() *MessageDef {
+	rb := NewRegistryBuilder()
+	address := rb.ForMessageDef("address").
+		WithName("Address").
+		WithField("city", 1, DtString).
+		WithField("zip", 2, DtUint32).
+		Build()
+	line := rb.ForMessageDef("line").
+		WithName("Line").
+		WithField("sku", 1, DtString).
+		WithField("quantity", 2, DtInt32).
+		WithField("address", 3, address.DataType).
+		Build()
+	def := rb.ForMessageDef("order").
+		WithName("Order").
+		WithField("name", 1, DtString).
+		WithField("total", 2, DtFloat64).
+		WithField("paid", 3, DtBool).
+		WithArrayField("tags", 4, DtString).
+		WithArrayField("codes", 5, DtInt64).
+		WithArrayField("lines", 6, line.DataType).
+		WithField("address", 7, address.DataType).
+		WithField("billing", 8, address.DataType).
+		WithField("data", 9, DtBytes).
+		Build()
+	rb.Build()
+	return def
+}
+
 func AssertEncodeDecode(t *testing.T, def *MessageDef, entity *Entity) {
 	ref := def.GetField(TagRegEntity).GetReference(entity)
 	require.NotNil(t, ref)
@@ -18,6 +18,16 @@ func BenchmarkTestEncodeRegular(b *testing.B) {
 			assert.NoError(b, err)
 		}
 	})
+
+	// The codec plans get compiled on every iteration, which shows how
+	// much is saved by caching them.
+	b.Run("encode regular uncached plans", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			def.Registry.ResetPlans()
+			_, err := Encode(entity, def)
+			assert.NoError(b, err)
+		}
+	})
 }
 
 func BenchmarkTestDecodeRegular(b *testing.B) {
@@ -31,6 +41,14 @@ func BenchmarkTestDecodeRegular(b *testing.B) {
 			assert.NoError(b, err)
 		}
 	})
+
+	b.Run("decode regular uncached plans", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			def.Registry.ResetPlans()
+			_, err := DecodeNew(data, def)
+			assert.NoError(b, err)
+		}
+	})
 }
 
 // BenchmarkReference explores other options to parse the JSON document.
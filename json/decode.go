@@ -3,7 +3,6 @@ package json
 import (
 	"encoding/base64"
 	"errors"
-	"strconv"
 
 	. "github.com/umk/go-dymessage"
 	"github.com/umk/go-dymessage/json/internal/impl"
@@ -11,29 +10,42 @@ import (
 
 type decoder struct {
 	lx impl.Lexer
+	// The options of decoding and the nesting depth of the value
+	// currently being decoded.
+	opts  decodeOptions
+	depth int
 }
 
 // DecodeNew transforms the JSON representation of the message to dynamic entity
 // against the provided message definition.
-func DecodeNew(b []byte, pd *MessageDef) (e *Entity, err error) {
-	var dc decoder
+func DecodeNew(b []byte, pd *MessageDef, opts ...DecodeOption) (e *Entity, err error) {
+	dc := decoder{opts: newDecodeOptions(opts)}
+	if err = dc.opts.limits.CheckBytes(len(b)); err != nil {
+		return
+	}
 	dc.lx.Reset(b)
 	dc.lx.Next()
-	if e, err = dc.decode(pd); err == nil {
+	if e, err = dc.decodeMessage(pd); err == nil {
 		if !dc.lx.Eof() {
 			message := dc.createErrorMessage(impl.TkEof)
 			err = errors.New(message)
+		} else if dc.opts.validate != nil {
+			err = dc.opts.validate(e, pd)
 		}
 	}
 	return
 }
 
 func (dc *decoder) decode(pd *MessageDef) (r *Entity, err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
 	if err = dc.accept(impl.TkCrBrOpen); err != nil {
 		return
 	}
 	r = pd.NewEntity()
 	if dc.tryAccept(impl.TkCrBrClose) {
+		dc.leave()
 		return
 	}
 	for {
@@ -45,6 +57,7 @@ func (dc *decoder) decode(pd *MessageDef) (r *Entity, err error) {
 		}
 	}
 	err = dc.accept(impl.TkCrBrClose)
+	dc.leave()
 	return
 }
 
@@ -56,19 +69,21 @@ func (dc *decoder) decodeProperty(r *Entity, pd *MessageDef) (err error) {
 	if err = dc.accept(impl.TkColon); err != nil {
 		return
 	}
-	f, ok := pd.TryGetFieldByName(name)
+	p := getPlan(pd)
+	i, ok := p.byName[name]
 	if !ok {
 		err = dc.ignoreValue()
 		return
 	}
-	if f.Repeated {
+	fp := &p.fields[i]
+	if fp.Repeated {
 		if dc.tryAccept(impl.TkNull) {
 			// Do nothing but leave default value in the entity field.
-		} else if err = dc.decodeRepeated(r, pd, f); err != nil {
+		} else if err = dc.decodeRepeated(r, pd, fp); err != nil {
 			return
 		}
 	} else {
-		if err = dc.decodeSingle(r, pd, f); err != nil {
+		if err = dc.decodeSingle(r, pd, fp); err != nil {
 			return
 		}
 	}
@@ -76,25 +91,25 @@ func (dc *decoder) decodeProperty(r *Entity, pd *MessageDef) (err error) {
 }
 
 func (dc *decoder) decodeSingle(
-	r *Entity, pd *MessageDef, f *MessageFieldDef) (err error) {
-	if f.DataType.IsRefType() {
+	r *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	if fp.DataType.IsRefType() {
 		var ref Reference
-		if ref, err = dc.decodeJsonRef(pd, f); err != nil {
+		if ref, err = dc.decodeJsonRef(pd, fp); err != nil {
 			return
 		}
-		f.SetReference(r, ref)
+		fp.SetReference(r, ref)
 	} else {
 		var p Primitive
-		if p, err = dc.decodeJsonValue(f); err != nil {
+		if p, err = fp.decodeValue(dc); err != nil {
 			return
 		}
-		f.SetPrimitive(r, p)
+		fp.SetPrimitive(r, p)
 	}
 	return
 }
 
 func (dc *decoder) decodeRepeated(
-	r *Entity, pd *MessageDef, f *MessageFieldDef) (err error) {
+	r *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
 	if err = dc.accept(impl.TkSqBrOpen); err != nil {
 		return
 	}
@@ -102,19 +117,22 @@ func (dc *decoder) decodeRepeated(
 		return
 	}
 	for {
-		n := f.Reserve(r, 1)
-		if f.DataType.IsRefType() {
+		if err = dc.opts.limits.CheckRepeated(fp.MessageFieldDef, fp.Len(r)+1); err != nil {
+			return
+		}
+		n := fp.Reserve(r, 1)
+		if fp.DataType.IsRefType() {
 			var ref Reference
-			if ref, err = dc.decodeJsonRef(pd, f); err != nil {
+			if ref, err = dc.decodeJsonRef(pd, fp); err != nil {
 				return
 			}
-			f.SetReferenceAt(r, n, ref)
+			fp.SetReferenceAt(r, n, ref)
 		} else {
 			var p Primitive
-			if p, err = dc.decodeJsonValue(f); err != nil {
+			if p, err = fp.decodeValue(dc); err != nil {
 				return
 			}
-			f.SetPrimitiveAt(r, n, p)
+			fp.SetPrimitiveAt(r, n, p)
 		}
 		if !dc.tryAccept(impl.TkComma) {
 			break
@@ -124,85 +142,90 @@ func (dc *decoder) decodeRepeated(
 	return
 }
 
-func (dc *decoder) decodeJsonValue(f *MessageFieldDef) (pr Primitive, err error) {
-	if f.DataType == DtBool {
-		if b, err := dc.acceptBool(); err != nil {
-			return pr, err
-		} else {
-			return FromBool(b), nil
-		}
-	} else if n, err := dc.acceptValue(impl.TkNumber); err != nil {
-		return pr, err
-	} else {
-		switch f.DataType {
-		case DtInt32:
-			var value int64
-			if value, err = strconv.ParseInt(n, 10, 32); err == nil {
-				return FromInt32(int32(value)), nil
-			}
-		case DtInt64:
-			var value int64
-			if value, err = strconv.ParseInt(n, 10, 64); err == nil {
-				return FromInt64(value), nil
-			}
-		case DtUint32:
-			var value uint64
-			if value, err = strconv.ParseUint(n, 10, 32); err == nil {
-				return FromUint32(uint32(value)), nil
-			}
-		case DtUint64:
-			var value uint64
-			if value, err = strconv.ParseUint(n, 10, 64); err == nil {
-				return FromUint64(value), nil
-			}
-		case DtFloat32:
-			var value float64
-			if value, err = strconv.ParseFloat(n, 32); err == nil {
-				return FromFloat32(float32(value)), nil
-			}
-		case DtFloat64:
-			var value float64
-			if value, err = strconv.ParseFloat(n, 64); err == nil {
-				return FromFloat64(value), nil
-			}
-		default:
-			panic(f.DataType)
-		}
-		return pr, err
+func (dc *decoder) decodeJsonRef(
+	pd *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	if !fp.decodesNull && dc.tryAccept(impl.TkNull) {
+		return ref, nil
 	}
+	return fp.decodeItem(dc, pd, fp)
 }
 
-func (dc *decoder) decodeJsonRef(
-	pd *MessageDef, f *MessageFieldDef) (ref Reference, err error) {
-	if dc.tryAccept(impl.TkNull) {
-		return ref, nil
+// -----------------------------------------------------------------------------
+// Item decoders
+
+func (dc *decoder) decodeString(_ *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	var str string
+	if str, err = dc.acceptValue(impl.TkString); err != nil {
+		return
 	}
-	switch {
-	case f.DataType == DtString:
-		var str string
-		if str, err = dc.acceptValue(impl.TkString); err == nil {
-			return FromString(str), nil
-		}
-	case f.DataType == DtBytes:
-		var str string
-		if str, err = dc.acceptValue(impl.TkString); err != nil {
-			return
-		}
-		var b []byte
-		if b, err = base64.StdEncoding.DecodeString(str); err == nil {
-			return FromBytes(b, false), nil
-		}
-	case f.DataType.IsEntity():
-		def := pd.Registry.GetMessageDef(f.DataType)
-		var nested *Entity
-		if nested, err = dc.decode(def); err != nil {
-			return
-		}
-		return FromEntity(nested), nil
+	if err = dc.opts.limits.CheckString(fp.MessageFieldDef, len(str)); err == nil {
+		ref = FromString(str)
+	}
+	return
+}
+
+func (dc *decoder) decodeBytes(_ *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	var str string
+	if str, err = dc.acceptValue(impl.TkString); err != nil {
+		return
+	}
+	var b []byte
+	if b, err = base64.StdEncoding.DecodeString(str); err != nil {
+		return
+	}
+	if err = dc.opts.limits.CheckString(fp.MessageFieldDef, len(b)); err == nil {
+		ref = FromBytes(b, false)
+	}
+	return
+}
+
+func (dc *decoder) decodeEntity(pd *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	var nested *Entity
+	if nested, err = dc.decode(def); err == nil {
+		ref = FromEntity(nested)
 	}
 	return
 }
 
+// -----------------------------------------------------------------------------
+// Value decoders
+
+func (dc *decoder) decodeInt32() (Primitive, error) {
+	value, err := dc.acceptInt(32)
+	return FromInt32(int32(value)), err
+}
+
+func (dc *decoder) decodeInt64() (Primitive, error) {
+	value, err := dc.acceptInt(64)
+	return FromInt64(value), err
+}
+
+func (dc *decoder) decodeUint32() (Primitive, error) {
+	value, err := dc.acceptUint(32)
+	return FromUint32(uint32(value)), err
+}
+
+func (dc *decoder) decodeUint64() (Primitive, error) {
+	value, err := dc.acceptUint(64)
+	return FromUint64(value), err
+}
+
+func (dc *decoder) decodeFloat32() (Primitive, error) {
+	value, err := dc.acceptFloat(32)
+	return FromFloat32(float32(value)), err
+}
+
+func (dc *decoder) decodeFloat64() (Primitive, error) {
+	value, err := dc.acceptFloat(64)
+	return FromFloat64(value), err
+}
+
+func (dc *decoder) decodeBool() (Primitive, error) {
+	value, err := dc.acceptBool()
+	return FromBool(value), err
+}
+
 // -----------------------------------------------------------------------------
 // Ignore methods
 
@@ -228,10 +251,14 @@ func (dc *decoder) ignoreValue() (err error) {
 }
 
 func (dc *decoder) ignoreObject() (err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
 	if err = dc.accept(impl.TkCrBrOpen); err != nil {
 		return
 	}
 	if dc.tryAccept(impl.TkCrBrClose) {
+		dc.leave()
 		return
 	}
 	for {
@@ -246,14 +273,19 @@ func (dc *decoder) ignoreObject() (err error) {
 		}
 	}
 	err = dc.accept(impl.TkCrBrClose)
+	dc.leave()
 	return
 }
 
 func (dc *decoder) ignoreArray() (err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
 	if err = dc.accept(impl.TkSqBrOpen); err != nil {
 		return
 	}
 	if dc.tryAccept(impl.TkSqBrClose) {
+		dc.leave()
 		return
 	}
 	for {
@@ -265,5 +297,19 @@ func (dc *decoder) ignoreArray() (err error) {
 		}
 	}
 	err = dc.accept(impl.TkSqBrClose)
+	dc.leave()
 	return
 }
+
+// -----------------------------------------------------------------------------
+// Nesting depth
+
+// enter increases the nesting depth before decoding an object or array, and
+// checks whether the depth doesn't exceed the limit.
+func (dc *decoder) enter() error {
+	dc.depth++
+	return dc.opts.limits.CheckDepth(dc.depth)
+}
+
+// leave decreases the nesting depth after an object or array has been decoded.
+func (dc *decoder) leave() { dc.depth-- }
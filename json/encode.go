@@ -1,16 +1,14 @@
 package json
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/json"
 
 	. "github.com/umk/go-dymessage"
 	"github.com/umk/go-dymessage/internal/helpers"
 )
 
 type encoder struct {
-	buf bytes.Buffer
+	buf []byte
 }
 
 // Encode transforms the data from the dynamic entity to a buffer, containing
@@ -18,129 +16,144 @@ type encoder struct {
 // definition, the method will panic.
 func Encode(e *Entity, pd *MessageDef) ([]byte, error) {
 	helpers.DataTypesMustMatch(e, pd)
-	var ec encoder
-	ec.buf.Grow(1024)
-	if err := ec.encode(e, pd); err != nil {
+	ec := encoder{buf: make([]byte, 0, 1024)}
+	if err := ec.encodeMessage(e, pd); err != nil {
 		return nil, err
 	}
-	return ec.buf.Bytes(), nil
+	return ec.buf, nil
 }
 
 func (ec *encoder) encode(e *Entity, pd *MessageDef) (err error) {
-	ec.buf.WriteRune('{')
-	n := len(pd.Fields)
-	for i, f := range pd.Fields {
-		if err = ec.encodeToBuf(f.Name); err != nil {
-			return
-		}
-		ec.buf.WriteRune(':')
-		if f.Repeated {
-			if f.DataType.IsRefType() {
-				err = ec.encodeJsonRefs(e, pd, f)
-			} else {
-				err = ec.encodeJsonValues(e, f)
-			}
-		} else if f.DataType.IsRefType() {
-			item := e.Entities[f.Offset]
-			if item != nil {
-				err = ec.encodeJsonRef(item, pd, f)
-			} else {
-				err = ec.encodeToBuf(nil)
-			}
-		} else {
-			value := f.GetPrimitive(e)
-			err = ec.encodeJsonValue(value, f)
+	p := getPlan(pd)
+	ec.buf = append(ec.buf, '{')
+	for i := range p.fields {
+		fp := &p.fields[i]
+		if i > 0 {
+			ec.buf = append(ec.buf, ',')
 		}
-		if i != (n - 1) {
-			ec.buf.WriteRune(',')
+		ec.buf = append(ec.buf, fp.name...)
+		if err = fp.encode(ec, e, pd, fp); err != nil {
+			return
 		}
 	}
-	ec.buf.WriteRune('}')
+	ec.buf = append(ec.buf, '}')
 	return
 }
 
-func (ec *encoder) encodeJsonValue(value Primitive, f *MessageFieldDef) (err error) {
-	switch f.DataType {
-	case DtInt32:
-		err = ec.encodeToBuf(value.ToInt32())
-	case DtInt64:
-		err = ec.encodeToBuf(value.ToInt64())
-	case DtUint32:
-		err = ec.encodeToBuf(value.ToUint32())
-	case DtUint64:
-		err = ec.encodeToBuf(value.ToUint64())
-	case DtFloat32:
-		err = ec.encodeToBuf(value.ToFloat32())
-	case DtFloat64:
-		err = ec.encodeToBuf(value.ToFloat64())
-	case DtBool:
-		err = ec.encodeToBuf(value.ToBool())
-	default:
-		panic(f.DataType)
-	}
-	return
+func (ec *encoder) encodeJsonValue(e *Entity, _ *MessageDef, fp *fieldPlan) error {
+	return fp.encodeValue(ec, fp.GetPrimitive(e))
 }
 
-func (ec *encoder) encodeJsonValues(e *Entity, f *MessageFieldDef) (err error) {
-	ec.buf.WriteRune('[')
-	data := e.Entities[f.Offset]
-	if data != nil {
-		n := len(data.Data) / f.DataType.GetWidthInBytes()
-		for i := 0; i < n; i++ {
-			value := f.GetPrimitiveAt(e, i)
-			if err = ec.encodeJsonValue(value, f); err != nil {
-				return
-			}
-			if i != (n - 1) {
-				ec.buf.WriteRune(',')
-			}
+func (ec *encoder) encodeJsonValues(e *Entity, _ *MessageDef, fp *fieldPlan) (err error) {
+	ec.buf = append(ec.buf, '[')
+	n := fp.Len(e)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			ec.buf = append(ec.buf, ',')
+		}
+		if err = fp.encodeValue(ec, fp.GetPrimitiveAt(e, i)); err != nil {
+			return
 		}
 	}
-	ec.buf.WriteRune(']')
+	ec.buf = append(ec.buf, ']')
 	return
 }
 
-func (ec *encoder) encodeJsonRef(e *Entity, pd *MessageDef, f *MessageFieldDef) (err error) {
-	switch f.DataType {
-	case DtBytes:
-		str := base64.StdEncoding.EncodeToString(e.Data)
-		err = ec.encodeToBuf(str)
-	case DtString:
-		err = ec.encodeToBuf(string(e.Data))
-	case DtEntity:
-		def := pd.Registry.GetMessageDef(f.DataType)
-		return ec.encode(e, def)
-	default:
-		panic(f.DataType)
+func (ec *encoder) encodeJsonRef(e *Entity, pd *MessageDef, fp *fieldPlan) error {
+	item := e.Entities[fp.Offset]
+	if item == nil {
+		ec.buf = append(ec.buf, "null"...)
+		return nil
 	}
-	return
+	if err := item.Load(); err != nil {
+		return err
+	}
+	return fp.encodeItem(ec, item, pd, fp)
 }
 
-func (ec *encoder) encodeJsonRefs(e *Entity, pd *MessageDef, f *MessageFieldDef) (err error) {
-	data := e.Entities[f.Offset]
+func (ec *encoder) encodeJsonRefs(e *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	data := e.Entities[fp.Offset]
 	if data == nil {
-		err = ec.encodeToBuf(nil)
-	} else {
-		ec.buf.WriteRune('[')
-		n := len(data.Entities)
-		for i, item := range data.Entities {
-			if err = ec.encodeJsonRef(item, pd, f); err != nil {
-				return
-			}
-			if i != (n - 1) {
-				ec.buf.WriteRune(',')
-			}
+		ec.buf = append(ec.buf, "null"...)
+		return
+	}
+	ec.buf = append(ec.buf, '[')
+	for i, item := range data.Entities {
+		if i > 0 {
+			ec.buf = append(ec.buf, ',')
+		}
+		if item == nil {
+			ec.buf = append(ec.buf, "null"...)
+			continue
+		}
+		if err = item.Load(); err != nil {
+			return
+		}
+		if err = fp.encodeItem(ec, item, pd, fp); err != nil {
+			return
 		}
-		ec.buf.WriteRune(']')
 	}
+	ec.buf = append(ec.buf, ']')
 	return
 }
 
-func (ec *encoder) encodeToBuf(v interface{}) error {
-	if data, err := json.Marshal(v); err != nil {
-		return err
-	} else {
-		ec.buf.Write(data)
-		return nil
-	}
+// -----------------------------------------------------------------------------
+// Item encoders
+
+func (ec *encoder) encodeString(item *Entity, _ *MessageDef, _ *fieldPlan) error {
+	ec.buf = appendString(ec.buf, string(item.Data))
+	return nil
+}
+
+func (ec *encoder) encodeBytes(item *Entity, _ *MessageDef, _ *fieldPlan) error {
+	n := len(ec.buf) + 1
+	m := base64.StdEncoding.EncodedLen(len(item.Data))
+	ec.buf = append(ec.buf, '"')
+	ec.buf = append(ec.buf, make([]byte, m)...)
+	base64.StdEncoding.Encode(ec.buf[n:], item.Data)
+	ec.buf = append(ec.buf, '"')
+	return nil
+}
+
+func (ec *encoder) encodeEntity(item *Entity, pd *MessageDef, fp *fieldPlan) error {
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	return ec.encode(item, def)
+}
+
+// -----------------------------------------------------------------------------
+// Value encoders
+
+func (ec *encoder) encodeInt32(value Primitive) error {
+	ec.buf = appendInt(ec.buf, int64(value.ToInt32()))
+	return nil
+}
+
+func (ec *encoder) encodeInt64(value Primitive) error {
+	ec.buf = appendInt(ec.buf, value.ToInt64())
+	return nil
+}
+
+func (ec *encoder) encodeUint32(value Primitive) error {
+	ec.buf = appendUint(ec.buf, uint64(value.ToUint32()))
+	return nil
+}
+
+func (ec *encoder) encodeUint64(value Primitive) error {
+	ec.buf = appendUint(ec.buf, value.ToUint64())
+	return nil
+}
+
+func (ec *encoder) encodeFloat32(value Primitive) (err error) {
+	ec.buf, err = appendFloat(ec.buf, float64(value.ToFloat32()), 32)
+	return
+}
+
+func (ec *encoder) encodeFloat64(value Primitive) (err error) {
+	ec.buf, err = appendFloat(ec.buf, value.ToFloat64(), 64)
+	return
+}
+
+func (ec *encoder) encodeBool(value Primitive) error {
+	ec.buf = appendBool(ec.buf, value.ToBool())
+	return nil
 }
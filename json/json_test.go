@@ -4,12 +4,16 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	. "github.com/umk/go-dymessage"
 	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/protobuf"
 )
 
 func TestJsonEncodeDecode(t *testing.T) {
@@ -54,3 +58,371 @@ func TestJsonDecodeRandom(t *testing.T) {
 		return nil
 	})
 }
+
+func TestJsonDecodeLimits(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opt  DecodeOption
+		fail bool
+	}{
+		{"depth", WithMaxDepth(3), false},
+		{"depth exceeded", WithMaxDepth(2), true},
+		{"bytes", WithMaxBytes(len(data)), false},
+		{"bytes exceeded", WithMaxBytes(len(data) - 1), true},
+		{"repeated", WithMaxRepeated(3), false},
+		{"repeated exceeded", WithMaxRepeated(2), true},
+		{"string", WithMaxStringLength(20), false},
+		{"string exceeded", WithMaxStringLength(19), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeNew(data, def, test.opt)
+			if test.fail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJsonDecodeDefaultMaxDepth(t *testing.T) {
+	def, _ := ArrangeEncodeDecode()
+
+	// The unknown fields are nested deeper than allowed by default.
+	data := `{"Unknown":` + strings.Repeat("[", 10001) + strings.Repeat("]", 10001) + `}`
+
+	_, err := DecodeNew([]byte(data), def)
+	require.Error(t, err)
+}
+
+func TestJsonTimestampDuration(t *testing.T) {
+	rb := NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithNamespace("koala.goshawk").
+		WithName("Message").
+		WithField("Time", 1, protobuf.ForTimestamp(rb)).
+		WithField("Elapsed", 2, protobuf.ForDuration(rb)).
+		WithArrayField("Laps", 3, protobuf.ForDuration(rb)).
+		Build()
+	rb.Build()
+	durDef := def.Registry.GetMessageDef(def.GetField(2).DataType)
+
+	e := def.NewEntity()
+	protobuf.SetTime(e, def, 1, time.Date(1972, 1, 1, 10, 0, 20, 21000000, time.UTC))
+	protobuf.SetDuration(e, def, 2, -1500*time.Millisecond)
+	def.GetField(3).Reserve(e, 3)
+	def.GetField(3).SetReferenceAt(e, 0, FromEntity(protobuf.FromDuration(3*time.Second, durDef)))
+	def.GetField(3).SetReferenceAt(e, 1, FromEntity(protobuf.FromDuration(1000, durDef)))
+	def.GetField(3).SetReferenceAt(e, 2, FromEntity(protobuf.FromDuration(1, durDef)))
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"Time":"1972-01-01T10:00:20.021Z","Elapsed":"-1.500s",`+
+			`"Laps":["3s","0.000001s","0.000000001s"]}`, string(data))
+
+	e2, err := DecodeNew(data, def)
+	require.NoError(t, err)
+	expected, err := protobuf.Encode(e, def)
+	require.NoError(t, err)
+	actual, err := protobuf.Encode(e2, def)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// The time zone offsets are accepted.
+	e2, err = DecodeNew([]byte(`{"Time":"1972-01-01T12:00:20+02:00","Elapsed":"-0.5s"}`), def)
+	require.NoError(t, err)
+	tm, _ := protobuf.GetTime(e2, def, 1)
+	assert.Equal(t, time.Date(1972, 1, 1, 10, 0, 20, 0, time.UTC), tm)
+	d, _ := protobuf.GetDuration(e2, def, 2)
+	assert.Equal(t, -500*time.Millisecond, d)
+
+	_, err = DecodeNew([]byte(`{"Time":"1972-01-01"}`), def)
+	assert.EqualError(t, err, `dymessage: (1:9): invalid timestamp "1972-01-01"`)
+	for _, s := range []string{"1", "s", "1.s", "+1s", "1.0000000001s", "1e3s", "315576000001s"} {
+		_, err = DecodeNew([]byte(`{"Elapsed":"`+s+`"}`), def)
+		assert.Error(t, err, s)
+	}
+	protobuf.SetTime(e, def, 1, time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err = Encode(e, def)
+	assert.Error(t, err)
+}
+
+func TestJsonWrappers(t *testing.T) {
+	rb := NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithNamespace("koala.goshawk").
+		WithName("Message").
+		WithField("Int32", 1, protobuf.ForWrapper(rb, DtInt32)).
+		WithField("Float64", 2, protobuf.ForWrapper(rb, DtFloat64)).
+		WithField("Bool", 3, protobuf.ForWrapper(rb, DtBool)).
+		WithField("String", 4, protobuf.ForWrapper(rb, DtString)).
+		WithField("Bytes", 5, protobuf.ForWrapper(rb, DtBytes)).
+		WithArrayField("Items", 6, protobuf.ForWrapper(rb, DtInt32)).
+		Build()
+	rb.Build()
+
+	e := def.NewEntity()
+	protobuf.SetWrappedPrimitive(e, def, 1, FromInt32(0))
+	protobuf.SetWrappedPrimitive(e, def, 2, FromFloat64(1.5))
+	protobuf.SetWrappedReference(e, def, 4, GetDefaultReference())
+	protobuf.SetWrappedReference(e, def, 5, FromBytes([]byte{1, 2}, false))
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"Int32":0,"Float64":1.5,"Bool":null,"String":"","Bytes":"AQI=","Items":null}`, string(data))
+
+	e2, err := DecodeNew(data, def)
+	require.NoError(t, err)
+	_, ok := protobuf.GetWrappedPrimitive(e2, def, 3)
+	assert.False(t, ok)
+	value, ok := protobuf.GetWrappedPrimitive(e2, def, 1)
+	assert.True(t, ok)
+	assert.Equal(t, int32(0), value.ToInt32())
+	ref, ok := protobuf.GetWrappedReference(e2, def, 4)
+	assert.True(t, ok)
+	assert.Equal(t, "", ref.ToString())
+
+	e2, err = DecodeNew([]byte(`{"Bool":true,"Items":[1,null,3]}`), def)
+	require.NoError(t, err)
+	value, _ = protobuf.GetWrappedPrimitive(e2, def, 3)
+	assert.True(t, value.ToBool())
+	assert.Nil(t, def.GetField(6).GetReferenceAt(e2, 1).ToEntity())
+	data, err = Encode(e2, def)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Items":[1,null,3]`)
+
+	_, err = DecodeNew([]byte(`{"Int32":"1"}`), def)
+	assert.Error(t, err)
+}
+
+func TestJsonStruct(t *testing.T) {
+	rb := NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithNamespace("koala.goshawk").
+		WithName("Message").
+		WithField("Struct", 1, protobuf.ForStruct(rb)).
+		WithField("Value", 2, protobuf.ForValue(rb)).
+		WithField("List", 3, protobuf.ForListValue(rb)).
+		Build()
+	rb.Build()
+	structDef := def.Registry.GetMessageDef(def.GetField(1).DataType)
+
+	data := `{"Struct":{"b":[1,"s",null,{}],"a":{"c":true}},"Value":-1.5,"List":[[],false]}`
+	e, err := DecodeNew([]byte(data), def)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{"c": true},
+		"b": []interface{}{1.0, "s", nil, map[string]interface{}{}},
+	}, protobuf.StructToMap(def.GetField(1).GetReference(e).ToEntity(), structDef))
+	actual, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(actual))
+
+	// The entities survive the protobuf encoding.
+	b, err := protobuf.Encode(e, def)
+	require.NoError(t, err)
+	e, err = protobuf.DecodeNew(b, def)
+	require.NoError(t, err)
+	actual, err = Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(actual))
+
+	// The null is decoded as the value, which holds the null.
+	data = `{"Struct":null,"Value":null,"List":[null]}`
+	e, err = DecodeNew([]byte(data), def)
+	require.NoError(t, err)
+	valueDef := def.Registry.GetMessageDef(def.GetField(2).DataType)
+	value := def.GetField(2).GetReference(e).ToEntity()
+	require.NotNil(t, value)
+	assert.Equal(t, uint64(protobuf.TagValueNull), valueDef.GetField(protobuf.TagValueKind).GetPrimitive(value).ToUint64())
+	actual, err = Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(actual))
+
+	_, err = DecodeNew([]byte(`{"Value":{"a":]}`), def)
+	assert.EqualError(t, err, `dymessage: (1:15): unexpected token "]"`)
+	_, err = DecodeNew([]byte(`{"Struct":[]}`), def)
+	assert.Error(t, err)
+	_, err = DecodeNew([]byte(`{"Value":[[[1]]]}`), def, WithMaxDepth(3))
+	assert.Error(t, err)
+}
+
+func TestJsonAny(t *testing.T) {
+	rb := TestBuilder{RegistryBuilder: NewRegistryBuilder()}
+	msg := rb.CreateTestMessage("message", "koala.goshawk", "Message").Build()
+	anyType := protobuf.ForAny(rb.RegistryBuilder)
+	holder := rb.ForMessageDef("holder").
+		WithNamespace("koala.goshawk").
+		WithName("Holder").
+		WithField("Payload", 1, anyType).
+		WithArrayField("Items", 2, anyType).
+		Build()
+	anyDef := holder.Registry.GetMessageDef(anyType)
+
+	pack := func(e *Entity, def *MessageDef) *Entity {
+		data, err := protobuf.Encode(e, def)
+		require.NoError(t, err)
+		a := anyDef.NewEntity()
+		anyDef.GetField(protobuf.TagAnyTypeUrl).SetReference(a, FromString("type.googleapis.com/"+def.QualifiedName()))
+		anyDef.GetField(protobuf.TagAnyValue).SetReference(a, FromBytes(data, false))
+		return a
+	}
+
+	value := msg.NewEntity()
+	msg.GetField(TagRegInt32).SetPrimitive(value, FromInt32(5))
+	msg.GetField(TagRegString).SetReference(value, FromString("string"))
+
+	e := holder.NewEntity()
+	holder.GetField(1).SetReference(e, FromEntity(pack(value, msg)))
+	holder.GetField(2).Reserve(e, 2)
+	holder.GetField(2).SetReferenceAt(e, 0, FromEntity(pack(pack(value, msg), anyDef)))
+	holder.GetField(2).SetReferenceAt(e, 1, FromEntity(anyDef.NewEntity()))
+
+	data, err := Encode(e, holder)
+	require.NoError(t, err)
+	s := string(data)
+	assert.Contains(t, s, `"Payload":{"@type":"type.googleapis.com/koala.goshawk.Message","RegInt32":5,`)
+	assert.Contains(t, s, `"Items":[{"@type":"type.googleapis.com/google.protobuf.Any","value":{"@type":"type.googleapis.com/koala.goshawk.Message","RegInt32":5,`)
+	assert.Contains(t, s, `},{}]`)
+
+	e2, err := DecodeNew(data, holder)
+	require.NoError(t, err)
+	expected, err := protobuf.Encode(e, holder)
+	require.NoError(t, err)
+	actual, err := protobuf.Encode(e2, holder)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	// The type may be located anywhere in the object.
+	data = []byte(`{"Payload":{"RegInt32":7,"@type":"type.googleapis.com/koala.goshawk.Message"}}`)
+	e2, err = DecodeNew(data, holder)
+	require.NoError(t, err)
+	a := holder.GetField(1).GetReference(e2).ToEntity()
+	value2, err := protobuf.DecodeNew(anyDef.GetField(protobuf.TagAnyValue).GetReference(a).ToBytes(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), msg.GetField(TagRegInt32).GetPrimitive(value2).ToInt32())
+
+	_, err = DecodeNew([]byte(`{"Payload":{"@type":"type.googleapis.com/koala.goshawk.Unknown"}}`), holder)
+	assert.EqualError(t, err, `dymessage: type "koala.goshawk.Unknown" could not be found`)
+	_, err = DecodeNew([]byte(`{"Payload":{"RegInt32":7}}`), holder)
+	assert.Error(t, err)
+}
+
+func createPatchDef() *MessageDef {
+	rb := NewRegistryBuilder()
+	ts := protobuf.ForTimestamp(rb)
+	address := rb.ForMessageDef("address").
+		WithName("Address").
+		WithField("city", 1, DtString).
+		WithField("zip", 2, DtUint32).
+		Build()
+	def := rb.ForMessageDef("person").
+		WithName("Person").
+		WithField("name", 1, DtString).
+		WithField("age", 2, DtInt32).
+		WithArrayField("tags", 3, DtString).
+		WithArrayField("scores", 4, DtInt64).
+		WithField("address", 5, address.DataType).
+		WithArrayField("addresses", 6, address.DataType).
+		WithField("created", 7, ts).
+		WithField("a/b~c", 8, DtBool).
+		Build()
+	rb.Build()
+	return def
+}
+
+func TestJsonPatch(t *testing.T) {
+	def := createPatchDef()
+	e, err := DecodeNew([]byte(`{"name":"Alice","age":30,"tags":["a","b"],"scores":[1,2,3],`+
+		`"address":{"city":"Oslo","zip":150},"created":"2020-01-01T00:00:00Z"}`), def)
+	require.NoError(t, err)
+
+	err = ApplyPatch(e, []byte(`[
+		{"op":"test","path":"/name","value":"Alice"},
+		{"op":"replace","path":"/name","value":"Alicia"},
+		{"op":"add","path":"/tags/1","value":"x"},
+		{"op":"add","path":"/tags/-","value":"z"},
+		{"op":"remove","path":"/scores/0"},
+		{"op":"replace","path":"/scores/1","value":5},
+		{"op":"add","path":"/address/city","value":"Bergen"},
+		{"op":"copy","from":"/address","path":"/addresses/0"},
+		{"op":"move","from":"/address/zip","path":"/addresses/0/zip"},
+		{"op":"remove","path":"/age"},
+		{"op":"replace","path":"/created","value":"2021-02-03T04:05:06Z"},
+		{"op":"add","path":"/a~1b~0c","value":true},
+		{"op":"test","path":"/tags","value":["a","x","b","z"]},
+		{"op":"test","path":"/addresses/0","value":{"city":"Bergen","zip":150}}
+	]`), def)
+	require.NoError(t, err)
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Alicia","age":0,"tags":["a","x","b","z"],"scores":[2,5],`+
+		`"address":{"city":"Bergen","zip":0},"addresses":[{"city":"Bergen","zip":150}],`+
+		`"created":"2021-02-03T04:05:06Z","a/b~c":true}`, string(data))
+
+	// The entity is left intact if any of the operations fails.
+	tests := []struct {
+		patch, message string
+	}{
+		{`[{"op":"replace","path":"/name","value":"Bob"},{"op":"test","path":"/age","value":1}]`,
+			`dymessage: operation 1 (test "/age"): test failed`},
+		{`[{"op":"replace","path":"/age","value":"1"}]`,
+			`dymessage: operation 0 (replace "/age"): (1:1): expected number, but found "1"`},
+		{`[{"op":"add","path":"/height","value":1}]`,
+			`dymessage: operation 0 (add "/height"): unknown field "height" of Person`},
+		{`[{"op":"replace","path":"/tags/4","value":"x"}]`,
+			`dymessage: operation 0 (replace "/tags/4"): index 4 is out of range`},
+		{`[{"op":"remove","path":"/tags/01"}]`,
+			`dymessage: operation 0 (remove "/tags/01"): invalid index "01"`},
+		{`[{"op":"add","path":"/created/seconds","value":1}]`,
+			`dymessage: operation 0 (add "/created/seconds"): path "/created/seconds" refers inside google.protobuf.Timestamp`},
+		{`[{"op":"move","from":"/name","path":"/scores/0"}]`,
+			`dymessage: operation 0 (move "/scores/0"): value of "/name" cannot be stored at "/scores/0"`},
+		{`[{"op":"move","from":"/address","path":"/address/city"}]`,
+			`dymessage: operation 0 (move "/address/city"): value cannot be moved into itself`},
+		{`[{"op":"add","path":"/name"}]`,
+			`dymessage: operation 0 (add "/name"): value is missing`},
+		{`[{"op":"update","path":"/name"}]`,
+			`dymessage: operation 0 (update "/name"): unknown operation "update"`},
+	}
+	for _, tt := range tests {
+		err = ApplyPatch(e, []byte(tt.patch), def)
+		assert.EqualError(t, err, tt.message, tt.patch)
+	}
+	data2, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(data2))
+
+	// The validation applies to the patched entity.
+	failing := func(*Entity, *MessageDef) error { return assert.AnError }
+	err = ApplyPatch(e, []byte(`[{"op":"replace","path":"/name","value":"Bob"}]`), def, WithValidation(failing))
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, "Alicia", def.GetFieldByName("name").GetReference(e).ToString())
+}
+
+func TestJsonMergePatch(t *testing.T) {
+	def := createPatchDef()
+	e, err := DecodeNew([]byte(`{"name":"Alice","age":30,"tags":["a","b"],"address":{"city":"Oslo","zip":150}}`), def)
+	require.NoError(t, err)
+
+	err = ApplyMergePatch(e, []byte(`{"name":"Bob","age":null,"tags":["c"],"unknown":{"a":1},`+
+		`"address":{"zip":null,"city":"Bergen"},"addresses":[{"city":"Tromsø"}],"created":"2020-01-01T00:00:00Z"}`), def)
+	require.NoError(t, err)
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"Bob","age":0,"tags":["c"],"scores":[],"address":{"city":"Bergen","zip":0},`+
+		`"addresses":[{"city":"Tromsø","zip":0}],"created":"2020-01-01T00:00:00Z","a/b~c":false}`, string(data))
+
+	err = ApplyMergePatch(e, []byte(`{"address":null,"name":"Carol","age":"x"}`), def)
+	assert.EqualError(t, err, `dymessage: (1:38): expected number, but found "x"`)
+	assert.NotNil(t, def.GetFieldByName("address").GetReference(e).Entity)
+
+	err = ApplyMergePatch(e, []byte(`{"address":null}`), def)
+	require.NoError(t, err)
+	assert.Nil(t, def.GetFieldByName("address").GetReference(e).Entity)
+}
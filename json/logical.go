@@ -0,0 +1,88 @@
+package json
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json/internal/impl"
+)
+
+// compileLogicalCoders replaces the coders of the field of a logical type with
+// the ones, which validate the values and convert them to and from their JSON
+// representation, provided by the logical type.
+func compileLogicalCoders(fp *fieldPlan, lt LogicalType) {
+	f := fp.MessageFieldDef
+	if !f.DataType.IsRefType() {
+		fp.encodeValue = func(ec *encoder, value Primitive) error {
+			return ec.encodeLogical(PrimitiveValue(f.DataType, value), f, lt)
+		}
+		fp.decodeValue = func(dc *decoder) (value Primitive, err error) {
+			var v LogicalValue
+			if v, err = dc.decodeLogical(f.DataType, lt); err == nil {
+				value = v.Primitive
+			}
+			return
+		}
+		return
+	}
+	fp.encodeItem = func(ec *encoder, item *Entity, _ *MessageDef, _ *fieldPlan) error {
+		return ec.encodeLogical(ReferenceValue(f.DataType, FromEntity(item)), f, lt)
+	}
+	fp.decodeItem = func(dc *decoder, _ *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+		var v LogicalValue
+		if v, err = dc.decodeLogical(f.DataType, lt); err != nil {
+			return
+		}
+		if err = dc.opts.limits.CheckString(fp.MessageFieldDef, len(v.Reference.ToBytes())); err == nil {
+			ref = v.Reference
+		}
+		return
+	}
+}
+
+func (ec *encoder) encodeLogical(v LogicalValue, f *MessageFieldDef, lt LogicalType) error {
+	if err := lt.Validate(v); err != nil {
+		return fmt.Errorf("dymessage: invalid value of field %q: %v", f.Name, err)
+	}
+	data, err := lt.FormatJSON(v)
+	if err != nil {
+		return fmt.Errorf("dymessage: invalid value of field %q: %v", f.Name, err)
+	}
+	ec.buf = append(ec.buf, data...)
+	return nil
+}
+
+func (dc *decoder) decodeLogical(dt DataType, lt LogicalType) (v LogicalValue, err error) {
+	pos := dc.lx.Tok.Pos
+	var data []byte
+	if data, err = dc.acceptScalar(); err != nil {
+		return
+	}
+	if v, err = lt.ParseJSON(dt, data); err == nil {
+		err = lt.Validate(v)
+	}
+	if err != nil {
+		err = fmt.Errorf("dymessage: %v: invalid value of %s: %v", pos, lt.Name(), err)
+	}
+	return
+}
+
+// acceptScalar accepts a string, a number or a literal, getting its JSON
+// representation.
+func (dc *decoder) acceptScalar() (data []byte, err error) {
+	if err = dc.lx.Err; err != nil {
+		return
+	}
+	switch dc.lx.Tok.Kind {
+	case impl.TkString:
+		data = appendString(nil, dc.lx.Tok.Value)
+	case impl.TkNumber, impl.TkTrue, impl.TkFalse:
+		data = []byte(dc.lx.Tok.Value)
+	default:
+		return nil, errors.New(dc.createErrorMessage(
+			impl.TkString, impl.TkNumber, impl.TkTrue, impl.TkFalse))
+	}
+	dc.lx.Next()
+	return
+}
@@ -3,6 +3,7 @@ package json
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/umk/go-dymessage/json/internal/impl"
@@ -70,6 +71,30 @@ func (dc *decoder) acceptBool() (b bool, err error) {
 	return
 }
 
+func (dc *decoder) acceptInt(bitSize int) (i int64, err error) {
+	var n string
+	if n, err = dc.acceptValue(impl.TkNumber); err == nil {
+		i, err = strconv.ParseInt(n, 10, bitSize)
+	}
+	return
+}
+
+func (dc *decoder) acceptUint(bitSize int) (i uint64, err error) {
+	var n string
+	if n, err = dc.acceptValue(impl.TkNumber); err == nil {
+		i, err = strconv.ParseUint(n, 10, bitSize)
+	}
+	return
+}
+
+func (dc *decoder) acceptFloat(bitSize int) (f float64, err error) {
+	var n string
+	if n, err = dc.acceptValue(impl.TkNumber); err == nil {
+		f, err = strconv.ParseFloat(n, bitSize)
+	}
+	return
+}
+
 func (dc *decoder) probably(tk impl.TokenKind) bool {
 	return dc.lx.Err == nil && dc.lx.Tok.Kind == tk
 }
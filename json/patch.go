@@ -0,0 +1,440 @@
+package json
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json/internal/impl"
+)
+
+type (
+	// A single operation of JSON Patch document.
+	patchOperation struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		From  string          `json:"from"`
+		Value json.RawMessage `json:"value"`
+	}
+
+	// A location in the entity referred by JSON Pointer, which is either
+	// the entity itself, a field of the entity or its nested entities, or
+	// an item of a repeated field.
+	location struct {
+		e     *Entity     // The entity, which holds the field
+		pd    *MessageDef // The message definition of the entity
+		fp    *fieldPlan  // The field, or nil for the entity itself
+		index int         // The index of the item, or -1 if not an item
+	}
+
+	// A value at the location. Depending on the data type, either the
+	// primitive value or the reference is set. The repeated fields are
+	// represented by the collections of items.
+	value struct {
+		p Primitive
+		r Reference
+	}
+)
+
+// ApplyPatch applies the JSON Patch document (RFC 6902) to the entity of the
+// message definition. The members of the objects referred by JSON Pointers are
+// the fields of the entities, and the values are decoded just like DecodeNew
+// does. The operations are applied in order, and the entity is updated only if
+// all of them succeed. The options of decoding apply to each of the values, and
+// the validation, if requested, applies to the patched entity.
+//
+// Removing a field resets it to the default value, while adding a field, which
+// is not repeated, replaces its value.
+func ApplyPatch(e *Entity, patch []byte, pd *MessageDef, opts ...DecodeOption) error {
+	do := newDecodeOptions(opts)
+	if err := do.limits.CheckBytes(len(patch)); err != nil {
+		return err
+	}
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("dymessage: invalid patch: %v", err)
+	}
+	patched := e.Clone()
+	for i, op := range ops {
+		if err := applyOperation(patched, pd, op, do); err != nil {
+			return fmt.Errorf("dymessage: operation %d (%s %q): %s", i, op.Op, op.Path, trimPrefix(err))
+		}
+	}
+	return commitPatch(e, patched, pd, do)
+}
+
+// ApplyMergePatch applies the JSON Merge Patch document (RFC 7396) to the entity
+// of the message definition. The members of the patch, which are null, reset
+// the fields to the default values, the objects are merged into the nested
+// entities, and other values replace the values of the fields. The values are
+// decoded just like DecodeNew does, and the members, which don't correspond to
+// any fields, are ignored. The entity is updated only if the whole patch has
+// been applied.
+func ApplyMergePatch(e *Entity, patch []byte, pd *MessageDef, opts ...DecodeOption) (err error) {
+	dc := decoder{opts: newDecodeOptions(opts)}
+	if err = dc.opts.limits.CheckBytes(len(patch)); err != nil {
+		return
+	}
+	dc.lx.Reset(patch)
+	dc.lx.Next()
+	patched := e.Clone()
+	if err = dc.merge(patched, pd); err != nil {
+		return
+	}
+	if !dc.lx.Eof() {
+		return errors.New(dc.createErrorMessage(impl.TkEof))
+	}
+	return commitPatch(e, patched, pd, dc.opts)
+}
+
+// commitPatch validates the patched copy of the entity, if requested, and
+// replaces the content of the entity with it.
+func commitPatch(e, patched *Entity, pd *MessageDef, do decodeOptions) error {
+	if do.validate != nil {
+		if err := do.validate(patched, pd); err != nil {
+			return err
+		}
+	}
+	*e = *patched
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Merge patch
+
+func (dc *decoder) merge(r *Entity, pd *MessageDef) (err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
+	if err = dc.accept(impl.TkCrBrOpen); err != nil {
+		return
+	}
+	if dc.tryAccept(impl.TkCrBrClose) {
+		dc.leave()
+		return
+	}
+	p := getPlan(pd)
+	for {
+		var name string
+		if name, err = dc.acceptValue(impl.TkString); err != nil {
+			return
+		}
+		if err = dc.accept(impl.TkColon); err != nil {
+			return
+		}
+		if i, ok := p.byName[name]; !ok {
+			err = dc.ignoreValue()
+		} else {
+			err = dc.mergeField(r, pd, &p.fields[i])
+		}
+		if err != nil {
+			return
+		}
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	err = dc.accept(impl.TkCrBrClose)
+	dc.leave()
+	return
+}
+
+func (dc *decoder) mergeField(r *Entity, pd *MessageDef, fp *fieldPlan) error {
+	switch {
+	case dc.tryAccept(impl.TkNull):
+		resetField(r, fp.MessageFieldDef)
+		return nil
+	case fp.Repeated:
+		resetField(r, fp.MessageFieldDef)
+		return dc.decodeRepeated(r, pd, fp)
+	case isObject(pd, fp) && dc.probably(impl.TkCrBrOpen):
+		def := pd.Registry.GetMessageDef(fp.DataType)
+		nested := fp.GetReference(r).Entity
+		if nested == nil {
+			nested = def.NewEntity()
+			fp.SetReference(r, FromEntity(nested))
+		}
+		return dc.merge(nested, def)
+	default:
+		return dc.decodeSingle(r, pd, fp)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// JSON Patch
+
+func applyOperation(e *Entity, pd *MessageDef, op patchOperation, do decodeOptions) error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return errors.New("value is missing")
+		}
+		loc, err := resolvePointer(e, pd, op.Path, op.Op == "add")
+		if err != nil {
+			return err
+		}
+		v, err := loc.decode(op.Value, do)
+		if err != nil {
+			return err
+		}
+		if op.Op == "test" {
+			if !loc.equal(v) {
+				return errors.New("test failed")
+			}
+			return nil
+		}
+		return loc.set(v, op.Op == "add")
+	case "remove":
+		loc, err := resolvePointer(e, pd, op.Path, false)
+		if err != nil {
+			return err
+		}
+		return loc.remove()
+	case "move", "copy":
+		if op.Op == "move" && strings.HasPrefix(op.Path, op.From+"/") {
+			return errors.New("value cannot be moved into itself")
+		}
+		from, err := resolvePointer(e, pd, op.From, false)
+		if err != nil {
+			return err
+		}
+		v := from.get()
+		if op.Op == "move" {
+			if err = from.remove(); err != nil {
+				return err
+			}
+		} else {
+			v.r.Entity = v.r.Entity.Clone()
+		}
+		loc, err := resolvePointer(e, pd, op.Path, true)
+		if err != nil {
+			return err
+		}
+		if !loc.compatible(from) {
+			return fmt.Errorf("value of %q cannot be stored at %q", op.From, op.Path)
+		}
+		return loc.set(v, true)
+	}
+	return fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// resolvePointer gets the location in the entity referred by JSON Pointer. If
+// the location is an item of a repeated field, which is about to be added, the
+// index may point past the last item or be "-".
+func resolvePointer(e *Entity, pd *MessageDef, pointer string, adding bool) (loc location, err error) {
+	loc = location{e: e, pd: pd, index: -1}
+	if pointer == "" {
+		return
+	}
+	if pointer[0] != '/' {
+		return loc, fmt.Errorf("invalid pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		last := i == len(tokens)-1
+		if loc.fp != nil && loc.fp.Repeated && loc.index < 0 {
+			if loc.index, err = parseIndex(token, loc.fp.Len(loc.e), adding && last); err != nil {
+				return
+			}
+			continue
+		}
+		// Going into the entity at current location.
+		nested, def := loc.entity()
+		if nested == nil {
+			return loc, fmt.Errorf("path %q doesn't exist", pointer)
+		}
+		if _, ok := getWellKnownCoder(def); ok && loc.fp != nil {
+			return loc, fmt.Errorf("path %q refers inside %s", pointer, def.QualifiedName())
+		}
+		p := getPlan(def)
+		n, ok := p.byName[token]
+		if !ok {
+			return loc, fmt.Errorf("unknown field %q of %s", token, def.QualifiedName())
+		}
+		loc = location{e: nested, pd: def, fp: &p.fields[n], index: -1}
+	}
+	return
+}
+
+func parseIndex(token string, n int, adding bool) (int, error) {
+	if token == "-" && adding {
+		return n, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid index %q", token)
+	}
+	if i > n || (i == n && !adding) {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// -----------------------------------------------------------------------------
+// Locations
+
+// entity gets the entity at the location along with its message definition,
+// or nil if the location doesn't refer a nested entity.
+func (loc location) entity() (*Entity, *MessageDef) {
+	switch {
+	case loc.fp == nil:
+		return loc.e, loc.pd
+	case !loc.fp.DataType.IsEntity() || (loc.fp.Repeated && loc.index < 0):
+		return nil, nil
+	}
+	return loc.get().r.Entity, loc.pd.Registry.GetMessageDef(loc.fp.DataType)
+}
+
+// single gets a value indicating whether the location holds a single value of
+// the field, rather than the collection of items.
+func (loc location) single() bool {
+	return loc.fp == nil || !loc.fp.Repeated || loc.index >= 0
+}
+
+// get gets the value at the location.
+func (loc location) get() value {
+	f := loc.fp
+	switch {
+	case f == nil:
+		return value{r: FromEntity(loc.e)}
+	case loc.index >= 0 && f.DataType.IsRefType():
+		return value{r: f.GetReferenceAt(loc.e, loc.index)}
+	case loc.index >= 0:
+		return value{p: f.GetPrimitiveAt(loc.e, loc.index)}
+	case f.DataType.IsRefType() || f.Repeated:
+		return value{r: f.GetReference(loc.e)}
+	default:
+		return value{p: f.GetPrimitive(loc.e)}
+	}
+}
+
+// set sets the value at the location, inserting the item into the repeated
+// field if requested.
+func (loc location) set(v value, insert bool) error {
+	f := loc.fp
+	switch {
+	case f == nil:
+		if v.r.Entity == nil {
+			return errors.New("entity cannot be null")
+		}
+		*loc.e = *v.r.Entity
+	case loc.index >= 0:
+		if insert {
+			f.Insert(loc.e, loc.index, 1)
+		}
+		if f.DataType.IsRefType() {
+			f.SetReferenceAt(loc.e, loc.index, v.r)
+		} else {
+			f.SetPrimitiveAt(loc.e, loc.index, v.p)
+		}
+	case f.DataType.IsRefType() || f.Repeated:
+		f.SetReference(loc.e, v.r)
+	default:
+		f.SetPrimitive(loc.e, v.p)
+	}
+	return nil
+}
+
+func (loc location) remove() error {
+	switch {
+	case loc.fp == nil:
+		return errors.New("entity cannot be removed")
+	case loc.index >= 0:
+		loc.fp.Remove(loc.e, loc.index, 1)
+	default:
+		resetField(loc.e, loc.fp.MessageFieldDef)
+	}
+	return nil
+}
+
+// decode decodes the value, which can be stored at the location.
+func (loc location) decode(data []byte, do decodeOptions) (v value, err error) {
+	dc := decoder{opts: do}
+	dc.lx.Reset(data)
+	dc.lx.Next()
+	f := loc.fp
+	switch {
+	case f == nil:
+		var e *Entity
+		if e, err = dc.decodeMessage(loc.pd); err == nil {
+			v.r = FromEntity(e)
+		}
+	case !loc.single():
+		// The items are decoded into a temporary entity, which then
+		// provides the collection of items.
+		temp := loc.pd.NewEntity()
+		if !dc.tryAccept(impl.TkNull) {
+			err = dc.decodeRepeated(temp, loc.pd, f)
+		}
+		v.r = f.GetReference(temp)
+	case f.DataType.IsRefType():
+		v.r, err = dc.decodeJsonRef(loc.pd, f)
+	default:
+		v.p, err = f.decodeValue(&dc)
+	}
+	if err == nil && !dc.lx.Eof() {
+		err = errors.New(dc.createErrorMessage(impl.TkEof))
+	}
+	return
+}
+
+// equal gets a value indicating whether the value at the location is equal to
+// the provided one.
+func (loc location) equal(v value) bool {
+	f := loc.fp
+	switch {
+	case f == nil:
+		return Equal(loc.e, v.r.Entity, loc.pd)
+	case !loc.single():
+		temp := loc.pd.NewEntity()
+		f.SetReference(temp, v.r)
+		return EqualField(loc.e, temp, f.MessageFieldDef, loc.pd)
+	case f.DataType.IsRefType():
+		return EqualReferences(loc.get().r, v.r, f.DataType, loc.pd.Registry)
+	default:
+		return loc.get().p == v.p
+	}
+}
+
+// compatible gets a value indicating whether the value at another location can
+// be stored at this one.
+func (loc location) compatible(other location) bool {
+	if loc.single() != other.single() {
+		return false
+	}
+	dt := func(l location) DataType {
+		if l.fp == nil {
+			return l.pd.DataType
+		}
+		return l.fp.DataType
+	}
+	return dt(loc) == dt(other)
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func isObject(pd *MessageDef, fp *fieldPlan) bool {
+	if !fp.DataType.IsEntity() {
+		return false
+	}
+	_, ok := getWellKnownCoder(pd.Registry.GetMessageDef(fp.DataType))
+	return !ok
+}
+
+func resetField(e *Entity, f *MessageFieldDef) {
+	if f.DataType.IsRefType() || f.Repeated {
+		f.SetReference(e, GetDefaultReference())
+	} else {
+		f.SetPrimitive(e, GetDefaultPrimitive())
+	}
+}
+
+// trimPrefix gets the message of the error without the prefix of the package.
+func trimPrefix(err error) string {
+	return strings.TrimPrefix(err.Error(), "dymessage: ")
+}
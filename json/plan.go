@@ -0,0 +1,153 @@
+package json
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// A codec plan compiled once for a message definition. The plan
+	// provides the encoders and decoders for each of the fields, so the
+	// data types of the fields are not inspected every time the entity is
+	// encoded or decoded.
+	plan struct {
+		fields []fieldPlan
+		// Indices of the field plans by names of the fields.
+		byName map[string]int
+		// The message definitions of the registry by their qualified
+		// names. Set only for google.protobuf.Any message, which
+		// refers the packed entities by the names of their types.
+		types map[string]*MessageDef
+	}
+
+	// A plan to encode and decode a single field of the message.
+	fieldPlan struct {
+		*MessageFieldDef
+		// The name of the field, encoded as a JSON string and followed
+		// by a colon.
+		name []byte
+
+		// Encodes the value of the field.
+		encode func(ec *encoder, e *Entity, pd *MessageDef, fp *fieldPlan) error
+
+		// Encodes and decodes a single item of the reference field. Not
+		// set for the primitive fields.
+		encodeItem func(ec *encoder, item *Entity, pd *MessageDef, fp *fieldPlan) error
+		decodeItem func(dc *decoder, pd *MessageDef, fp *fieldPlan) (Reference, error)
+		// Indicates whether the decoder of the items accepts null as a
+		// value rather than a null reference, like the decoder of
+		// google.protobuf.Value message does.
+		decodesNull bool
+
+		// Encodes and decodes a single primitive value. Not set for the
+		// reference fields.
+		encodeValue func(ec *encoder, value Primitive) error
+		decodeValue func(dc *decoder) (Primitive, error)
+	}
+)
+
+var planMarker PlanMarker
+
+func init() {
+	planMarker = RegisterPlan()
+}
+
+// getPlan gets the codec plan for the message definition, compiling it if
+// necessary.
+func getPlan(pd *MessageDef) *plan {
+	return pd.GetPlan(planMarker, compilePlan).(*plan)
+}
+
+func compilePlan(pd *MessageDef) interface{} {
+	p := &plan{
+		fields: make([]fieldPlan, len(pd.Fields)),
+		byName: make(map[string]int, len(pd.Fields)),
+	}
+	for i, f := range pd.Fields {
+		p.fields[i] = compileField(f)
+		if f.DataType.IsEntity() {
+			compileWellKnownCoders(&p.fields[i], pd.Registry.GetMessageDef(f.DataType))
+		}
+		p.byName[f.Name] = i
+	}
+	if pd.IsWellKnown(WellKnownAny) {
+		p.types = make(map[string]*MessageDef, len(pd.Registry.Defs))
+		for _, def := range pd.Registry.Defs {
+			if def != nil {
+				p.types[def.QualifiedName()] = def
+			}
+		}
+	}
+	return p
+}
+
+func compileField(f *MessageFieldDef) (fp fieldPlan) {
+	fp.MessageFieldDef = f
+	fp.name = append(appendString(nil, f.Name), ':')
+	switch {
+	case f.DataType == DtString:
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeString, (*decoder).decodeString
+	case f.DataType == DtBytes:
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeBytes, (*decoder).decodeBytes
+	case f.DataType.IsEntity():
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeEntity, (*decoder).decodeEntity
+	default:
+		compileValueCoders(&fp)
+	}
+	if lt, ok := f.TryGetLogicalType(); ok {
+		compileLogicalCoders(&fp, lt)
+	}
+	switch {
+	case f.Repeated && f.DataType.IsRefType():
+		fp.encode = (*encoder).encodeJsonRefs
+	case f.Repeated:
+		fp.encode = (*encoder).encodeJsonValues
+	case f.DataType.IsRefType():
+		fp.encode = (*encoder).encodeJsonRef
+	default:
+		fp.encode = (*encoder).encodeJsonValue
+	}
+	return
+}
+
+// compileWellKnownCoders replaces the item coders of the field, which refers
+// the entities of a well-known type, with the coders of this type.
+func compileWellKnownCoders(fp *fieldPlan, def *MessageDef) {
+	c, ok := getWellKnownCoder(def)
+	if !ok {
+		return
+	}
+	fp.encodeItem = func(ec *encoder, item *Entity, _ *MessageDef, _ *fieldPlan) error {
+		return c.encode(ec, item, def)
+	}
+	fp.decodesNull = def.IsWellKnown(WellKnownValue)
+	fp.decodeItem = func(dc *decoder, _ *MessageDef, _ *fieldPlan) (ref Reference, err error) {
+		var nested *Entity
+		if nested, err = c.decode(dc, def); err == nil {
+			ref = FromEntity(nested)
+		}
+		return
+	}
+}
+
+func compileValueCoders(fp *fieldPlan) {
+	switch fp.DataType {
+	case DtInt32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeInt32, (*decoder).decodeInt32
+	case DtInt64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeInt64, (*decoder).decodeInt64
+	case DtUint32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeUint32, (*decoder).decodeUint32
+	case DtUint64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeUint64, (*decoder).decodeUint64
+	case DtFloat32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeFloat32, (*decoder).decodeFloat32
+	case DtFloat64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeFloat64, (*decoder).decodeFloat64
+	case DtBool:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeBool, (*decoder).decodeBool
+	default:
+		panic(fmt.Sprintf("unsupported encoding data type %d", fp.DataType))
+	}
+}
@@ -0,0 +1,639 @@
+package json
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json/internal/impl"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+// Encodes and decodes the entities of a well-known type, which has a special
+// representation in JSON.
+type wellKnownCoder struct {
+	encode func(ec *encoder, e *Entity, pd *MessageDef) error
+	decode func(dc *decoder, pd *MessageDef) (*Entity, error)
+}
+
+var errNullEntry = errors.New("dymessage: struct has null entry")
+
+// The coders of the well-known types by their names.
+var wellKnownCoders map[string]wellKnownCoder
+
+func init() {
+	wellKnownCoders = map[string]wellKnownCoder{
+		WellKnownAny:       {(*encoder).encodeAny, (*decoder).decodeAny},
+		WellKnownTimestamp: {(*encoder).encodeTimestamp, (*decoder).decodeTimestamp},
+		WellKnownDuration:  {(*encoder).encodeDuration, (*decoder).decodeDuration},
+		WellKnownStruct:    {(*encoder).encodeStruct, (*decoder).decodeStruct},
+		WellKnownValue:     {(*encoder).encodeValueMsg, (*decoder).decodeValueMsg},
+		WellKnownListValue: {(*encoder).encodeListValue, (*decoder).decodeListValue},
+	}
+	for _, name := range []string{
+		WellKnownDoubleValue, WellKnownFloatValue,
+		WellKnownInt64Value, WellKnownUInt64Value,
+		WellKnownInt32Value, WellKnownUInt32Value,
+		WellKnownBoolValue, WellKnownStringValue, WellKnownBytesValue,
+	} {
+		wellKnownCoders[name] = wellKnownCoder{(*encoder).encodeWrapper, (*decoder).decodeWrapper}
+	}
+}
+
+// getWellKnownCoder gets the coder of the well-known type, if the message
+// definition represents one.
+func getWellKnownCoder(pd *MessageDef) (c wellKnownCoder, ok bool) {
+	if pd != nil && pd.Namespace == WellKnownNamespace {
+		c, ok = wellKnownCoders[pd.Name]
+	}
+	return
+}
+
+// encodeMessage encodes the entity, taking into account the special
+// representation of the well-known types.
+func (ec *encoder) encodeMessage(e *Entity, pd *MessageDef) error {
+	if c, ok := getWellKnownCoder(pd); ok {
+		return c.encode(ec, e, pd)
+	}
+	return ec.encode(e, pd)
+}
+
+// decodeMessage decodes the entity, taking into account the special
+// representation of the well-known types.
+func (dc *decoder) decodeMessage(pd *MessageDef) (*Entity, error) {
+	if c, ok := getWellKnownCoder(pd); ok {
+		return c.decode(dc, pd)
+	}
+	return dc.decode(pd)
+}
+
+// -----------------------------------------------------------------------------
+// Any
+
+// encodeAny encodes the entity of google.protobuf.Any message. The fields of
+// the packed entity are written along with the "@type" field, unless the
+// packed entity is of a well-known type itself, in which case its JSON
+// representation is written to the "value" field.
+func (ec *encoder) encodeAny(e *Entity, pd *MessageDef) (err error) {
+	url := pd.GetField(protobuf.TagAnyTypeUrl).GetReference(e).ToString()
+	value := pd.GetField(protobuf.TagAnyValue).GetReference(e).ToBytes()
+	if url == "" && len(value) == 0 {
+		ec.buf = append(ec.buf, '{', '}')
+		return
+	}
+	var def *MessageDef
+	if def, err = resolveAny(pd, url); err != nil {
+		return
+	}
+	var nested *Entity
+	if nested, err = protobuf.DecodeNew(value, def); err != nil {
+		return
+	}
+	ec.buf = append(ec.buf, `{"@type":`...)
+	ec.buf = appendString(ec.buf, url)
+	if _, ok := getWellKnownCoder(def); ok {
+		ec.buf = append(ec.buf, `,"value":`...)
+		if err = ec.encodeMessage(nested, def); err != nil {
+			return
+		}
+		ec.buf = append(ec.buf, '}')
+		return
+	}
+	n := len(ec.buf)
+	if err = ec.encode(nested, def); err != nil {
+		return
+	}
+	// Merging the fields of the packed entity into the object, which
+	// already contains the type.
+	if len(ec.buf)-n > 2 {
+		ec.buf[n] = ','
+	} else {
+		ec.buf = append(ec.buf[:n], '}')
+	}
+	return
+}
+
+// decodeAny decodes the entity of google.protobuf.Any message. Since the type
+// of the packed entity must be known before its fields are decoded, and the
+// "@type" field may be located anywhere in the object, the object is looked
+// through to find the type first, and then decoded from the beginning.
+func (dc *decoder) decodeAny(pd *MessageDef) (r *Entity, err error) {
+	start := dc.lx
+	var url string
+	var empty bool
+	if url, empty, err = dc.findAnyType(); err != nil {
+		return
+	}
+	r = pd.NewEntity()
+	if url == "" {
+		if !empty {
+			err = fmt.Errorf("dymessage: %v: expected \"@type\" field of Any", start.Tok.Pos)
+		}
+		return
+	}
+	var def *MessageDef
+	if def, err = resolveAny(pd, url); err != nil {
+		return
+	}
+	end := dc.lx
+	dc.lx = start
+	var nested *Entity
+	if _, ok := getWellKnownCoder(def); ok {
+		nested, err = dc.decodeAnyValue(def)
+	} else {
+		// The "@type" field is unknown to the message definition, so
+		// it's ignored by the decoder.
+		nested, err = dc.decode(def)
+	}
+	if err != nil {
+		return
+	}
+	dc.lx = end
+	var value []byte
+	if value, err = protobuf.Encode(nested, def); err != nil {
+		return
+	}
+	pd.GetField(protobuf.TagAnyTypeUrl).SetReference(r, FromString(url))
+	pd.GetField(protobuf.TagAnyValue).SetReference(r, FromBytes(value, false))
+	return
+}
+
+// findAnyType looks through the object to find its "@type" field. The empty
+// flag indicates whether the object doesn't have any fields.
+func (dc *decoder) findAnyType() (url string, empty bool, err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
+	if err = dc.accept(impl.TkCrBrOpen); err != nil {
+		return
+	}
+	if dc.tryAccept(impl.TkCrBrClose) {
+		dc.leave()
+		return "", true, nil
+	}
+	for {
+		var name string
+		if name, err = dc.acceptValue(impl.TkString); err != nil {
+			return
+		}
+		if err = dc.accept(impl.TkColon); err != nil {
+			return
+		}
+		if name == "@type" {
+			url, err = dc.acceptValue(impl.TkString)
+		} else {
+			err = dc.ignoreValue()
+		}
+		if err != nil {
+			return
+		}
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	err = dc.accept(impl.TkCrBrClose)
+	dc.leave()
+	return
+}
+
+// decodeAnyValue decodes the object of Any, which contains the entity of a
+// well-known type in its "value" field.
+func (dc *decoder) decodeAnyValue(pd *MessageDef) (r *Entity, err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
+	if err = dc.accept(impl.TkCrBrOpen); err != nil {
+		return
+	}
+	for !dc.probably(impl.TkCrBrClose) {
+		var name string
+		if name, err = dc.acceptValue(impl.TkString); err != nil {
+			return
+		}
+		if err = dc.accept(impl.TkColon); err != nil {
+			return
+		}
+		if name == "value" {
+			r, err = dc.decodeMessage(pd)
+		} else {
+			err = dc.ignoreValue()
+		}
+		if err != nil {
+			return
+		}
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	if err = dc.accept(impl.TkCrBrClose); err != nil {
+		return
+	}
+	if r == nil {
+		r = pd.NewEntity()
+	}
+	dc.leave()
+	return
+}
+
+// resolveAny gets the message definition of the type, which is referred by the
+// type URL of Any, from the registry the definition of Any belongs to.
+func resolveAny(pd *MessageDef, url string) (*MessageDef, error) {
+	name := url[strings.LastIndexByte(url, '/')+1:]
+	if def, ok := getPlan(pd).types[name]; ok {
+		return def, nil
+	}
+	return nil, fmt.Errorf("dymessage: type %q could not be found", name)
+}
+
+// -----------------------------------------------------------------------------
+// Wrappers
+
+// encodeWrapper encodes the entity of a wrapper message, like Int32Value, as a
+// bare value of the wrapped type.
+func (ec *encoder) encodeWrapper(e *Entity, pd *MessageDef) error {
+	fp := &getPlan(pd).fields[0]
+	if !fp.DataType.IsRefType() {
+		return fp.encodeValue(ec, fp.GetPrimitive(e))
+	}
+	item := e.Entities[fp.Offset]
+	if item == nil {
+		// The default value of the strings and bytes.
+		item = &Entity{}
+	}
+	return fp.encodeItem(ec, item, pd, fp)
+}
+
+// decodeWrapper decodes the entity of a wrapper message, like Int32Value, from
+// a bare value of the wrapped type.
+func (dc *decoder) decodeWrapper(pd *MessageDef) (r *Entity, err error) {
+	fp := &getPlan(pd).fields[0]
+	r = pd.NewEntity()
+	if !fp.DataType.IsRefType() {
+		var value Primitive
+		if value, err = fp.decodeValue(dc); err == nil {
+			fp.SetPrimitive(r, value)
+		}
+		return
+	}
+	var ref Reference
+	if ref, err = fp.decodeItem(dc, pd, fp); err == nil {
+		fp.SetReference(r, ref)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Struct, Value and ListValue
+
+// encodeStruct encodes the entity of google.protobuf.Struct message as a JSON
+// object, which contains the fields of the struct in their order.
+func (ec *encoder) encodeStruct(e *Entity, pd *MessageDef) (err error) {
+	f := pd.GetField(protobuf.TagStructFields)
+	entryDef := pd.Registry.GetMessageDef(f.DataType)
+	keyField := entryDef.GetField(protobuf.TagStructEntryKey)
+	valueField := entryDef.GetField(protobuf.TagStructEntryValue)
+	valueDef := pd.Registry.GetMessageDef(valueField.DataType)
+	ec.buf = append(ec.buf, '{')
+	n := f.Len(e)
+	for i := 0; i < n; i++ {
+		entry := f.GetReferenceAt(e, i).ToEntity()
+		if entry == nil {
+			return errNullEntry
+		}
+		if i > 0 {
+			ec.buf = append(ec.buf, ',')
+		}
+		ec.buf = appendString(ec.buf, keyField.GetReference(entry).ToString())
+		ec.buf = append(ec.buf, ':')
+		if err = ec.encodeValueMsg(valueField.GetReference(entry).ToEntity(), valueDef); err != nil {
+			return
+		}
+	}
+	ec.buf = append(ec.buf, '}')
+	return
+}
+
+// decodeStruct decodes the entity of google.protobuf.Struct message from a JSON
+// object with arbitrary fields.
+func (dc *decoder) decodeStruct(pd *MessageDef) (r *Entity, err error) {
+	f := pd.GetField(protobuf.TagStructFields)
+	entryDef := pd.Registry.GetMessageDef(f.DataType)
+	keyField := entryDef.GetField(protobuf.TagStructEntryKey)
+	valueField := entryDef.GetField(protobuf.TagStructEntryValue)
+	valueDef := pd.Registry.GetMessageDef(valueField.DataType)
+	if err = dc.enter(); err != nil {
+		return
+	}
+	if err = dc.accept(impl.TkCrBrOpen); err != nil {
+		return
+	}
+	r = pd.NewEntity()
+	for !dc.probably(impl.TkCrBrClose) {
+		if err = dc.opts.limits.CheckRepeated(f, f.Len(r)+1); err != nil {
+			return
+		}
+		var key string
+		if key, err = dc.acceptValue(impl.TkString); err != nil {
+			return
+		}
+		if err = dc.accept(impl.TkColon); err != nil {
+			return
+		}
+		var value *Entity
+		if value, err = dc.decodeValueMsg(valueDef); err != nil {
+			return
+		}
+		entry := entryDef.NewEntity()
+		keyField.SetReference(entry, FromString(key))
+		valueField.SetReference(entry, FromEntity(value))
+		f.SetReferenceAt(r, f.Reserve(r, 1), FromEntity(entry))
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	if err = dc.accept(impl.TkCrBrClose); err != nil {
+		return
+	}
+	dc.leave()
+	return
+}
+
+// encodeValueMsg encodes the entity of google.protobuf.Value message as a JSON
+// value of the kind the entity holds. The nil entity and the entity, which
+// kind is not set, are encoded as null.
+func (ec *encoder) encodeValueMsg(e *Entity, pd *MessageDef) (err error) {
+	var kind uint64
+	if e != nil {
+		kind = pd.GetField(protobuf.TagValueKind).GetPrimitive(e).ToUint64()
+	}
+	switch kind {
+	case protobuf.TagValueNumber:
+		number := pd.GetField(kind).GetPrimitive(e).ToFloat64()
+		ec.buf, err = appendFloat(ec.buf, number, 64)
+	case protobuf.TagValueString:
+		ec.buf = appendString(ec.buf, pd.GetField(kind).GetReference(e).ToString())
+	case protobuf.TagValueBool:
+		ec.buf = appendBool(ec.buf, pd.GetField(kind).GetPrimitive(e).ToBool())
+	case protobuf.TagValueStruct, protobuf.TagValueList:
+		f := pd.GetField(kind)
+		def := pd.Registry.GetMessageDef(f.DataType)
+		nested := f.GetReference(e).ToEntity()
+		if nested == nil {
+			nested = def.NewEntity()
+		}
+		err = ec.encodeMessage(nested, def)
+	default:
+		ec.buf = append(ec.buf, "null"...)
+	}
+	return
+}
+
+// decodeValueMsg decodes the entity of google.protobuf.Value message from an
+// arbitrary JSON value, including null.
+func (dc *decoder) decodeValueMsg(pd *MessageDef) (r *Entity, err error) {
+	r = pd.NewEntity()
+	var kind uint64
+	switch {
+	case dc.tryAccept(impl.TkNull):
+		kind = protobuf.TagValueNull
+	case dc.probably(impl.TkNumber):
+		kind = protobuf.TagValueNumber
+		var number float64
+		if number, err = dc.acceptFloat(64); err == nil {
+			pd.GetField(kind).SetPrimitive(r, FromFloat64(number))
+		}
+	case dc.probably(impl.TkString):
+		kind = protobuf.TagValueString
+		f := pd.GetField(kind)
+		var ref Reference
+		if ref, err = dc.decodeString(pd, &fieldPlan{MessageFieldDef: f}); err == nil {
+			f.SetReference(r, ref)
+		}
+	case dc.probably(impl.TkTrue) || dc.probably(impl.TkFalse):
+		kind = protobuf.TagValueBool
+		var b bool
+		if b, err = dc.acceptBool(); err == nil {
+			pd.GetField(kind).SetPrimitive(r, FromBool(b))
+		}
+	case dc.probably(impl.TkCrBrOpen) || dc.probably(impl.TkSqBrOpen):
+		kind = protobuf.TagValueStruct
+		if dc.probably(impl.TkSqBrOpen) {
+			kind = protobuf.TagValueList
+		}
+		f := pd.GetField(kind)
+		var nested *Entity
+		if nested, err = dc.decodeMessage(pd.Registry.GetMessageDef(f.DataType)); err == nil {
+			f.SetReference(r, FromEntity(nested))
+		}
+	default:
+		if dc.lx.Err != nil {
+			return nil, dc.lx.Err
+		}
+		return nil, errors.New(dc.createErrorMessage())
+	}
+	if err != nil {
+		return nil, err
+	}
+	pd.GetField(protobuf.TagValueKind).SetPrimitive(r, FromUint64(kind))
+	return
+}
+
+// encodeListValue encodes the entity of google.protobuf.ListValue message as a
+// JSON array of the values.
+func (ec *encoder) encodeListValue(e *Entity, pd *MessageDef) (err error) {
+	f := pd.GetField(protobuf.TagListValues)
+	valueDef := pd.Registry.GetMessageDef(f.DataType)
+	ec.buf = append(ec.buf, '[')
+	n := f.Len(e)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			ec.buf = append(ec.buf, ',')
+		}
+		if err = ec.encodeValueMsg(f.GetReferenceAt(e, i).ToEntity(), valueDef); err != nil {
+			return
+		}
+	}
+	ec.buf = append(ec.buf, ']')
+	return
+}
+
+// decodeListValue decodes the entity of google.protobuf.ListValue message from
+// a JSON array of arbitrary values.
+func (dc *decoder) decodeListValue(pd *MessageDef) (r *Entity, err error) {
+	f := pd.GetField(protobuf.TagListValues)
+	valueDef := pd.Registry.GetMessageDef(f.DataType)
+	if err = dc.enter(); err != nil {
+		return
+	}
+	if err = dc.accept(impl.TkSqBrOpen); err != nil {
+		return
+	}
+	r = pd.NewEntity()
+	for !dc.probably(impl.TkSqBrClose) {
+		if err = dc.opts.limits.CheckRepeated(f, f.Len(r)+1); err != nil {
+			return
+		}
+		var value *Entity
+		if value, err = dc.decodeValueMsg(valueDef); err != nil {
+			return
+		}
+		f.SetReferenceAt(r, f.Reserve(r, 1), FromEntity(value))
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	if err = dc.accept(impl.TkSqBrClose); err != nil {
+		return
+	}
+	dc.leave()
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Timestamp and Duration
+
+// The ranges of the values of Timestamp and Duration, as defined by the
+// comments in timestamp.proto and duration.proto.
+const (
+	minTimestampSeconds = -62135596800 // 0001-01-01T00:00:00Z
+	maxTimestampSeconds = 253402300799 // 9999-12-31T23:59:59Z
+	maxDurationSeconds  = 315576000000 // About 10,000 years
+)
+
+// encodeTimestamp encodes the entity of google.protobuf.Timestamp message as an
+// RFC 3339 string in UTC with 0, 3, 6 or 9 fractional digits.
+func (ec *encoder) encodeTimestamp(e *Entity, pd *MessageDef) error {
+	seconds := pd.GetField(protobuf.TagTimestampSeconds).GetPrimitive(e).ToInt64()
+	nanos := pd.GetField(protobuf.TagTimestampNanos).GetPrimitive(e).ToInt32()
+	if seconds < minTimestampSeconds || seconds > maxTimestampSeconds || nanos < 0 || nanos >= 1e9 {
+		return fmt.Errorf("dymessage: timestamp of %d seconds and %d nanos is out of range", seconds, nanos)
+	}
+	ec.buf = append(ec.buf, '"')
+	ec.buf = time.Unix(seconds, 0).UTC().AppendFormat(ec.buf, "2006-01-02T15:04:05")
+	ec.buf = appendNanos(ec.buf, nanos)
+	ec.buf = append(ec.buf, 'Z', '"')
+	return nil
+}
+
+// decodeTimestamp decodes the entity of google.protobuf.Timestamp message from
+// an RFC 3339 string, which may have any time zone offset.
+func (dc *decoder) decodeTimestamp(pd *MessageDef) (r *Entity, err error) {
+	pos := dc.lx.Tok.Pos
+	var str string
+	if str, err = dc.acceptValue(impl.TkString); err != nil {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil || t.Unix() < minTimestampSeconds || t.Unix() > maxTimestampSeconds {
+		return nil, fmt.Errorf("dymessage: %v: invalid timestamp %q", pos, str)
+	}
+	return protobuf.FromTime(t, pd), nil
+}
+
+// encodeDuration encodes the entity of google.protobuf.Duration message as the
+// number of seconds with 0, 3, 6 or 9 fractional digits, followed by the "s"
+// suffix.
+func (ec *encoder) encodeDuration(e *Entity, pd *MessageDef) error {
+	seconds := pd.GetField(protobuf.TagDurationSeconds).GetPrimitive(e).ToInt64()
+	nanos := pd.GetField(protobuf.TagDurationNanos).GetPrimitive(e).ToInt32()
+	if seconds < -maxDurationSeconds || seconds > maxDurationSeconds ||
+		nanos <= -1e9 || nanos >= 1e9 || seconds < 0 && nanos > 0 || seconds > 0 && nanos < 0 {
+		return fmt.Errorf("dymessage: duration of %d seconds and %d nanos is out of range", seconds, nanos)
+	}
+	ec.buf = append(ec.buf, '"')
+	if seconds < 0 || nanos < 0 {
+		ec.buf = append(ec.buf, '-')
+		seconds, nanos = -seconds, -nanos
+	}
+	ec.buf = strconv.AppendInt(ec.buf, seconds, 10)
+	ec.buf = appendNanos(ec.buf, nanos)
+	ec.buf = append(ec.buf, 's', '"')
+	return nil
+}
+
+// decodeDuration decodes the entity of google.protobuf.Duration message from the
+// number of seconds with up to 9 fractional digits, followed by the "s" suffix.
+func (dc *decoder) decodeDuration(pd *MessageDef) (r *Entity, err error) {
+	pos := dc.lx.Tok.Pos
+	var str string
+	if str, err = dc.acceptValue(impl.TkString); err != nil {
+		return
+	}
+	seconds, nanos, ok := parseDuration(str)
+	if !ok {
+		return nil, fmt.Errorf("dymessage: %v: invalid duration %q", pos, str)
+	}
+	r = pd.NewEntity()
+	pd.GetField(protobuf.TagDurationSeconds).SetPrimitive(r, FromInt64(seconds))
+	pd.GetField(protobuf.TagDurationNanos).SetPrimitive(r, FromInt32(nanos))
+	return
+}
+
+// parseDuration parses the JSON representation of Duration. The nanos have the
+// same sign as the seconds.
+func parseDuration(str string) (seconds int64, nanos int32, ok bool) {
+	if !strings.HasSuffix(str, "s") {
+		return
+	}
+	str = str[:len(str)-1]
+	neg := strings.HasPrefix(str, "-")
+	if neg {
+		str = str[1:]
+	}
+	whole, frac := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		whole, frac = str[:i], str[i+1:]
+		if !isDigits(frac) || len(frac) > 9 {
+			return
+		}
+	}
+	if !isDigits(whole) {
+		return
+	}
+	var err error
+	if seconds, err = strconv.ParseInt(whole, 10, 64); err != nil || seconds > maxDurationSeconds {
+		return
+	}
+	if frac != "" {
+		n, _ := strconv.Atoi(frac + strings.Repeat("0", 9-len(frac)))
+		nanos = int32(n)
+	}
+	if neg {
+		seconds, nanos = -seconds, -nanos
+	}
+	return seconds, nanos, true
+}
+
+// appendNanos appends the fraction of a second, omitting the trailing groups of
+// three zeros. The nanos must not be negative.
+func appendNanos(buf []byte, nanos int32) []byte {
+	if nanos == 0 {
+		return buf
+	}
+	digits := 9
+	for nanos%1000 == 0 {
+		nanos /= 1000
+		digits -= 3
+	}
+	str := strconv.Itoa(int(nanos))
+	buf = append(buf, '.')
+	for i := len(str); i < digits; i++ {
+		buf = append(buf, '0')
+	}
+	return append(buf, str...)
+}
+
+func isDigits(str string) bool {
+	if str == "" {
+		return false
+	}
+	for i := 0; i < len(str); i++ {
+		if str[i] < '0' || str[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package dymessage
+
+import (
+	"fmt"
+)
+
+type (
+	// Represents a logical type, like a decimal, a UUID or a date, which
+	// is stored as a value of one of the primitive or reference data types,
+	// but is validated and represented specially by the encoders. The
+	// logical types are registered globally by RegisterLogicalType and
+	// assigned to the fields with WithLogicalType.
+	LogicalType interface {
+		// Name gets the name of the logical type, which is unique among
+		// the registered ones, like "uuid".
+		Name() string
+		// Supports gets a value indicating whether the values of the
+		// data type can store the values of the logical type.
+		Supports(dt DataType) bool
+		// Validate checks whether the value is valid for the logical
+		// type.
+		Validate(v LogicalValue) error
+
+		// FormatJSON gets the JSON representation of the value, which
+		// must be a string, a number or a literal.
+		FormatJSON(v LogicalValue) ([]byte, error)
+		// ParseJSON gets the value of the data type from its JSON
+		// representation.
+		ParseJSON(dt DataType, data []byte) (LogicalValue, error)
+
+		// FormatText gets the human-readable representation of the
+		// value, which is used by the printer.
+		FormatText(v LogicalValue) ([]byte, error)
+		// ParseText gets the value of the data type from its
+		// human-readable representation.
+		ParseText(dt DataType, text []byte) (LogicalValue, error)
+
+		// ProtoAnnotation gets the annotation, which is written as a
+		// comment next to the fields of the logical type in the .proto
+		// files, or an empty string if the fields are not annotated.
+		ProtoAnnotation() string
+	}
+
+	// Represents a single value of the field of a logical type. Depending
+	// on the data type, either the primitive value or the reference is set.
+	LogicalValue struct {
+		DataType  DataType
+		Primitive Primitive
+		Reference Reference
+	}
+)
+
+var (
+	logicalMarker ExtensionMarker
+	logicalTypes  = make(map[string]LogicalType)
+)
+
+func init() {
+	logicalMarker = RegisterExtension()
+}
+
+// RegisterLogicalType registers the logical type globally, so it could be
+// assigned to the fields by its name. This must be called during init() of the
+// package, which provides the logical type. If a logical type with the same
+// name has already been registered, the method will panic.
+func RegisterLogicalType(lt LogicalType) {
+	name := lt.Name()
+	if _, ok := logicalTypes[name]; ok {
+		panic(fmt.Sprintf("logical type %q has already been registered", name))
+	}
+	logicalTypes[name] = lt
+}
+
+// LookupLogicalType gets the registered logical type by its name.
+func LookupLogicalType(name string) (LogicalType, bool) {
+	lt, ok := logicalTypes[name]
+	return lt, ok
+}
+
+// WithLogicalType produces a function to extend the message field definition,
+// indicating that the values of the field represent the registered logical
+// type with specified name. If the logical type has not been registered or it
+// doesn't support the data type of the field, the function will panic.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func WithLogicalType(name string) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		lt, ok := LookupLogicalType(name)
+		if !ok {
+			panic(fmt.Sprintf("logical type %q has not been registered", name))
+		}
+		if f.DataType.IsEntity() || !lt.Supports(f.DataType) {
+			panic(fmt.Sprintf("logical type %q doesn't support data type %d", name, f.DataType))
+		}
+		if _, ok := f.TryGetExtension(logicalMarker); ok {
+			panic("logical type has already been specified")
+		}
+		f.SetExtension(logicalMarker, lt)
+	}
+}
+
+// TryGetLogicalType gets the logical type of the field, if the field has been
+// extended with one.
+func (f *MessageFieldDef) TryGetLogicalType() (LogicalType, bool) {
+	if lt, ok := f.TryGetExtension(logicalMarker); ok {
+		return lt.(LogicalType), true
+	}
+	return nil, false
+}
+
+// -----------------------------------------------------------------------------
+// Logical values
+
+// PrimitiveValue creates a logical value of the primitive data type.
+func PrimitiveValue(dt DataType, p Primitive) LogicalValue {
+	return LogicalValue{DataType: dt, Primitive: p}
+}
+
+// ReferenceValue creates a logical value of the reference data type.
+func ReferenceValue(dt DataType, r Reference) LogicalValue {
+	return LogicalValue{DataType: dt, Reference: r}
+}
@@ -0,0 +1,74 @@
+package logical
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// The range of the dates from 0001-01-01 to 9999-12-31 in days since the Unix
+// epoch.
+const (
+	minDate = -719162
+	maxDate = 2932896
+)
+
+// Represents a calendar date without a time zone, which is stored as a 32-bit
+// integer number of days since the Unix epoch. The JSON and text
+// representations of the date are in the "2006-01-02" format.
+type dateType struct{}
+
+func (dateType) Name() string { return Date }
+
+func (dateType) Supports(dt DataType) bool { return dt == DtInt32 }
+
+func (dateType) Validate(v LogicalValue) error {
+	if days := v.Primitive.ToInt32(); days < minDate || days > maxDate {
+		return fmt.Errorf("date of %d days since the epoch is out of range", days)
+	}
+	return nil
+}
+
+func (t dateType) FormatJSON(v LogicalValue) ([]byte, error) { return quote(t.FormatText(v)) }
+
+func (t dateType) ParseJSON(dt DataType, data []byte) (LogicalValue, error) {
+	text, err := unquote(data)
+	if err != nil {
+		return LogicalValue{}, err
+	}
+	return t.ParseText(dt, text)
+}
+
+func (t dateType) FormatText(v LogicalValue) ([]byte, error) {
+	if err := t.Validate(v); err != nil {
+		return nil, err
+	}
+	days := int64(v.Primitive.ToInt32())
+	return time.Unix(days*24*60*60, 0).UTC().AppendFormat(nil, "2006-01-02"), nil
+}
+
+func (dateType) ParseText(dt DataType, text []byte) (LogicalValue, error) {
+	d, err := time.Parse("2006-01-02", string(text))
+	if err != nil {
+		return LogicalValue{}, fmt.Errorf("invalid date %q", text)
+	}
+	days := d.Unix() / (24 * 60 * 60)
+	return PrimitiveValue(dt, FromInt32(int32(days))), nil
+}
+
+func (dateType) ProtoAnnotation() string { return "date: days since 1970-01-01" }
+
+// DateFromTime gets the logical value of the date, which contains the time. The
+// date is taken in the time zone of the time.
+func DateFromTime(t time.Time) LogicalValue {
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+	return PrimitiveValue(DtInt32, FromInt32(int32(days)))
+}
+
+// DateToTime gets the midnight of the date in UTC.
+func DateToTime(v LogicalValue) time.Time {
+	days := int64(v.Primitive.ToInt32())
+	return time.Unix(days*24*60*60, 0).UTC()
+}
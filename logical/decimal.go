@@ -0,0 +1,77 @@
+package logical
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// Represents a decimal number of an arbitrary precision, which is stored as a
+// string, like "-12.50". The JSON representation of the decimal is a string
+// too, which prevents the loss of precision by the JSON parsers, though the
+// numbers are accepted when decoding.
+type decimalType struct{}
+
+func (decimalType) Name() string { return Decimal }
+
+func (decimalType) Supports(dt DataType) bool { return dt == DtString }
+
+func (decimalType) Validate(v LogicalValue) error {
+	return validateDecimal(v.Reference.ToBytes())
+}
+
+func (t decimalType) FormatJSON(v LogicalValue) ([]byte, error) { return quote(t.FormatText(v)) }
+
+func (t decimalType) ParseJSON(dt DataType, data []byte) (LogicalValue, error) {
+	if len(data) > 0 && data[0] != '"' {
+		return t.ParseText(dt, data)
+	}
+	text, err := unquote(data)
+	if err != nil {
+		return LogicalValue{}, err
+	}
+	return t.ParseText(dt, text)
+}
+
+func (decimalType) FormatText(v LogicalValue) ([]byte, error) {
+	text := v.Reference.ToBytes()
+	if err := validateDecimal(text); err != nil {
+		return nil, err
+	}
+	return text, nil
+}
+
+func (decimalType) ParseText(dt DataType, text []byte) (LogicalValue, error) {
+	if err := validateDecimal(text); err != nil {
+		return LogicalValue{}, err
+	}
+	return ReferenceValue(dt, FromString(string(text))), nil
+}
+
+func (decimalType) ProtoAnnotation() string { return "decimal" }
+
+// validateDecimal checks whether the text contains a decimal number with an
+// optional sign and fractional part, and without an exponent.
+func validateDecimal(text []byte) error {
+	i := 0
+	if i < len(text) && text[i] == '-' {
+		i++
+	}
+	digits := 0
+	for ; i < len(text) && isDigit(text[i]); i++ {
+		digits++
+	}
+	if digits > 0 && i < len(text) && text[i] == '.' {
+		i++
+		digits = 0
+		for ; i < len(text) && isDigit(text[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 || i < len(text) {
+		return fmt.Errorf("invalid decimal %q", text)
+	}
+	return nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,53 @@
+// Package logical provides the commonly used logical types, which are layered
+// over the primitive data types of the message fields. The types are
+// registered when the package is imported and assigned to the fields with
+// the WithLogicalType function of the dymessage package:
+//
+//	mb.WithField("Id", 1, DtBytes).ExtendField(WithLogicalType(logical.UUID))
+//
+// The json package validates the values of the logical types and writes them
+// in their JSON representation, while the protobuf package encodes them as
+// the values of the underlying data types.
+package logical
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// Names of the logical types provided by the package.
+const (
+	UUID    = "uuid"
+	Date    = "date"
+	Decimal = "decimal"
+	Money   = "money"
+)
+
+func init() {
+	RegisterLogicalType(uuidType{})
+	RegisterLogicalType(dateType{})
+	RegisterLogicalType(decimalType{})
+	RegisterLogicalType(moneyType{})
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+// quote gets the JSON string, which contains the text.
+func quote(text []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// unquote gets the text contained in the JSON string.
+func unquote(data []byte) ([]byte, error) {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return nil, fmt.Errorf("expected string, but got %s", data)
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,94 @@
+package logical
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json"
+)
+
+func createTestDef() *MessageDef {
+	rb := NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithName("Message").
+		WithField("Id", 1, DtBytes).ExtendField(WithLogicalType(UUID)).
+		WithField("Ref", 2, DtString).ExtendField(WithLogicalType(UUID)).
+		WithField("Born", 3, DtInt32).ExtendField(WithLogicalType(Date)).
+		WithArrayField("Prices", 4, DtString).ExtendField(WithLogicalType(Decimal)).
+		WithField("Cost", 5, DtString).ExtendField(WithLogicalType(Money)).
+		Build()
+	rb.Build()
+	return def
+}
+
+func TestJson(t *testing.T) {
+	def := createTestDef()
+	data := `{"Id":"123e4567-e89b-12d3-a456-426655440000","Ref":"123E4567-E89B-12D3-A456-426655440000",` +
+		`"Born":"2019-05-01","Prices":["12.50",-3],"Cost":"-0.99 EUR"}`
+	e, err := json.DecodeNew([]byte(data), def)
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x55, 0x44, 0x00, 0x00},
+		def.GetField(1).GetReference(e).ToBytes())
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426655440000", def.GetField(2).GetReference(e).ToString())
+	born := PrimitiveValue(DtInt32, def.GetField(3).GetPrimitive(e))
+	assert.Equal(t, time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC), DateToTime(born))
+	assert.Equal(t, "-3", def.GetField(4).GetReferenceAt(e, 1).ToString())
+	assert.Equal(t, "-0.99 EUR", def.GetField(5).GetReference(e).ToString())
+
+	actual, err := json.Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"Id":"123e4567-e89b-12d3-a456-426655440000","Ref":"123e4567-e89b-12d3-a456-426655440000",`+
+			`"Born":"2019-05-01","Prices":["12.50","-3"],"Cost":"-0.99 EUR"}`, string(actual))
+
+	for _, s := range []string{
+		`{"Id":"123e4567-e89b-12d3-a456-42665544000"}`,
+		`{"Id":"123e4567-e89b-12d3-a456_426655440000"}`,
+		`{"Born":"2019-02-30"}`,
+		`{"Born":17000}`,
+		`{"Prices":["1e3"]}`,
+		`{"Prices":["1."]}`,
+		`{"Cost":"12.50"}`,
+		`{"Cost":"12.50 usd"}`,
+		`{"Cost":"12.50  USD"}`,
+		`{"Cost":12.5}`,
+	} {
+		_, err = json.DecodeNew([]byte(s), def)
+		assert.Error(t, err, s)
+	}
+	_, err = json.DecodeNew([]byte(`{"Born":"2019-5-1"}`), def)
+	assert.EqualError(t, err, `dymessage: (1:9): invalid value of date: invalid date "2019-5-1"`)
+
+	def.GetField(1).SetReference(e, FromBytes([]byte{1, 2, 3}, false))
+	_, err = json.Encode(e, def)
+	assert.EqualError(t, err, `dymessage: invalid value of field "Id": expected 16 bytes of UUID, but got 3`)
+}
+
+func TestSprint(t *testing.T) {
+	def := createTestDef()
+	e := def.NewEntity()
+	def.GetField(1).SetReference(e, FromBytes([]byte{1, 2}, false))
+	def.GetField(3).SetPrimitive(e, DateFromTime(time.Date(1969, 12, 31, 23, 0, 0, 0, time.UTC)).Primitive)
+	def.GetField(4).Reserve(e, 1)
+	def.GetField(4).SetReferenceAt(e, 0, FromString("0.01"))
+	def.GetField(5).SetReference(e, FromString("100 JPY"))
+	// The invalid values are printed as the values of the underlying types.
+	assert.Equal(t, `Message{Id: 0x0102, Ref: "", Born: 1969-12-31, Prices: [0.01], Cost: 100 JPY}`, Sprint(e, def))
+}
+
+func TestWithLogicalType(t *testing.T) {
+	rb := NewRegistryBuilder()
+	mb := rb.ForMessageDef("message")
+	assert.Panics(t, func() { mb.WithField("Id", 1, DtInt64).ExtendField(WithLogicalType(UUID)) })
+	assert.Panics(t, func() { mb.WithField("Unknown", 2, DtString).ExtendField(WithLogicalType("unknown")) })
+	assert.Panics(t, func() { RegisterLogicalType(uuidType{}) })
+	lt, ok := LookupLogicalType(Decimal)
+	assert.True(t, ok)
+	assert.Equal(t, "decimal", lt.ProtoAnnotation())
+	assert.Panics(t, func() { mb.WithField("Cost", 3, DtFloat64).ExtendField(WithLogicalType(Money)) })
+}
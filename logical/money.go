@@ -0,0 +1,67 @@
+package logical
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// Represents an amount of money in a currency, which is stored as a string of
+// the decimal amount followed by the ISO 4217 code of the currency, like
+// "12.50 USD". The JSON and text representations of the money are the same
+// as the stored one.
+type moneyType struct{}
+
+func (moneyType) Name() string { return Money }
+
+func (moneyType) Supports(dt DataType) bool { return dt == DtString }
+
+func (moneyType) Validate(v LogicalValue) error {
+	return validateMoney(v.Reference.ToBytes())
+}
+
+func (t moneyType) FormatJSON(v LogicalValue) ([]byte, error) { return quote(t.FormatText(v)) }
+
+func (t moneyType) ParseJSON(dt DataType, data []byte) (LogicalValue, error) {
+	text, err := unquote(data)
+	if err != nil {
+		return LogicalValue{}, err
+	}
+	return t.ParseText(dt, text)
+}
+
+func (moneyType) FormatText(v LogicalValue) ([]byte, error) {
+	text := v.Reference.ToBytes()
+	if err := validateMoney(text); err != nil {
+		return nil, err
+	}
+	return text, nil
+}
+
+func (moneyType) ParseText(dt DataType, text []byte) (LogicalValue, error) {
+	if err := validateMoney(text); err != nil {
+		return LogicalValue{}, err
+	}
+	return ReferenceValue(dt, FromString(string(text))), nil
+}
+
+func (moneyType) ProtoAnnotation() string { return "money" }
+
+// validateMoney checks whether the text contains a decimal amount and a code of
+// the currency of three uppercase letters separated by a single space.
+func validateMoney(text []byte) error {
+	n := len(text) - 4
+	if n < 1 || text[n] != ' ' || !isCurrency(text[n+1:]) || validateDecimal(text[:n]) != nil {
+		return fmt.Errorf("invalid money %q", text)
+	}
+	return nil
+}
+
+func isCurrency(code []byte) bool {
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
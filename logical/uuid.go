@@ -0,0 +1,95 @@
+package logical
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// Represents a UUID, which is stored either as 16 bytes or as a string in the
+// canonical form, like "123e4567-e89b-12d3-a456-426655440000". The JSON and
+// text representations are always in the canonical form.
+type uuidType struct{}
+
+func (uuidType) Name() string { return UUID }
+
+func (uuidType) Supports(dt DataType) bool { return dt == DtBytes || dt == DtString }
+
+func (uuidType) Validate(v LogicalValue) error {
+	_, err := getUUID(v)
+	return err
+}
+
+func (t uuidType) FormatJSON(v LogicalValue) ([]byte, error) { return quote(t.FormatText(v)) }
+
+func (t uuidType) ParseJSON(dt DataType, data []byte) (LogicalValue, error) {
+	text, err := unquote(data)
+	if err != nil {
+		return LogicalValue{}, err
+	}
+	return t.ParseText(dt, text)
+}
+
+func (uuidType) FormatText(v LogicalValue) ([]byte, error) {
+	u, err := getUUID(v)
+	if err != nil {
+		return nil, err
+	}
+	return formatUUID(u), nil
+}
+
+func (uuidType) ParseText(dt DataType, text []byte) (LogicalValue, error) {
+	u, err := parseUUID(text)
+	if err != nil {
+		return LogicalValue{}, err
+	}
+	if dt == DtString {
+		return ReferenceValue(dt, FromString(string(formatUUID(u)))), nil
+	}
+	return ReferenceValue(dt, FromBytes(u[:], false)), nil
+}
+
+func (uuidType) ProtoAnnotation() string { return "uuid" }
+
+// getUUID gets the bytes of the UUID, stored in the value.
+func getUUID(v LogicalValue) (u [16]byte, err error) {
+	b := v.Reference.ToBytes()
+	if v.DataType == DtString {
+		return parseUUID(b)
+	}
+	if len(b) != len(u) {
+		return u, fmt.Errorf("expected %d bytes of UUID, but got %d", len(u), len(b))
+	}
+	copy(u[:], b)
+	return
+}
+
+func parseUUID(text []byte) (u [16]byte, err error) {
+	if len(text) != 36 || text[8] != '-' || text[13] != '-' || text[18] != '-' || text[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", text)
+	}
+	n := 0
+	for _, part := range [][]byte{text[:8], text[9:13], text[14:18], text[19:23], text[24:]} {
+		var m int
+		if m, err = hex.Decode(u[n:], part); err != nil {
+			return u, fmt.Errorf("invalid UUID %q", text)
+		}
+		n += m
+	}
+	return
+}
+
+func formatUUID(u [16]byte) []byte {
+	text := make([]byte, 36)
+	hex.Encode(text, u[:4])
+	text[8] = '-'
+	hex.Encode(text[9:], u[4:6])
+	text[13] = '-'
+	hex.Encode(text[14:], u[6:8])
+	text[18] = '-'
+	hex.Encode(text[19:], u[8:10])
+	text[23] = '-'
+	hex.Encode(text[24:], u[10:])
+	return text
+}
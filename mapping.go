@@ -0,0 +1,326 @@
+package dymessage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// ToMap converts the entity to a map, where the keys are names of the fields
+// and the values are of natural Go types: int32, int64, uint32, uint64,
+// float32, float64 and bool for the primitive fields, string and []byte for
+// the string and bytes fields, and map[string]interface{} for the nested
+// entities. The repeated fields are converted to []interface{}. The fields
+// with null references, the repeated fields without items and the internal
+// fields are omitted.
+func ToMap(e *Entity, md *MessageDef) map[string]interface{} {
+	if e == nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(md.Fields))
+	for _, f := range md.Fields {
+		if f.IsInternal() {
+			continue
+		}
+		if f.Repeated {
+			n := f.Len(e)
+			if n == 0 {
+				continue
+			}
+			items := make([]interface{}, n)
+			for i := 0; i < n; i++ {
+				if f.DataType.IsRefType() {
+					items[i] = referenceToValue(f.GetReferenceAt(e, i), md, f)
+				} else {
+					items[i] = primitiveToValue(f.GetPrimitiveAt(e, i), f)
+				}
+			}
+			m[f.Name] = items
+		} else if f.DataType.IsRefType() {
+			if ref := f.GetReference(e); ref.Entity != nil {
+				m[f.Name] = referenceToValue(ref, md, f)
+			}
+		} else {
+			m[f.Name] = primitiveToValue(f.GetPrimitive(e), f)
+		}
+	}
+	return m
+}
+
+// FromMap creates an entity from the map, where the keys are names of the
+// fields. Besides the types produced by the ToMap function, the values may be
+// of any numeric type or json.Number as long as the value fits the data type
+// of the field without loss, strings for the bytes fields, entities of
+// corresponding data type for the nested entities, and slices of any type for
+// the repeated fields. The nil values of the fields are ignored, while the nil
+// items of the repeated fields are not allowed. If the map contains a key,
+// which doesn't correspond any field, or a value which doesn't fit the field,
+// the function returns an error with the path to the value.
+func FromMap(m map[string]interface{}, md *MessageDef) (*Entity, error) {
+	return fromMap(m, md, "")
+}
+
+func fromMap(m map[string]interface{}, md *MessageDef, path string) (*Entity, error) {
+	e := md.NewEntity()
+	for name, value := range m {
+		p := name
+		if path != "" {
+			p = path + "." + name
+		}
+		f, ok := md.TryGetFieldByName(name)
+		if !ok || f.IsInternal() {
+			return nil, fmt.Errorf("dymessage: %s: unknown field", p)
+		}
+		if value == nil {
+			continue
+		}
+		if err := setFromValue(e, md, f, value, p); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func setFromValue(e *Entity, md *MessageDef, f *MessageFieldDef, value interface{}, path string) error {
+	if !f.Repeated {
+		if f.DataType.IsRefType() {
+			ref, err := valueToReference(value, md, f, path)
+			if err != nil {
+				return err
+			}
+			f.SetReference(e, ref)
+		} else {
+			p, err := valueToPrimitive(value, f, path)
+			if err != nil {
+				return err
+			}
+			f.SetPrimitive(e, p)
+		}
+		return nil
+	}
+	items := reflect.ValueOf(value)
+	if k := items.Kind(); k != reflect.Slice && k != reflect.Array {
+		return newMapTypeError(path, value, "slice")
+	}
+	n := items.Len()
+	f.Reserve(e, n)
+	for i := 0; i < n; i++ {
+		item := items.Index(i).Interface()
+		p := path + "[" + strconv.Itoa(i) + "]"
+		if f.DataType.IsRefType() {
+			// The repeated fields cannot hold null items, which the
+			// encoders would reject.
+			if item == nil {
+				return newMapTypeError(p, item, mapTypeName(f, md))
+			}
+			ref, err := valueToReference(item, md, f, p)
+			if err != nil {
+				return err
+			}
+			f.SetReferenceAt(e, i, ref)
+		} else {
+			prim, err := valueToPrimitive(item, f, p)
+			if err != nil {
+				return err
+			}
+			f.SetPrimitiveAt(e, i, prim)
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Value conversions
+
+func primitiveToValue(p Primitive, f *MessageFieldDef) interface{} {
+	switch f.DataType {
+	case DtInt32:
+		return p.ToInt32()
+	case DtInt64:
+		return p.ToInt64()
+	case DtUint32:
+		return p.ToUint32()
+	case DtUint64:
+		return p.ToUint64()
+	case DtFloat32:
+		return p.ToFloat32()
+	case DtFloat64:
+		return p.ToFloat64()
+	case DtBool:
+		return p.ToBool()
+	default:
+		panic(fmt.Sprintf("unexpected primitive data type %d", f.DataType))
+	}
+}
+
+func referenceToValue(r Reference, md *MessageDef, f *MessageFieldDef) interface{} {
+	switch {
+	case r.Entity == nil:
+		return nil
+	case f.DataType == DtString:
+		return r.ToString()
+	case f.DataType == DtBytes:
+		return r.ToBytes()
+	default:
+		def := md.Registry.GetMessageDef(f.DataType)
+		return ToMap(r.ToEntity(), def)
+	}
+}
+
+func valueToPrimitive(value interface{}, f *MessageFieldDef, path string) (Primitive, error) {
+	if f.DataType == DtBool {
+		if b, ok := value.(bool); ok {
+			return FromBool(b), nil
+		}
+		return 0, newMapTypeError(path, value, "bool")
+	}
+	v := reflect.ValueOf(value)
+	if n, ok := value.(json.Number); ok {
+		// The number is parsed as a float unless it represents an
+		// integer, which may not be represented precisely by a float.
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			v = reflect.ValueOf(i)
+		} else if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			v = reflect.ValueOf(u)
+		} else if fl, err := strconv.ParseFloat(string(n), 64); err == nil {
+			v = reflect.ValueOf(fl)
+		}
+	}
+	switch f.DataType {
+	case DtInt32:
+		if i, ok := toInt(v, math.MinInt32, math.MaxInt32); ok {
+			return FromInt32(int32(i)), nil
+		}
+		return 0, newMapTypeError(path, value, "int32")
+	case DtInt64:
+		if i, ok := toInt(v, math.MinInt64, math.MaxInt64); ok {
+			return FromInt64(i), nil
+		}
+		return 0, newMapTypeError(path, value, "int64")
+	case DtUint32:
+		if u, ok := toUint(v, math.MaxUint32); ok {
+			return FromUint32(uint32(u)), nil
+		}
+		return 0, newMapTypeError(path, value, "uint32")
+	case DtUint64:
+		if u, ok := toUint(v, math.MaxUint64); ok {
+			return FromUint64(u), nil
+		}
+		return 0, newMapTypeError(path, value, "uint64")
+	case DtFloat32:
+		if fl, ok := toFloat(v); ok {
+			return FromFloat32(float32(fl)), nil
+		}
+		return 0, newMapTypeError(path, value, "float32")
+	case DtFloat64:
+		if fl, ok := toFloat(v); ok {
+			return FromFloat64(fl), nil
+		}
+		return 0, newMapTypeError(path, value, "float64")
+	default:
+		panic(fmt.Sprintf("unexpected primitive data type %d", f.DataType))
+	}
+}
+
+func valueToReference(value interface{}, md *MessageDef, f *MessageFieldDef, path string) (Reference, error) {
+	switch f.DataType {
+	case DtString:
+		if s, ok := value.(string); ok {
+			return FromString(s), nil
+		}
+		return Reference{}, newMapTypeError(path, value, mapTypeName(f, md))
+	case DtBytes:
+		switch v := value.(type) {
+		case []byte:
+			return FromBytes(v, true), nil
+		case string:
+			return FromBytes([]byte(v), false), nil
+		}
+		return Reference{}, newMapTypeError(path, value, mapTypeName(f, md))
+	default:
+		def := md.Registry.GetMessageDef(f.DataType)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			e, err := fromMap(v, def, path)
+			return FromEntity(e), err
+		case *Entity:
+			if v.DataType == def.DataType {
+				return FromEntity(v), nil
+			}
+		}
+		return Reference{}, newMapTypeError(path, value, mapTypeName(f, md))
+	}
+}
+
+// mapTypeName gets the name of the type the values of the reference field are
+// converted to, as it's reported in the errors.
+func mapTypeName(f *MessageFieldDef, md *MessageDef) string {
+	switch f.DataType {
+	case DtString:
+		return "string"
+	case DtBytes:
+		return "bytes"
+	default:
+		return md.Registry.GetMessageDef(f.DataType).Name
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+// toInt converts the numeric value to an integer if it fits the range without
+// loss.
+func toInt(v reflect.Value, min, max int64) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		return i, i >= min && i <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		return int64(u), u <= uint64(max)
+	case reflect.Float32, reflect.Float64:
+		fl := v.Float()
+		// The upper bound is exclusive, because the max value
+		// converted to float may be rounded up.
+		return int64(fl), fl == math.Trunc(fl) && fl >= float64(min) && fl < float64(max)+1
+	default:
+		return 0, false
+	}
+}
+
+// toUint converts the numeric value to an unsigned integer if it fits the
+// range without loss.
+func toUint(v reflect.Value, max uint64) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		return uint64(i), i >= 0 && uint64(i) <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		return u, u <= max
+	case reflect.Float32, reflect.Float64:
+		fl := v.Float()
+		return uint64(fl), fl == math.Trunc(fl) && fl >= 0 && fl < float64(max)+1
+	default:
+		return 0, false
+	}
+}
+
+func toFloat(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func newMapTypeError(path string, value interface{}, expected string) error {
+	return fmt.Errorf("dymessage: %s: cannot use value %v of type %T as %s", path, value, value, expected)
+}
@@ -0,0 +1,82 @@
+package dymessage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToMapFromMap(t *testing.T) {
+	def := createTestDef()
+	m := map[string]interface{}{
+		"name":   "Alice",
+		"age":    int32(30),
+		"score":  1.5,
+		"active": true,
+		"avatar": []byte{1, 2},
+		"tags":   []interface{}{"a", "b"},
+		"address": map[string]interface{}{
+			"city": "Oslo",
+			"zip":  uint32(150),
+		},
+		"addresses": []interface{}{
+			map[string]interface{}{"city": "Bergen", "zip": uint32(0)},
+		},
+	}
+	e, err := FromMap(m, def)
+	require.NoError(t, err)
+	assert.Equal(t, m, ToMap(e, def))
+
+	// The values of other numeric types are converted as long as they fit
+	// the fields.
+	e, err = FromMap(map[string]interface{}{
+		"age":    json.Number("-7"),
+		"score":  3,
+		"avatar": "xy",
+		"tags":   []string{"c"},
+		"name":   nil,
+	}, def)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"age":    int32(-7),
+		"score":  3.0,
+		"active": false,
+		"avatar": []byte("xy"),
+		"tags":   []interface{}{"c"},
+	}, ToMap(e, def))
+}
+
+func TestFromMapErrors(t *testing.T) {
+	def := createTestDef()
+	tests := []struct {
+		m       map[string]interface{}
+		message string
+	}{
+		{map[string]interface{}{"height": 1},
+			"dymessage: height: unknown field"},
+		{map[string]interface{}{"age": int64(1) << 40},
+			"dymessage: age: cannot use value 1099511627776 of type int64 as int32"},
+		{map[string]interface{}{"age": 1.5},
+			"dymessage: age: cannot use value 1.5 of type float64 as int32"},
+		{map[string]interface{}{"active": 1},
+			"dymessage: active: cannot use value 1 of type int as bool"},
+		{map[string]interface{}{"name": 1},
+			"dymessage: name: cannot use value 1 of type int as string"},
+		{map[string]interface{}{"tags": "a"},
+			"dymessage: tags: cannot use value a of type string as slice"},
+		{map[string]interface{}{"tags": []interface{}{"a", nil}},
+			"dymessage: tags[1]: cannot use value <nil> of type <nil> as string"},
+		{map[string]interface{}{"address": map[string]interface{}{"zip": -1}},
+			"dymessage: address.zip: cannot use value -1 of type int as uint32"},
+		{map[string]interface{}{"addresses": []interface{}{nil}},
+			"dymessage: addresses[0]: cannot use value <nil> of type <nil> as Address"},
+		{map[string]interface{}{"addresses": []interface{}{map[string]interface{}{"town": "Oslo"}}},
+			"dymessage: addresses[0].town: unknown field"},
+	}
+	for _, test := range tests {
+		_, err := FromMap(test.m, def)
+		assert.EqualError(t, err, test.message)
+	}
+}
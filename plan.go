@@ -0,0 +1,85 @@
+package dymessage
+
+import "sync"
+
+type (
+	// Provides the information how to locate the codec plan in the cache
+	// of plans of a message definition. Pass this marker to the GetPlan
+	// method of MessageDef.
+	PlanMarker struct{ index int }
+
+	// An item of the cache of plans, which remembers the state of the
+	// message definition at the moment the plan has been compiled.
+	planItem struct {
+		registry *Registry
+		fields   int
+		plan     interface{}
+	}
+)
+
+var plans = struct {
+	sync.Mutex
+	index int
+}{index: 0}
+
+// RegisterPlan registers a kind of codec plan globally. This must be called
+// during init() of the package, which compiles the plans. The returned marker
+// must be provided to the GetPlan method of MessageDef to get the plan of this
+// kind.
+func RegisterPlan() PlanMarker {
+	plans.Lock()
+	id := plans.index
+	plans.index++
+	plans.Unlock()
+	return PlanMarker{index: id}
+}
+
+// GetPlan gets the plan of the kind identified by the marker, compiled for the
+// message definition. If the plan hasn't been compiled yet, or the message
+// definition has been moved to another registry or got new fields since the
+// plan was compiled, the compile function is called to produce a new one. The
+// method is safe for concurrent use.
+//
+// The plans must not rely on the field definitions to stay the same after
+// having been compiled. If the fields are altered in place, call ResetPlans
+// to get the plans compiled again.
+func (md *MessageDef) GetPlan(mk PlanMarker, compile func(*MessageDef) interface{}) interface{} {
+	if items, ok := md.plans.Load().([]planItem); ok && mk.index < len(items) {
+		item := items[mk.index]
+		if item.plan != nil && item.registry == md.Registry && item.fields == len(md.Fields) {
+			return item.plan
+		}
+	}
+	// The plan is compiled outside of the lock, so a few goroutines may
+	// compile the same plan concurrently, but only one of them wins.
+	plan := compile(md)
+	plans.Lock()
+	items, _ := md.plans.Load().([]planItem)
+	updated := make([]planItem, plans.index)
+	copy(updated, items)
+	updated[mk.index] = planItem{
+		registry: md.Registry,
+		fields:   len(md.Fields),
+		plan:     plan,
+	}
+	md.plans.Store(updated)
+	plans.Unlock()
+	return plan
+}
+
+// ResetPlans drops the codec plans compiled for the message definition, so
+// they will be compiled again the next time the definition is used.
+func (md *MessageDef) ResetPlans() {
+	plans.Lock()
+	md.plans.Store([]planItem(nil))
+	plans.Unlock()
+}
+
+// ResetPlans drops the codec plans compiled for all message definitions of
+// the registry. Call this method after the definitions have been altered in
+// place, like reordering the fields or applying the extensions.
+func (r *Registry) ResetPlans() {
+	for _, def := range r.Defs {
+		def.ResetPlans()
+	}
+}
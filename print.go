@@ -0,0 +1,286 @@
+package dymessage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Represents an option, which alters the way the entity is printed by
+	// the Sprint function or the formatter.
+	PrintOption func(*printOptions)
+
+	printOptions struct {
+		indent   string // Indentation of the nested values, or empty for single-line output
+		maxBytes int    // Maximum number of bytes printed for the bytes values
+	}
+
+	// Formats the entity against the message definition, writing the names
+	// of the fields along with their values. See the NewFormatter function.
+	Formatter struct {
+		e    *Entity
+		md   *MessageDef
+		opts []PrintOption
+	}
+
+	printer struct {
+		sb    strings.Builder
+		opts  printOptions
+		depth int
+	}
+)
+
+// The maximum number of bytes printed for the bytes values by default.
+const DefaultPrintMaxBytes = 32
+
+// WithPrintIndent makes the printer write each field on its own line,
+// indenting the nested values with specified string. An empty string makes the
+// output single-line, which is the default.
+func WithPrintIndent(indent string) PrintOption {
+	return func(po *printOptions) { po.indent = indent }
+}
+
+// WithPrintMaxBytes limits the number of bytes printed for the bytes values,
+// the remaining bytes being replaced with an ellipsis. By default 32 bytes are
+// printed. Zero or a negative value removes the limit.
+func WithPrintMaxBytes(n int) PrintOption {
+	return func(po *printOptions) { po.maxBytes = n }
+}
+
+// Sprint gets a human-readable representation of the entity against the message
+// definition. The representation includes the names of the fields and their
+// values, decoded according to the data types of the fields. The nested
+// entities and repeated fields are printed recursively.
+func Sprint(e *Entity, md *MessageDef, opts ...PrintOption) string {
+	pr := newPrinter(opts)
+	pr.printEntity(e, md)
+	return pr.sb.String()
+}
+
+// SprintValue gets a human-readable representation of a single value of the
+// field, which belongs to the message definition, just like the values of the
+// fields are printed by the Sprint function. Depending on the data type of the
+// field, either the primitive value or the reference is printed.
+func SprintValue(p Primitive, r Reference, f *MessageFieldDef, md *MessageDef, opts ...PrintOption) string {
+	pr := newPrinter(opts)
+	if f.DataType.IsRefType() {
+		pr.printReference(r, md, f)
+	} else {
+		pr.printPrimitive(p, f)
+	}
+	return pr.sb.String()
+}
+
+// NewFormatter creates a formatter, which prints the entity against the message
+// definition when used with the functions of the fmt package. The %v verb
+// prints the entity just like the Sprint function does, while %+v also
+// indents the nested values with two spaces, unless the options specify
+// another indentation.
+func NewFormatter(e *Entity, md *MessageDef, opts ...PrintOption) Formatter {
+	return Formatter{e: e, md: md, opts: opts}
+}
+
+// Format implements the fmt.Formatter interface.
+func (f Formatter) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v', 's':
+		opts := f.opts
+		if s.Flag('+') {
+			opts = append([]PrintOption{WithPrintIndent("  ")}, opts...)
+		}
+		_, _ = fmt.Fprint(s, Sprint(f.e, f.md, opts...))
+	default:
+		_, _ = fmt.Fprintf(s, "%%!%c(dymessage.Formatter)", verb)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Printer implementation
+
+func newPrinter(opts []PrintOption) *printer {
+	pr := &printer{opts: printOptions{maxBytes: DefaultPrintMaxBytes}}
+	for _, opt := range opts {
+		opt(&pr.opts)
+	}
+	return pr
+}
+
+func (pr *printer) printEntity(e *Entity, md *MessageDef) {
+	if e == nil {
+		pr.sb.WriteString("<nil>")
+		return
+	}
+	pr.sb.WriteString(md.Name)
+	pr.sb.WriteByte('{')
+	first := true
+	for _, f := range md.Fields {
+		if f.IsInternal() {
+			continue
+		}
+		if first {
+			pr.depth++
+		}
+		pr.printSeparator(first)
+		first = false
+		pr.sb.WriteString(f.Name)
+		pr.sb.WriteString(": ")
+		if f.Repeated {
+			pr.printRepeated(e, md, f)
+		} else if f.DataType.IsRefType() {
+			pr.printReference(f.GetReference(e), md, f)
+		} else {
+			pr.printPrimitive(f.GetPrimitive(e), f)
+		}
+	}
+	if first {
+		pr.sb.WriteByte('}')
+		return
+	}
+	pr.depth--
+	pr.printClosing('}')
+}
+
+func (pr *printer) printRepeated(e *Entity, md *MessageDef, f *MessageFieldDef) {
+	n := f.Len(e)
+	pr.sb.WriteByte('[')
+	if n == 0 {
+		pr.sb.WriteByte(']')
+		return
+	}
+	// Only the nested entities are written on separate lines, because
+	// the values of other types are short enough to fit in one line.
+	multiline := f.DataType.IsEntity() && pr.opts.indent != ""
+	if multiline {
+		pr.depth++
+	}
+	for i := 0; i < n; i++ {
+		if multiline {
+			pr.printSeparator(i == 0)
+		} else if i > 0 {
+			pr.sb.WriteString(", ")
+		}
+		if f.DataType.IsRefType() {
+			pr.printReference(f.GetReferenceAt(e, i), md, f)
+		} else {
+			pr.printPrimitive(f.GetPrimitiveAt(e, i), f)
+		}
+	}
+	if multiline {
+		pr.depth--
+		pr.printClosing(']')
+	} else {
+		pr.sb.WriteByte(']')
+	}
+}
+
+func (pr *printer) printReference(r Reference, md *MessageDef, f *MessageFieldDef) {
+	// The null strings and bytes are printed as the empty ones, which
+	// they are equal to.
+	switch {
+	case r.Entity == nil && f.DataType.IsEntity():
+		pr.sb.WriteString("<nil>")
+	case pr.printLogical(ReferenceValue(f.DataType, r), f):
+	case f.DataType == DtString:
+		pr.sb.WriteString(strconv.Quote(r.ToString()))
+	case f.DataType == DtBytes:
+		pr.printBytes(r.ToBytes())
+	default:
+		def := md.Registry.GetMessageDef(f.DataType)
+		pr.printEntity(r.ToEntity(), def)
+	}
+}
+
+func (pr *printer) printPrimitive(p Primitive, f *MessageFieldDef) {
+	if pr.printLogical(PrimitiveValue(f.DataType, p), f) {
+		return
+	}
+	var buf [32]byte
+	var b []byte
+	switch f.DataType {
+	case DtInt32:
+		b = strconv.AppendInt(buf[:0], int64(p.ToInt32()), 10)
+	case DtInt64:
+		b = strconv.AppendInt(buf[:0], p.ToInt64(), 10)
+	case DtUint32:
+		b = strconv.AppendUint(buf[:0], uint64(p.ToUint32()), 10)
+	case DtUint64:
+		b = strconv.AppendUint(buf[:0], p.ToUint64(), 10)
+	case DtFloat32:
+		b = strconv.AppendFloat(buf[:0], float64(p.ToFloat32()), 'g', -1, 32)
+	case DtFloat64:
+		b = strconv.AppendFloat(buf[:0], p.ToFloat64(), 'g', -1, 64)
+	case DtBool:
+		b = strconv.AppendBool(buf[:0], p.ToBool())
+	default:
+		panic(fmt.Sprintf("unexpected primitive data type %d", f.DataType))
+	}
+	pr.sb.Write(b)
+}
+
+// printLogical prints the text representation of the value, if the field is
+// of a logical type. If the value cannot be represented, it's printed as a
+// value of the underlying data type.
+func (pr *printer) printLogical(v LogicalValue, f *MessageFieldDef) bool {
+	lt, ok := f.TryGetLogicalType()
+	if !ok {
+		return false
+	}
+	text, err := lt.FormatText(v)
+	if err != nil {
+		return false
+	}
+	pr.sb.Write(text)
+	return true
+}
+
+// printBytes prints the bytes in hexadecimal notation, truncating them if
+// there are more bytes than the options allow.
+func (pr *printer) printBytes(b []byte) {
+	const hex = "0123456789abcdef"
+	n := len(b)
+	if pr.opts.maxBytes > 0 && n > pr.opts.maxBytes {
+		b = b[:pr.opts.maxBytes]
+	}
+	pr.sb.WriteString("0x")
+	for _, c := range b {
+		pr.sb.WriteByte(hex[c>>4])
+		pr.sb.WriteByte(hex[c&0x0f])
+	}
+	if len(b) < n {
+		pr.sb.WriteString("...(")
+		pr.sb.WriteString(strconv.Itoa(n))
+		pr.sb.WriteString(" bytes)")
+	}
+}
+
+// printSeparator prints a separator before the item of an entity or list,
+// which is either a newline followed by the indentation, or a comma followed
+// by a space for the single-line output.
+func (pr *printer) printSeparator(first bool) {
+	if pr.opts.indent != "" {
+		if !first {
+			pr.sb.WriteByte(',')
+		}
+		pr.printNewline()
+	} else if !first {
+		pr.sb.WriteString(", ")
+	}
+}
+
+// printClosing prints the closing bracket of an entity or list, placing it on
+// its own line if the output is indented.
+func (pr *printer) printClosing(c byte) {
+	if pr.opts.indent != "" {
+		pr.sb.WriteByte(',')
+		pr.printNewline()
+	}
+	pr.sb.WriteByte(c)
+}
+
+func (pr *printer) printNewline() {
+	pr.sb.WriteByte('\n')
+	for i := 0; i < pr.depth; i++ {
+		pr.sb.WriteString(pr.opts.indent)
+	}
+}
@@ -0,0 +1,96 @@
+package dymessage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestDef() *MessageDef {
+	rb := NewRegistryBuilder()
+	address := rb.ForMessageDef("address").
+		WithName("Address").
+		WithField("city", 1, DtString).
+		WithField("zip", 2, DtUint32).
+		Build()
+	def := rb.ForMessageDef("person").
+		WithName("Person").
+		WithField("name", 1, DtString).
+		WithField("age", 2, DtInt32).
+		WithField("score", 3, DtFloat64).
+		WithField("active", 4, DtBool).
+		WithField("avatar", 5, DtBytes).
+		WithArrayField("tags", 6, DtString).
+		WithField("address", 7, address.DataType).
+		WithArrayField("addresses", 8, address.DataType).
+		Build()
+	rb.Build()
+	return def
+}
+
+func TestSprint(t *testing.T) {
+	def := createTestDef()
+	addressDef := def.Registry.GetMessageDef(def.GetFieldByName("address").DataType)
+	e := def.NewEntity()
+	def.GetFieldByName("name").SetReference(e, FromString("Alice \"A\""))
+	def.GetFieldByName("age").SetPrimitive(e, FromInt32(-30))
+	def.GetFieldByName("score").SetPrimitive(e, FromFloat64(1.5))
+	def.GetFieldByName("active").SetPrimitive(e, FromBool(true))
+	def.GetFieldByName("avatar").SetReference(e, FromBytes([]byte{1, 0xab, 0xff}, false))
+	tags := def.GetFieldByName("tags")
+	tags.Reserve(e, 2)
+	tags.SetReferenceAt(e, 0, FromString("a"))
+	tags.SetReferenceAt(e, 1, FromString("b"))
+	addresses := def.GetFieldByName("addresses")
+	addresses.Reserve(e, 2)
+	for i, city := range []string{"Oslo", "Bergen"} {
+		a := addressDef.NewEntity()
+		addressDef.GetFieldByName("city").SetReference(a, FromString(city))
+		addressDef.GetFieldByName("zip").SetPrimitive(a, FromUint32(uint32(i)))
+		addresses.SetReferenceAt(e, i, FromEntity(a))
+	}
+
+	assert.Equal(t, `Person{name: "Alice \"A\"", age: -30, score: 1.5, active: true, avatar: 0x01abff, `+
+		`tags: ["a", "b"], address: <nil>, addresses: [Address{city: "Oslo", zip: 0}, Address{city: "Bergen", zip: 1}]}`,
+		Sprint(e, def))
+
+	assert.Equal(t, `Person{
+..name: "Alice \"A\"",
+..age: -30,
+..score: 1.5,
+..active: true,
+..avatar: 0x01...(3 bytes),
+..tags: ["a", "b"],
+..address: <nil>,
+..addresses: [
+....Address{
+......city: "Oslo",
+......zip: 0,
+....},
+....Address{
+......city: "Bergen",
+......zip: 1,
+....},
+..],
+}`, Sprint(e, def, WithPrintIndent(".."), WithPrintMaxBytes(1)))
+
+	// The null strings and bytes are printed as the empty ones.
+	empty := def.NewEntity()
+	assert.Equal(t, `Person{name: "", age: 0, score: 0, active: false, avatar: 0x, `+
+		`tags: [], address: <nil>, addresses: []}`, Sprint(empty, def))
+	assert.Equal(t, `""`, SprintValue(0, Reference{}, def.GetFieldByName("name"), def))
+	assert.Equal(t, "<nil>", Sprint(nil, def))
+}
+
+func TestFormatter(t *testing.T) {
+	def := createTestDef()
+	addressDef := def.Registry.GetMessageDef(def.GetFieldByName("address").DataType)
+	a := addressDef.NewEntity()
+	addressDef.GetFieldByName("city").SetReference(a, FromString("Oslo"))
+
+	f := NewFormatter(a, addressDef)
+	assert.Equal(t, `Address{city: "Oslo", zip: 0}`, fmt.Sprintf("%v", f))
+	assert.Equal(t, "Address{\n  city: \"Oslo\",\n  zip: 0,\n}", fmt.Sprintf("%+v", f))
+	assert.Equal(t, "%!d(dymessage.Formatter)", fmt.Sprintf("%d", f))
+}
@@ -1,6 +1,7 @@
 package protobuf
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
 
@@ -21,6 +22,30 @@ func BenchmarkEncodeRegular(b *testing.B) {
 			assert.NoError(b, err)
 		}
 	})
+
+	// The codec plans get compiled on every iteration, which shows how
+	// much is saved by caching them.
+	b.Run("encode regular uncached plans", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			def.Registry.ResetPlans()
+			_, err := Encode(entity, def)
+			assert.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkAppendEncodeRegular(b *testing.B) {
+	def, entity := ArrangeEncodeDecode()
+	buf := make([]byte, 0, Size(entity, def))
+
+	// The buffer is reused between the iterations, so no memory is
+	// allocated for the encoded data.
+	b.Run("append encode regular", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := AppendEncode(buf[:0], entity, def)
+			assert.NoError(b, err)
+		}
+	})
 }
 
 func BenchmarkDecodeRegular(b *testing.B) {
@@ -45,6 +70,14 @@ func BenchmarkDecodeRegular(b *testing.B) {
 			assert.NoError(b, err)
 		}
 	})
+
+	b.Run("decode regular existing uncached plans", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			def.Registry.ResetPlans()
+			_, err := Decode(data, def, message)
+			assert.NoError(b, err)
+		}
+	})
 }
 
 func BenchmarkDecodeRegularShuffled(b *testing.B) {
@@ -153,3 +186,134 @@ func shuffleRegistryFields(reg *dymessage.Registry) {
 		})
 	}
 }
+
+// arrangeWide creates a wide message, out of which only a couple of fields are
+// needed, and encodes its entity.
+func arrangeWide(b *testing.B) (*dymessage.MessageDef, []byte) {
+	rb := dymessage.NewRegistryBuilder()
+	mb := rb.ForMessageDef("wide").WithName("Wide")
+	for i := 1; i <= 200; i += 2 {
+		mb = mb.WithField(fmt.Sprintf("Int%d", i), uint64(i), dymessage.DtInt64).
+			WithField(fmt.Sprintf("String%d", i+1), uint64(i+1), dymessage.DtString)
+	}
+	def := mb.Build()
+	rb.Build()
+	entity := def.NewEntity()
+	for _, f := range def.Fields {
+		if f.DataType == dymessage.DtString {
+			f.SetReference(entity, dymessage.FromString(f.Name))
+		} else {
+			f.SetPrimitive(entity, dymessage.FromInt64(int64(f.Tag)))
+		}
+	}
+	data, err := Encode(entity, def)
+	assert.NoError(b, err)
+	return def, data
+}
+
+func BenchmarkDecodeSelected(b *testing.B) {
+	def, data := arrangeWide(b)
+
+	b.Run("decode all fields", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := DecodeNew(data, def)
+			assert.NoError(b, err)
+		}
+	})
+
+	b.Run("decode selected fields", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := DecodeNew(data, def, WithFields("Int1", "String200"))
+			assert.NoError(b, err)
+		}
+	})
+
+	b.Run("decode resolved selection", func(b *testing.B) {
+		fs, err := NewFieldSelection(def, "Int1", "String200")
+		assert.NoError(b, err)
+		for i := 0; i < b.N; i++ {
+			_, err := DecodeNew(data, def, WithSelection(fs))
+			assert.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkDecodeZeroCopy(b *testing.B) {
+	rb := dymessage.NewRegistryBuilder()
+	def := rb.ForMessageDef("blob").
+		WithName("Blob").
+		WithField("Name", 1, dymessage.DtString).
+		WithField("Content", 2, dymessage.DtBytes).
+		Build()
+	rb.Build()
+	entity := def.NewEntity()
+	def.GetField(1).SetReference(entity, dymessage.FromString("blob"))
+	def.GetField(2).SetReference(entity, dymessage.FromBytes(make([]byte, 1<<20), false))
+	data, err := Encode(entity, def)
+	assert.NoError(b, err)
+
+	b.Run("decode copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := DecodeNew(data, def)
+			assert.NoError(b, err)
+		}
+	})
+
+	b.Run("decode zero copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := DecodeNew(data, def, WithZeroCopy())
+			assert.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkView(b *testing.B) {
+	def, data := arrangeWide(b)
+	first, last := def.GetFieldByName("Int1"), def.GetFieldByName("String200")
+
+	b.Run("view", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			v := NewView(data, def)
+			_, err := v.GetPrimitive(first)
+			assert.NoError(b, err)
+			_, err = v.GetBytes(last)
+			assert.NoError(b, err)
+		}
+	})
+
+	// The index pays off when the fields of the same view are read many
+	// times.
+	v := NewView(data, def)
+	assert.NoError(b, v.BuildIndex())
+
+	b.Run("view indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := v.GetPrimitive(first)
+			assert.NoError(b, err)
+			_, err = v.GetBytes(last)
+			assert.NoError(b, err)
+		}
+	})
+}
+
+func BenchmarkPatchFields(b *testing.B) {
+	def, data := arrangeWide(b)
+	f := def.GetFieldByName("Int1")
+
+	b.Run("patch fields", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := PatchFields(data, def, FieldUpdate{Path: "Int1", Primitive: dymessage.FromInt64(int64(i))})
+			assert.NoError(b, err)
+		}
+	})
+
+	b.Run("decode and encode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			entity, err := DecodeNew(data, def)
+			assert.NoError(b, err)
+			f.SetPrimitive(entity, dymessage.FromInt64(int64(i)))
+			_, err = Encode(entity, def)
+			assert.NoError(b, err)
+		}
+	})
+}
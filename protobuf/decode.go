@@ -8,8 +8,12 @@ import (
 	. "github.com/umk/go-dymessage/protobuf/internal/impl"
 )
 
-func (ec *encoder) decode(b []byte, pd *MessageDef, e *Entity) (err error) {
+func (ec *encoder) decode(b []byte, pd *MessageDef, e *Entity, sel *selection) (err error) {
 	helpers.DataTypesMustMatch(e, pd)
+	if err = ec.opts.limits.CheckDepth(ec.depth + 1); err != nil {
+		return
+	}
+	ec.depth++
 	prevBuf := ec.borrowBuf()
 	prevBytes := ec.replaceBytes(b)
 	// If entity data is not empty, resetting it to default just in case if
@@ -19,7 +23,8 @@ func (ec *encoder) decode(b []byte, pd *MessageDef, e *Entity) (err error) {
 			e.Data[i] = 0
 		}
 	}
-	fseq, fields := 0, pd.Fields
+	p := getPlan(pd)
+	fseq, fields := 0, p.fields
 	for !ec.cur.Eob() {
 		var t uint64
 		t, err = ec.cur.DecodeVarint()
@@ -27,67 +32,82 @@ func (ec *encoder) decode(b []byte, pd *MessageDef, e *Entity) (err error) {
 			break
 		}
 		wire, tag := t&7, t>>3
-		f, ok := (*MessageFieldDef)(nil), false
-		// Advancing fseq until it points the field with the current
-		// tag, or goes outside of the fields slice length. While
-		// enumerating, the nested entities get prepared for reuse.
-		for fseq < len(fields) {
-			fcur := fields[fseq]
-			fseq++
-			if fcur.Repeated || fcur.DataType == DtBytes || fcur.DataType == DtString {
-				if ch := e.Entities[fcur.Offset]; ch != nil {
-					ch.Reset()
-				}
-			}
-			if fcur.Tag == tag {
-				f = fcur
-				goto FoundField
-			}
-			if fcur.DataType.IsEntity() {
-				// In case if the entity won't be provided at all.
-				e.Entities[fcur.Offset] = nil
+		i := p.lookup(tag)
+		var nested *selection
+		if i >= 0 && sel != nil {
+			var ok bool
+			if nested, ok = sel.fields[tag]; !ok {
+				// The fields, which are not selected, are left
+				// empty just like the ones missing in the input.
+				i = -1
 			}
 		}
-		// If the field count not be found, trying to find by looking
-		// through all the collection of entity fields.
-		f, ok = pd.TryGetField(tag)
-		if !ok {
+		if i < 0 {
 			if err = ec.skipValue(wire); err != nil {
 				break
 			}
 			continue
 		}
-	FoundField:
-		if wire == WireBytes {
-			err = ec.decodeRef(e, pd, f)
-		} else {
-			err = ec.decodeValue(e, f)
+		// Advancing fseq until it goes past the field with the current
+		// tag. While enumerating, the nested entities get prepared for
+		// reuse. If the field precedes fseq, it has been prepared
+		// already.
+		for ; fseq <= i; fseq++ {
+			prepareField(e, fields[fseq].MessageFieldDef, fseq == i)
+		}
+		fp := &fields[i]
+		switch {
+		case wire == WireBytes:
+			err = ec.decodeRef(e, pd, fp, nested)
+		case fp.DataType.IsRefType():
+			// The values of reference types are always length
+			// delimited, so there's no coder for other wire types.
+			err = unexpectedWire(fp.MessageFieldDef, wire)
+		default:
+			err = ec.decodeValue(e, fp)
 		}
 		if err != nil {
 			break
 		}
+		if fp.oneof != nil {
+			fp.oneof.SetPrimitive(e, FromUint64(fp.Tag))
+		}
 	}
 	// Each of the following fields have not been specified in the input, so
 	// just cleaning its data.
 	for i := fseq; i < len(fields); i++ {
-		fcur := fields[i]
-		if fcur.DataType.IsEntity() {
-			e.Entities[fcur.Offset] = nil
-		}
+		prepareField(e, fields[i].MessageFieldDef, false)
 	}
 	ec.replaceBytes(prevBytes)
 	ec.returnBuf(prevBuf)
+	ec.depth--
 	return
 }
 
-func (ec *encoder) decodeRef(e *Entity, pd *MessageDef, f *MessageFieldDef) error {
+// prepareField prepares the nested entity of the field for being reused by the
+// decoder. The present parameter indicates whether the field has been found in
+// the input, so the nested entity, if it exists, can be populated.
+func prepareField(e *Entity, f *MessageFieldDef, present bool) {
+	if f.Repeated || f.DataType == DtBytes || f.DataType == DtString {
+		if ch := e.Entities[f.Offset]; ch != nil {
+			ch.Reset()
+		}
+	}
+	if !present && f.DataType.IsEntity() {
+		// In case if the entity won't be provided at all.
+		e.Entities[f.Offset] = nil
+	}
+}
+
+func (ec *encoder) decodeRef(e *Entity, pd *MessageDef, fp *fieldPlan, sel *selection) error {
+	f := fp.MessageFieldDef
 	value, err := ec.cur.DecodeRawBytes(false)
 	if err != nil {
 		return err
 	}
 
 	if !f.DataType.IsRefType() {
-		return ec.decodeValuePacked(e, f, value)
+		return ec.decodeValuePacked(e, fp, value)
 	}
 
 	// Getting an entity, which can be reused. This assumes that the nested
@@ -105,6 +125,9 @@ func (ec *encoder) decodeRef(e *Entity, pd *MessageDef, f *MessageFieldDef) erro
 		// collection is reserved before the item is retrieved in order
 		// to make possible to reuse an existing item.
 		n := len(data.Entities)
+		if err = ec.opts.limits.CheckRepeated(f, n+1); err != nil {
+			return err
+		}
 		if n < cap(data.Entities) {
 			data.Entities = data.Entities[:n+1]
 			entity = data.Entities[n]
@@ -122,23 +145,48 @@ func (ec *encoder) decodeRef(e *Entity, pd *MessageDef, f *MessageFieldDef) erro
 		if entity == nil {
 			entity = def.NewEntity()
 		}
-		if err = ec.decode(value, def, entity); err != nil {
-			return err
+		if ec.opts.lazy {
+			// The data is checked now, so the errors are reported
+			// by the decoder rather than when the entity is loaded.
+			if !ec.checked {
+				if err = ec.check(value, def, sel); err != nil {
+					return err
+				}
+			}
+			entity.SetLazy(value, ec.lazyDecoder(def, sel))
+		} else {
+			// The reused entity may have been decoded lazily.
+			entity.SetLazy(nil, nil)
+			if err = ec.decode(value, def, entity, sel); err != nil {
+				return err
+			}
 		}
 	} else {
+		if err = ec.opts.limits.CheckString(f, len(value)); err != nil {
+			return err
+		}
 		if entity == nil {
 			entity = &Entity{}
 		}
-		// If capacity allows the data block of the value is reused in
-		// order to store the binary data. Otherwise a new block is
-		// created, and existing one is abandoned.
 		n := len(value)
-		if n <= cap(entity.Data) {
-			entity.Data = entity.Data[0:n]
+		if ec.opts.zeroCopy {
+			// The capacity is limited by the length of the value,
+			// so appending to the value won't overwrite the input.
+			entity.Borrow(value[:n:n])
 		} else {
-			entity.Data = make([]byte, len(value))
+			// The reused item may still refer the input of the
+			// decoding with zero copy, which must be dropped.
+			entity.Reset()
+			// If capacity allows the data block of the value is
+			// reused in order to store the binary data. Otherwise a
+			// new block is created, and existing one is abandoned.
+			if n <= cap(entity.Data) {
+				entity.Data = entity.Data[0:n]
+			} else {
+				entity.Data = make([]byte, len(value))
+			}
+			copy(entity.Data, value)
 		}
-		copy(entity.Data, value)
 	}
 	// Updating the entity with a value built from the buffer.
 	if f.Repeated {
@@ -153,124 +201,156 @@ func (ec *encoder) decodeRef(e *Entity, pd *MessageDef, f *MessageFieldDef) erro
 	return nil
 }
 
-func (ec *encoder) skipValue(wire uint64) (err error) {
+// lazyDecoder gets the function, which decodes the lazy entity of the message
+// definition at the current depth with the options of the decoder.
+func (ec *encoder) lazyDecoder(pd *MessageDef, sel *selection) func([]byte, *Entity) error {
+	opts, depth := ec.opts, ec.depth
+	return func(data []byte, e *Entity) error {
+		lc := getEncoder()
+		lc.opts, lc.depth, lc.checked = opts, depth, true
+		err := lc.decode(data, pd, e, sel)
+		lc.checked = false
+		putEncoder(lc)
+		return err
+	}
+}
+
+// check reads the data of the entity of the message definition just like the
+// decode method does, but without populating the entity, so the errors, which
+// the decoding of the data would fail with, are found without allocations.
+func (ec *encoder) check(b []byte, pd *MessageDef, sel *selection) (err error) {
+	if err = ec.opts.limits.CheckDepth(ec.depth + 1); err != nil {
+		return
+	}
+	ec.depth++
+	prevBuf := ec.borrowBuf()
+	prevBytes := ec.replaceBytes(b)
+	p := getPlan(pd)
+	// The numbers of items of the repeated fields, which are counted only
+	// if the number is limited.
+	var counts []int
+	for !ec.cur.Eob() {
+		var t uint64
+		if t, err = ec.cur.DecodeVarint(); err != nil {
+			break
+		}
+		wire, tag := t&7, t>>3
+		i := p.lookup(tag)
+		var nested *selection
+		if i >= 0 && sel != nil {
+			var ok bool
+			if nested, ok = sel.fields[tag]; !ok {
+				i = -1
+			}
+		}
+		if i < 0 {
+			if err = ec.skipValue(wire); err != nil {
+				break
+			}
+			continue
+		}
+		fp, n := &p.fields[i], 1
+		if wire == WireBytes {
+			var value []byte
+			if value, err = ec.cur.DecodeRawBytes(false); err != nil {
+				break
+			}
+			switch {
+			case !fp.DataType.IsRefType():
+				n, err = ec.checkPacked(fp, value)
+			case fp.DataType.IsEntity():
+				err = ec.check(value, pd.Registry.GetMessageDef(fp.DataType), nested)
+			default:
+				err = ec.opts.limits.CheckString(fp.MessageFieldDef, len(value))
+			}
+		} else if fp.DataType.IsRefType() {
+			err = unexpectedWire(fp.MessageFieldDef, wire)
+		} else {
+			_, err = fp.decodeValue(ec.cur)
+		}
+		if err == nil && fp.Repeated && ec.opts.limits.MaxRepeated > 0 {
+			if counts == nil {
+				counts = make([]int, len(p.fields))
+			}
+			counts[i] += n
+			err = ec.opts.limits.CheckRepeated(fp.MessageFieldDef, counts[i])
+		}
+		if err != nil {
+			break
+		}
+	}
+	ec.replaceBytes(prevBytes)
+	ec.returnBuf(prevBuf)
+	ec.depth--
+	return
+}
+
+// checkPacked reads the packed values of the field, getting their number.
+func (ec *encoder) checkPacked(fp *fieldPlan, value []byte) (n int, err error) {
+	prevBuf := ec.borrowBuf()
+	prevBytes := ec.replaceBytes(value)
+	for ; !ec.cur.Eob() && err == nil; n++ {
+		_, err = fp.decodeValue(ec.cur)
+	}
+	ec.replaceBytes(prevBytes)
+	ec.returnBuf(prevBuf)
+	return
+}
+
+func (ec *encoder) skipValue(wire uint64) error {
+	return skipWire(ec.cur, wire)
+}
+
+// skipWire reads the value of specified wire type from the buffer, discarding
+// the value.
+func skipWire(b *Buffer, wire uint64) (err error) {
 	switch wire {
 	case WireVarint:
-		_, err = ec.cur.DecodeVarint()
+		_, err = b.DecodeVarint()
 	case WireFixed32:
-		_, err = ec.cur.DecodeFixed32()
+		_, err = b.DecodeFixed32()
 	case WireFixed64:
-		_, err = ec.cur.DecodeFixed64()
+		_, err = b.DecodeFixed64()
 	case WireBytes:
-		_, err = ec.cur.DecodeRawBytes(false)
+		_, err = b.DecodeRawBytes(false)
 	default:
 		err = fmt.Errorf("dymessage: wire format %d is not supported", wire)
 	}
 	return
 }
 
-func (ec *encoder) decodeValue(e *Entity, f *MessageFieldDef) (err error) {
-	var value uint64
-	extension, ok := tryGetExtension(f)
-	if ok && extension.integerKind != ikDefault {
-		value, err = ec.decodeValueByKind(f, extension.integerKind)
-	} else {
-		value, err = ec.decodeValueDefault(f)
-	}
+func (ec *encoder) decodeValue(e *Entity, fp *fieldPlan) error {
+	value, err := fp.decodeValue(ec.cur)
 	if err == nil {
-		if f.Repeated {
-			entity := e.Entities[f.Offset]
-			if entity == nil {
-				entity = &Entity{}
-				e.Entities[f.Offset] = entity
+		if fp.Repeated {
+			if err = ec.opts.limits.CheckRepeated(fp.MessageFieldDef, fp.Len(e)+1); err != nil {
+				return err
 			}
-			n := f.Reserve(e, 1)
-			f.SetPrimitiveAt(e, n, Primitive(value))
+			n := fp.Reserve(e, 1)
+			fp.SetPrimitiveAt(e, n, Primitive(value))
 		} else {
-			f.SetPrimitive(e, Primitive(value))
+			fp.SetPrimitive(e, Primitive(value))
 		}
 	}
 	return err
 }
 
-func (ec *encoder) decodeValuePacked(e *Entity, f *MessageFieldDef, value []byte) (err error) {
+func (ec *encoder) decodeValuePacked(e *Entity, fp *fieldPlan, value []byte) (err error) {
 	prevBuf := ec.borrowBuf()
 	prevBytes := ec.replaceBytes(value)
-	fn := ec.getValueDecoder(f)
 	var i uint64
 	for !ec.cur.Eob() {
-		i, err = fn()
+		i, err = fp.decodeValue(ec.cur)
 		if err != nil {
 			break
 		}
-		prev := f.Reserve(e, 1)
-		f.SetPrimitiveAt(e, prev, Primitive(i))
+		if err = ec.opts.limits.CheckRepeated(fp.MessageFieldDef, fp.Len(e)+1); err != nil {
+			break
+		}
+		prev := fp.Reserve(e, 1)
+		fp.SetPrimitiveAt(e, prev, Primitive(i))
 	}
 	ec.replaceBytes(prevBytes)
 	ec.returnBuf(prevBuf)
 	return
 }
-
-func (ec *encoder) getValueDecoder(f *MessageFieldDef) func() (uint64, error) {
-	extension, ok := tryGetExtension(f)
-	if ok && extension.integerKind != ikDefault {
-		ik := extension.integerKind
-		switch ik {
-		case ikVarint:
-			return ec.cur.DecodeVarint
-		case ikZigZag:
-			switch f.DataType {
-			case DtInt32:
-				return ec.cur.DecodeZigzag32
-			case DtInt64:
-				return ec.cur.DecodeZigzag64
-			default:
-				panic(fmt.Sprintf("ZigZag encoding is applied to invalid data type %d", f.DataType))
-			}
-		default:
-			panic(fmt.Sprintf("unsupported value of integer kind %d", ik))
-		}
-	}
-	switch f.DataType {
-	case DtInt32, DtUint32, DtFloat32:
-		return ec.cur.DecodeFixed32
-	case DtInt64, DtUint64, DtFloat64:
-		return ec.cur.DecodeFixed64
-	case DtBool:
-		return ec.cur.DecodeVarint
-	default:
-		panic(fmt.Sprintf("unsupported decoding data type %d", f.DataType))
-	}
-}
-
-func (ec *encoder) decodeValueDefault(f *MessageFieldDef) (value uint64, err error) {
-	switch f.DataType {
-	case DtInt32, DtUint32, DtFloat32:
-		value, err = ec.cur.DecodeFixed32()
-	case DtInt64, DtUint64, DtFloat64:
-		value, err = ec.cur.DecodeFixed64()
-	case DtBool:
-		value, err = ec.cur.DecodeVarint()
-	default:
-		panic(fmt.Sprintf("unsupported decoding data type %d", f.DataType))
-	}
-	return
-}
-
-func (ec *encoder) decodeValueByKind(f *MessageFieldDef, ik integerKind) (uint64, error) {
-	switch ik {
-	case ikVarint:
-		return ec.cur.DecodeVarint()
-	case ikZigZag:
-		switch f.DataType {
-		case DtInt32:
-			return ec.cur.DecodeZigzag32()
-		case DtInt64:
-			return ec.cur.DecodeZigzag64()
-		default:
-			panic(fmt.Sprintf("ZigZag encoding is applied to invalid data type %d", f.DataType))
-		}
-	default:
-		panic(fmt.Sprintf("unsupported value of integer kind %d", ik))
-	}
-}
@@ -2,195 +2,123 @@ package protobuf
 
 import (
 	"errors"
-	"fmt"
 
 	. "github.com/umk/go-dymessage"
-	. "github.com/umk/go-dymessage/protobuf/internal/impl"
 )
 
-// encode encodes the specified entity into a protocol buffers against the
-// specified message definition. The ownedBuf parameter indicates whether
-// created buffer must be owned by the caller, or can be shared with further
-// callers.
-func (ec *encoder) encode(
-	e *Entity, pd *MessageDef, ownedBuf bool) (result []byte, err error) {
+var errNullItem = errors.New("dymessage: repeated field has null item")
+
+// encodeInto encodes the specified entity against the specified message
+// definition, appending the result to the provided buffer. The sizes of the
+// nested entities must have been computed by the size method beforehand.
+func (ec *encoder) encodeInto(
+	dst []byte, e *Entity, pd *MessageDef) (result []byte, err error) {
 	prevBuf := ec.borrowBuf()
-	for _, f := range pd.Fields {
-		if f.Repeated {
-			if f.DataType.IsRefType() {
-				err = ec.encodeRefs(e, pd, f)
-			} else {
-				err = ec.encodeValues(e, f)
-			}
-		} else if f.DataType.IsRefType() {
-			item := e.Entities[f.Offset]
-			if item != nil {
-				err = ec.encodeRef(item, pd, f)
-			}
-		} else {
-			value := f.GetPrimitive(e)
-			err = ec.encodeValue(uint64(value), f)
-		}
-		if err != nil {
-			break
-		}
-	}
-	if err == nil {
+	ec.cur.SetBuf(dst)
+	ec.sizeAt = 0
+	if err = ec.encode(e, pd); err == nil {
 		result = ec.cur.Bytes()
 	}
-	if !ownedBuf {
-		ec.returnBuf(prevBuf)
-	}
+	// The buffer must not keep a reference to the memory owned by the
+	// caller after the encoder is returned to the pool.
+	ec.cur.SetBuf(nil)
+	ec.returnBuf(prevBuf)
 	return
 }
 
-func (ec *encoder) encodeValue(value uint64, f *MessageFieldDef) (err error) {
-	extension, ok := tryGetExtension(f)
-	if ok && extension.integerKind != ikDefault {
-		ik := extension.integerKind
-		return ec.encodeValueByKind(value, f, ik)
-	}
-	switch f.DataType {
-	case DtInt32, DtUint32, DtFloat32:
-		if err = ec.encodeTag(f.Tag, WireFixed32); err == nil {
-			err = ec.cur.EncodeFixed32(value)
-		}
-	case DtInt64, DtUint64, DtFloat64:
-		if err = ec.encodeTag(f.Tag, WireFixed64); err == nil {
-			err = ec.cur.EncodeFixed64(value)
-		}
-	case DtBool:
-		if err = ec.encodeTag(f.Tag, WireVarint); err == nil {
-			err = ec.cur.EncodeVarint(value)
+// encode encodes the specified entity into the current buffer against the
+// specified message definition.
+func (ec *encoder) encode(e *Entity, pd *MessageDef) (err error) {
+	p := getPlan(pd)
+	for i := range p.fields {
+		fp := &p.fields[i]
+		if err = fp.encode(ec, e, pd, fp); err != nil {
+			break
 		}
-	default:
-		panic(fmt.Sprintf("unsupported encoding data type %d", f.DataType))
 	}
 	return
 }
 
-func (ec *encoder) encodeValueByKind(
-	value uint64, f *MessageFieldDef, ik integerKind) (err error) {
-	switch ik {
-	case ikVarint:
-		if err = ec.encodeTag(f.Tag, WireVarint); err == nil {
-			err = ec.cur.EncodeVarint(value)
-		}
-	case ikZigZag:
-		if err = ec.encodeTag(f.Tag, WireVarint); err == nil {
-			switch f.DataType {
-			case DtInt32:
-				err = ec.cur.EncodeZigzag32(value)
-			case DtInt64:
-				err = ec.cur.EncodeZigzag64(value)
-			default:
-				panic(fmt.Sprintf("ZigZag encoding is applied to invalid data type %d", f.DataType))
-			}
-		}
-	default:
-		panic(fmt.Sprintf("unsupported value of integer kind %d", ik))
+func (ec *encoder) encodeValue(e *Entity, _ *MessageDef, fp *fieldPlan) (err error) {
+	value := fp.GetPrimitive(e)
+	if err = ec.cur.EncodeRaw(fp.tag); err == nil {
+		err = fp.encodeValue(ec.cur, uint64(value))
 	}
 	return
 }
 
-func (ec *encoder) encodeValues(e *Entity, f *MessageFieldDef) error {
-	data := e.Entities[f.Offset]
-	if data != nil && len(data.Data) > 0 {
-		if err := ec.encodeTag(f.Tag, WireBytes); err != nil {
-			return err
-		}
-		buf, err := ec.encodeValuesPacked(e, f)
-		if err != nil {
-			return err
-		}
-		return ec.cur.EncodeRawBytes(buf)
+func (ec *encoder) encodeValues(e *Entity, _ *MessageDef, fp *fieldPlan) (err error) {
+	data := e.Entities[fp.Offset]
+	if data == nil || len(data.Data) == 0 {
+		return nil
 	}
-	return nil
-}
-
-func (ec *encoder) encodeValuesPacked(
-	e *Entity, f *MessageFieldDef) (buf []byte, err error) {
-	prevBuf := ec.borrowBuf()
-	fn := ec.getValueEncoder(f)
-	n := f.Len(e)
-	for i := 0; i < n; i++ {
-		value := f.GetPrimitiveAt(e, i)
-		err = fn(uint64(value))
-		if err != nil {
-			break
-		}
+	if err = ec.cur.EncodeRaw(fp.tag); err != nil {
+		return
 	}
-	if err == nil {
-		buf = ec.cur.Bytes()
+	if err = ec.cur.EncodeVarint(uint64(ec.nextSize())); err != nil {
+		return
 	}
-	ec.returnBuf(prevBuf)
-	return
-}
-
-func (ec *encoder) encodeRef(
-	e *Entity, pd *MessageDef, f *MessageFieldDef) (err error) {
-	var bytes []byte
-	if f.DataType == DtBytes || f.DataType == DtString {
-		bytes = e.Data
-	} else {
-		def := pd.Registry.GetMessageDef(f.DataType)
-		if bytes, err = ec.encode(e, def, false); err != nil {
-			return
+	n := fp.Len(e)
+	for i := 0; i < n; i++ {
+		value := fp.GetPrimitiveAt(e, i)
+		if err = fp.encodeValue(ec.cur, uint64(value)); err != nil {
+			break
 		}
 	}
-	if err = ec.encodeTag(f.Tag, WireBytes); err == nil {
-		err = ec.cur.EncodeRawBytes(bytes)
-	}
 	return
 }
 
-func (ec *encoder) getValueEncoder(f *MessageFieldDef) func(uint64) error {
-	extension, ok := tryGetExtension(f)
-	if ok && extension.integerKind != ikDefault {
-		switch extension.integerKind {
-		case ikVarint:
-			return ec.cur.EncodeVarint
-		case ikZigZag:
-			switch f.DataType {
-			case DtInt32:
-				return ec.cur.EncodeZigzag32
-			case DtInt64:
-				return ec.cur.EncodeZigzag64
-			default:
-				panic(fmt.Sprintf("ZigZag encoding is applied to invalid data type %d", f.DataType))
-			}
-		default:
-			panic(fmt.Sprintf("unsupported value of integer kind %d", extension.integerKind))
-		}
-	}
-	switch f.DataType {
-	case DtInt32, DtUint32, DtFloat32:
-		return ec.cur.EncodeFixed32
-	case DtInt64, DtUint64, DtFloat64:
-		return ec.cur.EncodeFixed64
-	case DtBool:
-		return ec.cur.EncodeVarint
-	default:
-		panic(fmt.Sprintf("unsupported encoding data type %d", f.DataType))
+func (ec *encoder) encodeRef(e *Entity, pd *MessageDef, fp *fieldPlan) error {
+	item := e.Entities[fp.Offset]
+	if item == nil {
+		return nil
 	}
+	return fp.encodeItem(ec, item, pd, fp)
 }
 
-func (ec *encoder) encodeRefs(e *Entity, pd *MessageDef, f *MessageFieldDef) error {
-	data := e.Entities[f.Offset]
+func (ec *encoder) encodeRefs(e *Entity, pd *MessageDef, fp *fieldPlan) error {
+	data := e.Entities[fp.Offset]
 	if data == nil {
 		return nil
 	}
 	for _, item := range data.Entities {
 		if item == nil {
-			return errors.New("dymessage: repeated field has null item")
+			return errNullItem
 		}
-		if err := ec.encodeRef(item, pd, f); err != nil {
+		if err := fp.encodeItem(ec, item, pd, fp); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (ec *encoder) encodeTag(tag, wire uint64) error {
-	return ec.cur.EncodeVarint(uint64((tag << 3) | wire))
+func (ec *encoder) encodeBytes(item *Entity, _ *MessageDef, fp *fieldPlan) (err error) {
+	if err = ec.cur.EncodeRaw(fp.tag); err == nil {
+		err = ec.cur.EncodeRawBytes(item.Data)
+	}
+	return
+}
+
+func (ec *encoder) encodeEntity(item *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	if err = ec.cur.EncodeRaw(fp.tag); err != nil {
+		return
+	}
+	if data, ok := item.LazyData(); ok {
+		// The entity hasn't been accessed since it has been decoded,
+		// so its data is written as is.
+		return ec.cur.EncodeRawBytes(data)
+	}
+	if err = ec.cur.EncodeVarint(uint64(ec.nextSize())); err != nil {
+		return
+	}
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	return ec.encode(item, def)
+}
+
+// nextSize gets the size of the next nested entity or packed collection, which
+// has been computed by the size pass.
+func (ec *encoder) nextSize() (n int) {
+	n = ec.sizes[ec.sizeAt]
+	ec.sizeAt++
+	return
 }
@@ -57,6 +57,27 @@ var varintProtoTypes = map[DataType]string{
 	DtUint64: "uint64",
 }
 
+// Files of the well-known types, which are imported by the exported .proto
+// files rather than being exported themselves.
+var wellKnownFiles = map[string]string{
+	WellKnownAny:         "google/protobuf/any.proto",
+	WellKnownTimestamp:   "google/protobuf/timestamp.proto",
+	WellKnownDuration:    "google/protobuf/duration.proto",
+	WellKnownDoubleValue: "google/protobuf/wrappers.proto",
+	WellKnownFloatValue:  "google/protobuf/wrappers.proto",
+	WellKnownInt64Value:  "google/protobuf/wrappers.proto",
+	WellKnownUInt64Value: "google/protobuf/wrappers.proto",
+	WellKnownInt32Value:  "google/protobuf/wrappers.proto",
+	WellKnownUInt32Value: "google/protobuf/wrappers.proto",
+	WellKnownBoolValue:   "google/protobuf/wrappers.proto",
+	WellKnownStringValue: "google/protobuf/wrappers.proto",
+	WellKnownBytesValue:  "google/protobuf/wrappers.proto",
+	WellKnownStruct:      "google/protobuf/struct.proto",
+	WellKnownValue:       "google/protobuf/struct.proto",
+	WellKnownListValue:   "google/protobuf/struct.proto",
+	structEntryName:      "google/protobuf/struct.proto",
+}
+
 // -----------------------------------------------------------------------------
 // Locators
 
@@ -99,6 +120,9 @@ func (f *FileSystemLocator) GetImport(ns string) string {
 func ExportToProto(r *Registry, loc ExportLocator) error {
 	files := make(map[string]map[string]*MessageDef)
 	for _, def := range r.Defs {
+		if _, ok := getWellKnownFile(def); ok {
+			continue
+		}
 		p, ok := files[def.Namespace]
 		if !ok {
 			p = make(map[string]*MessageDef)
@@ -127,8 +151,10 @@ func export(r *Registry, namespace string, defs map[string]*MessageDef, loc Expo
 			}
 			if (f.DataType & DtEntity) != 0 {
 				dt := r.GetMessageDef(f.DataType)
-				if dt.Namespace != namespace {
-					imports[dt.Namespace] = nil
+				if file, ok := getWellKnownFile(dt); ok {
+					imports[file] = nil
+				} else if dt.Namespace != namespace {
+					imports[loc.GetImport(dt.Namespace)] = nil
 				}
 			}
 		}
@@ -142,7 +168,7 @@ func export(r *Registry, namespace string, defs map[string]*MessageDef, loc Expo
 			_ = closer.Close()
 		}
 	}()
-	return createTemplate(r, namespace, loc).Execute(wr, struct {
+	return createTemplate(r, namespace).Execute(wr, struct {
 		Ns      string
 		Imports map[string]interface{}
 		Defs    map[string]*MessageDef
@@ -153,6 +179,16 @@ func export(r *Registry, namespace string, defs map[string]*MessageDef, loc Expo
 	})
 }
 
+// getWellKnownFile gets the file, which declares the well-known type, if the
+// message definition represents one.
+func getWellKnownFile(def *MessageDef) (string, bool) {
+	if def.Namespace != WellKnownNamespace {
+		return "", false
+	}
+	file, ok := wellKnownFiles[def.Name]
+	return file, ok
+}
+
 func getBuiltInTypeName(f *MessageFieldDef) string {
 	extension, ok := tryGetExtension(f)
 	if ok && extension.integerKind != ikDefault {
@@ -173,7 +209,7 @@ func getBuiltInTypeName(f *MessageFieldDef) string {
 	}
 }
 
-func createTemplate(reg *Registry, ns string, loc ExportLocator) *template.Template {
+func createTemplate(reg *Registry, ns string) *template.Template {
 	return template.Must(
 		template.New("protodef").Funcs(template.FuncMap{
 			"typename": func(f *MessageFieldDef) string {
@@ -191,24 +227,29 @@ func createTemplate(reg *Registry, ns string, loc ExportLocator) *template.Templ
 			"fieldname": func(s string) string {
 				return strings.ToLower(stringutil.SnakeCaps(s))
 			},
+			"annotation": func(f *MessageFieldDef) string {
+				if lt, ok := f.TryGetLogicalType(); ok {
+					if annotation := lt.ProtoAnnotation(); annotation != "" {
+						return " // " + annotation
+					}
+				}
+				return ""
+			},
 			"modifier": func(f *MessageFieldDef) string {
 				if f.Repeated {
 					return "repeated "
 				}
 				return ""
 			},
-			"import": func(ns string) string {
-				return loc.GetImport(ns)
-			},
 		}).Delims("<", ">").Parse(`syntax = "proto3";
 
 package < .Ns >;
 
-< range $index, $element := .Imports >import "< import $index >";
+< range $index, $element := .Imports >import "< $index >";
 < end >< range .Defs >
 message < .Name >
 {< range .Fields >
-	< modifier . >< typename . > < fieldname .Name > = < .Tag >;
+	< modifier . >< typename . > < fieldname .Name > = < .Tag >;< annotation . >
 < end >}
 < end >`))
 }
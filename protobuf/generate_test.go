@@ -12,6 +12,7 @@ import (
 
 	. "github.com/umk/go-dymessage"
 	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/logical"
 	"github.com/umk/go-testutil"
 )
 
@@ -39,6 +40,16 @@ const (
 	TagHoopoeRegEntity = iota + 100
 )
 
+const (
+	TagMeerkatRegAny = iota + 100
+	TagMeerkatRegTimestamp
+	TagMeerkatRegDuration
+	TagMeerkatRegInt32Value
+	TagMeerkatRegStringValue
+	TagMeerkatRegUuid
+	TagMeerkatRegStruct
+)
+
 func TestExport(t *testing.T) {
 	rb := TestBuilder{
 		RegistryBuilder: NewRegistryBuilder(),
@@ -64,6 +75,13 @@ func TestExport(t *testing.T) {
 
 	// Meerkat
 	rb.CreateTestMessage("Meerkat", "marten.heron", "Meerkat").
+		WithField("RegAny", TagMeerkatRegAny, ForAny(rb.RegistryBuilder)).
+		WithField("RegTimestamp", TagMeerkatRegTimestamp, ForTimestamp(rb.RegistryBuilder)).
+		WithField("RegDuration", TagMeerkatRegDuration, ForDuration(rb.RegistryBuilder)).
+		WithField("RegInt32Value", TagMeerkatRegInt32Value, ForWrapper(rb.RegistryBuilder, DtInt32)).
+		WithField("RegStringValue", TagMeerkatRegStringValue, ForWrapper(rb.RegistryBuilder, DtString)).
+		WithField("RegUuid", TagMeerkatRegUuid, DtBytes).ExtendField(WithLogicalType(logical.UUID)).
+		WithField("RegStruct", TagMeerkatRegStruct, ForStruct(rb.RegistryBuilder)).
 		Build()
 
 	reg, loc := rb.Build(), &testLocator{}
@@ -63,3 +63,6 @@ func (p *Buffer) Bytes() []byte { return p.buf }
 
 // Eob reports whether the Buffer has been read entirely.
 func (p *Buffer) Eob() bool { return p.index >= len(p.buf) }
+
+// Offset returns the read point of the Buffer.
+func (p *Buffer) Offset() int { return p.index }
@@ -94,3 +94,57 @@ func (p *Buffer) EncodeRawBytes(b []byte) error {
 	p.buf = append(p.buf, b...)
 	return nil
 }
+
+// EncodeRaw writes the bytes to the Buffer as is, without
+// a count prefix. This is used to write the pre-encoded tags.
+func (p *Buffer) EncodeRaw(b []byte) error {
+	p.buf = append(p.buf, b...)
+	return nil
+}
+
+// SizeVarint returns the varint encoding size of an integer.
+func SizeVarint(x uint64) int {
+	switch {
+	case x < 1<<7:
+		return 1
+	case x < 1<<14:
+		return 2
+	case x < 1<<21:
+		return 3
+	case x < 1<<28:
+		return 4
+	case x < 1<<35:
+		return 5
+	case x < 1<<42:
+		return 6
+	case x < 1<<49:
+		return 7
+	case x < 1<<56:
+		return 8
+	case x < 1<<63:
+		return 9
+	}
+	return 10
+}
+
+// SizeFixed64 returns the encoding size of a 64-bit integer.
+func SizeFixed64(uint64) int { return 8 }
+
+// SizeFixed32 returns the encoding size of a 32-bit integer.
+func SizeFixed32(uint64) int { return 4 }
+
+// SizeZigzag64 returns the encoding size of a zigzag-encoded 64-bit integer.
+func SizeZigzag64(x uint64) int {
+	return SizeVarint(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+
+// SizeZigzag32 returns the encoding size of a zigzag-encoded 32-bit integer.
+func SizeZigzag32(x uint64) int {
+	return SizeVarint(uint64((uint32(x) << 1) ^ uint32((int32(x) >> 31))))
+}
+
+// SizeRawBytes returns the encoding size of a count-delimited byte buffer
+// of the specified length.
+func SizeRawBytes(n int) int {
+	return SizeVarint(uint64(n)) + n
+}
@@ -0,0 +1,162 @@
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/helpers"
+)
+
+type (
+	// Represents an option, which alters the way the message is decoded
+	// from protocol buffers. Provide the options to the Decode and
+	// DecodeNew functions.
+	DecodeOption func(*decodeOptions)
+
+	decodeOptions struct {
+		limits helpers.Limits
+		// Validates the decoded entity, if set.
+		validate func(*dymessage.Entity, *dymessage.MessageDef) error
+		// The paths of the fields to decode. If empty, all of the
+		// fields are decoded.
+		selectors []selector
+		// The fields to decode resolved in advance, if any.
+		selection *FieldSelection
+		// Whether the nested entities are decoded lazily.
+		lazy bool
+		// Whether the strings and bytes refer the input rather than
+		// its copy.
+		zeroCopy bool
+	}
+)
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	do := decodeOptions{limits: helpers.DefaultLimits()}
+	for _, opt := range opts {
+		opt(&do)
+	}
+	return do
+}
+
+// getSelection gets the fields of the message definition selected to be
+// decoded, or nil if all of the fields are decoded.
+func (do *decodeOptions) getSelection(pd *dymessage.MessageDef) (*selection, error) {
+	fs := do.selection
+	switch {
+	case fs != nil && len(do.selectors) > 0:
+		return nil, errors.New("dymessage: selection cannot be combined with the paths of fields")
+	case fs != nil && fs.pd != pd:
+		return nil, fmt.Errorf("dymessage: selection of %s cannot be applied to %s",
+			fs.pd.QualifiedName(), pd.QualifiedName())
+	case fs != nil:
+		return fs.sel, nil
+	case len(do.selectors) > 0:
+		return newSelection(do.selectors, pd)
+	default:
+		return nil, nil
+	}
+}
+
+// WithMaxDepth limits the nesting depth of the decoded entities, the root one
+// being at depth one. By default the depth is limited by 10000. Zero or a
+// negative value removes the limit.
+func WithMaxDepth(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxDepth = n }
+}
+
+// WithMaxBytes limits the size of the input in bytes. By default the size is
+// not limited.
+func WithMaxBytes(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxBytes = n }
+}
+
+// WithMaxRepeated limits the number of items in each of the repeated fields.
+// By default the number of items is not limited.
+func WithMaxRepeated(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxRepeated = n }
+}
+
+// WithMaxStringLength limits the length in bytes of the string and bytes
+// values. By default the length is not limited.
+func WithMaxStringLength(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxStringLength = n }
+}
+
+// WithValidation makes the decoder validate the entity after it has been
+// decoded, returning the error of the validation if any. Provide the Validate
+// function of the validation package to check the entity against the
+// constraints of the fields.
+func WithValidation(validate func(*dymessage.Entity, *dymessage.MessageDef) error) DecodeOption {
+	return func(do *decodeOptions) { do.validate = validate }
+}
+
+// WithFields makes the decoder populate only the fields with specified paths,
+// where the names of the fields of the nested entities are separated by dots,
+// like "address.city". The path of the field of an entity type selects all of
+// the fields of the nested entity, and the paths going through the repeated
+// fields select the fields of each of the items. Other fields are skipped
+// without being decoded and remain empty in the entity.
+//
+// The paths are resolved every time the message is decoded. Resolve them once
+// with NewFieldSelection and provide them with WithSelection instead, if the
+// same fields are decoded many times.
+func WithFields(paths ...string) DecodeOption {
+	return func(do *decodeOptions) {
+		for _, path := range paths {
+			do.selectors = append(do.selectors, selector{path: path})
+		}
+	}
+}
+
+// WithFieldTags makes the decoder populate only the field with specified path,
+// which is given by the tags of the fields starting at the root entity. See
+// WithFields for details. The option may be specified several times to select
+// several fields.
+func WithFieldTags(tags ...uint64) DecodeOption {
+	return func(do *decodeOptions) {
+		// Copying the tags also makes them non-nil, which tells
+		// the path of tags from the path of names.
+		path := append(make([]uint64, 0, len(tags)), tags...)
+		do.selectors = append(do.selectors, selector{tags: path})
+	}
+}
+
+// WithSelection makes the decoder populate only the fields of the selection,
+// which must have been resolved against the message definition of the decoded
+// entity. See WithFields for details. The option cannot be combined with the
+// WithFields and WithFieldTags options.
+func WithSelection(fs *FieldSelection) DecodeOption {
+	return func(do *decodeOptions) { do.selection = fs }
+}
+
+// WithLazy makes the decoder keep the encoded data of the nested entities and
+// decode them when they are accessed for the first time with the GetReference
+// or GetReferenceAt methods of the message fields, so the nested entities,
+// which are never accessed, are not decoded at all. The options of the decoder
+// apply to the nested entities as well, and their data is checked by the
+// decoder without being decoded, so the errors in the data are reported by the
+// decoder rather than when the entities are accessed. The nested entities,
+// which haven't been decoded, are encoded back to protocol buffers exactly as
+// they were in the input.
+//
+// Because the nested entities are decoded when they are read, the entity
+// decoded with the option is not safe for concurrent reads. See the Load
+// method of the entity for the details.
+func WithLazy() DecodeOption {
+	return func(do *decodeOptions) { do.lazy = true }
+}
+
+// WithZeroCopy makes the decoder store the values of the string and bytes
+// fields as the slices of the input, so the values are not copied. Use the
+// option only if the input outlives the entity and is never modified, like
+// the memory-mapped files, because the entity shares the memory with it. The
+// string and bytes values of the entity must not be modified as well.
+//
+// The entity decoded with the option may be reused by the decoder without the
+// option, which allocates new memory for the strings and bytes rather than
+// overwriting the input. When combined with WithLazy, the input is not copied
+// to be kept by the lazy entities either.
+func WithZeroCopy() DecodeOption {
+	return func(do *decodeOptions) { do.zeroCopy = true }
+}
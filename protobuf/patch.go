@@ -0,0 +1,194 @@
+package protobuf
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/protobuf/internal/impl"
+)
+
+type (
+	// An update of the non-repeated field of the message encoded in
+	// protocol buffers. Depending on the data type of the field, either
+	// the primitive value or the reference is set.
+	FieldUpdate struct {
+		// The path to the field, where the names of the fields of the
+		// nested entities are separated by dots, like "address.zip".
+		// The fields on the path must not be repeated.
+		Path      string
+		Primitive Primitive
+		Reference Reference
+	}
+
+	// The updates of the fields of a single message.
+	patchNode struct {
+		fields map[uint64]*patchField
+		order  []uint64 // The tags of the fields in order of the updates
+	}
+
+	// An update of a single field of the message, which either sets the
+	// value of the field or updates the fields of its nested entity.
+	patchField struct {
+		fp     *fieldPlan
+		def    *MessageDef // The definition of the nested entity, if any
+		update *FieldUpdate
+		nested *patchNode
+	}
+)
+
+// PatchFields applies the updates to the message of the message definition
+// encoded in protocol buffers, rewriting only the updated fields and the length
+// prefixes of the nested entities, which contain them. The rest of the data is
+// copied as is, so the message is neither decoded nor encoded. The data is not
+// modified, and the patched message is returned in a new buffer.
+//
+// If the field is present several times, its last occurrence is replaced and
+// the others are removed. The fields, which are not present, are appended to
+// the message along with the nested entities, which contain them. Setting the
+// null reference to the field of an entity, string or bytes type removes the
+// field.
+func PatchFields(data []byte, pd *MessageDef, updates ...FieldUpdate) ([]byte, error) {
+	root := new(patchNode)
+	for i := range updates {
+		if err := root.add(&updates[i], pd); err != nil {
+			return nil, err
+		}
+	}
+	var out Buffer
+	out.SetBuf(make([]byte, 0, len(data)+len(updates)*16))
+	if err := patchMessage(&out, data, root); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+// add adds the update to the tree of the updates of the message definition.
+func (n *patchNode) add(u *FieldUpdate, pd *MessageDef) error {
+	names := strings.Split(u.Path, ".")
+	for i, name := range names {
+		f, ok := pd.TryGetFieldByName(name)
+		if !ok {
+			return fmt.Errorf("dymessage: unknown field %q of %s", name, pd.QualifiedName())
+		}
+		if f.Repeated {
+			return fmt.Errorf("dymessage: repeated field %q of %s cannot be patched", name, pd.QualifiedName())
+		}
+		last := i == len(names)-1
+		if !last && !f.DataType.IsEntity() {
+			return fmt.Errorf("dymessage: field %q of %s is not a nested entity", name, pd.QualifiedName())
+		}
+		pf, ok := n.fields[f.Tag]
+		if !ok {
+			pf = &patchField{fp: getFieldPlan(pd, f)}
+			if f.DataType.IsEntity() {
+				pf.def = pd.Registry.GetMessageDef(f.DataType)
+			}
+			if n.fields == nil {
+				n.fields = make(map[uint64]*patchField)
+			}
+			n.fields[f.Tag], n.order = pf, append(n.order, f.Tag)
+		}
+		if (last && pf.nested != nil) || (!last && pf.update != nil) {
+			return fmt.Errorf("dymessage: update of %q overlaps other updates", u.Path)
+		}
+		if last {
+			pf.update = u
+			return nil
+		}
+		if pf.nested == nil {
+			pf.nested = new(patchNode)
+		}
+		n, pd = pf.nested, pf.def
+	}
+	return nil
+}
+
+// patchMessage writes the encoded message with the updates applied.
+func patchMessage(out *Buffer, data []byte, n *patchNode) error {
+	// Finding the last occurrences of the updated fields, which are the
+	// only ones to be replaced.
+	last := make(map[uint64]int, len(n.fields))
+	for at := 0; at < len(data); {
+		tag, wire, _, end, err := readField(data, at)
+		if err != nil {
+			return err
+		}
+		if pf, ok := n.fields[tag]; ok {
+			if pf.nested != nil && wire != WireBytes {
+				return unexpectedWire(pf.fp.MessageFieldDef, wire)
+			}
+			last[tag] = at
+		}
+		at = end
+	}
+	// The runs of the fields, which are not updated, are copied at once.
+	start := 0
+	for at := 0; at < len(data); {
+		tag, _, raw, end, _ := readField(data, at)
+		if pf, ok := n.fields[tag]; ok {
+			if err := out.EncodeRaw(data[start:at]); err != nil {
+				return err
+			}
+			if last[tag] == at {
+				if err := pf.write(out, raw); err != nil {
+					return err
+				}
+			}
+			start = end
+		}
+		at = end
+	}
+	if err := out.EncodeRaw(data[start:]); err != nil {
+		return err
+	}
+	for _, tag := range n.order {
+		if _, ok := last[tag]; !ok {
+			if err := n.fields[tag].write(out, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write writes the updated field. The raw parameter holds the encoded nested
+// entity the fields of which are updated, or nil if the entity is missing.
+func (pf *patchField) write(out *Buffer, raw []byte) error {
+	fp := pf.fp
+	if pf.nested != nil {
+		var nested Buffer
+		if err := patchMessage(&nested, raw, pf.nested); err != nil {
+			return err
+		}
+		return writeBytes(out, fp, nested.Bytes())
+	}
+	u := pf.update
+	switch {
+	case fp.DataType.IsRefType() && u.Reference.Entity == nil:
+		return nil
+	case fp.DataType.IsEntity():
+		data, err := Encode(u.Reference.Entity, pf.def)
+		if err != nil {
+			return err
+		}
+		return writeBytes(out, fp, data)
+	case fp.DataType.IsRefType():
+		return writeBytes(out, fp, u.Reference.ToBytes())
+	default:
+		if err := out.EncodeRaw(fp.tag); err != nil {
+			return err
+		}
+		return fp.encodeValue(out, uint64(u.Primitive))
+	}
+}
+
+func writeBytes(out *Buffer, fp *fieldPlan, data []byte) error {
+	if err := out.EncodeRaw(fp.tag); err != nil {
+		return err
+	}
+	return out.EncodeRawBytes(data)
+}
@@ -0,0 +1,189 @@
+package protobuf
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/protobuf/internal/impl"
+)
+
+type (
+	// A codec plan compiled once for a message definition. The plan
+	// provides the encoders and decoders for each of the fields, so the
+	// data types and extensions of the fields are not inspected every
+	// time the entity is encoded or decoded.
+	plan struct {
+		fields []fieldPlan
+		// Indices of the field plans by tags of the fields. The tags
+		// less than the length of the slice are looked up here, while
+		// the greater ones are looked up in the map.
+		byTag    []int32
+		byTagMap map[uint64]int
+	}
+
+	// A plan to encode and decode a single field of the message.
+	fieldPlan struct {
+		*MessageFieldDef
+		// The tag of the field, encoded together with the wire type,
+		// which is used when the field is written.
+		tag []byte
+
+		// Encodes the field or gets its encoded size.
+		encode func(ec *encoder, e *Entity, pd *MessageDef, fp *fieldPlan) error
+		size   func(ec *encoder, e *Entity, pd *MessageDef, fp *fieldPlan) int
+
+		// Encodes a single item of the reference field or gets its
+		// encoded size. Not set for the primitive fields.
+		encodeItem func(ec *encoder, item *Entity, pd *MessageDef, fp *fieldPlan) error
+		sizeItem   func(ec *encoder, item *Entity, pd *MessageDef, fp *fieldPlan) int
+
+		// Encodes and decodes a single primitive value or gets its
+		// encoded size. Not set for the reference fields.
+		encodeValue func(*Buffer, uint64) error
+		decodeValue func(*Buffer) (uint64, error)
+		sizeValue   func(uint64) int
+
+		// The field, which receives the tag of the current one when
+		// it's decoded. Set only for the members of a oneof.
+		oneof *MessageFieldDef
+	}
+)
+
+// The maximum tag of a field to be looked up in the slice rather than a map.
+const maxDenseTag = 1024
+
+var planMarker PlanMarker
+
+func init() {
+	planMarker = RegisterPlan()
+}
+
+// getPlan gets the codec plan for the message definition, compiling it if
+// necessary.
+func getPlan(pd *MessageDef) *plan {
+	return pd.GetPlan(planMarker, compilePlan).(*plan)
+}
+
+// lookup gets the index of the field plan by the field tag. If there is no
+// field with such tag, the method returns -1.
+func (p *plan) lookup(tag uint64) int {
+	if tag < uint64(len(p.byTag)) {
+		return int(p.byTag[tag])
+	}
+	if i, ok := p.byTagMap[tag]; ok {
+		return i
+	}
+	return -1
+}
+
+// getFieldPlan gets the plan of the field of the message definition, or nil if
+// the field doesn't belong to the definition.
+func getFieldPlan(pd *MessageDef, f *MessageFieldDef) *fieldPlan {
+	p := getPlan(pd)
+	if i := p.lookup(f.Tag); i >= 0 && p.fields[i].MessageFieldDef == f {
+		return &p.fields[i]
+	}
+	return nil
+}
+
+func compilePlan(pd *MessageDef) interface{} {
+	p := &plan{fields: make([]fieldPlan, len(pd.Fields))}
+	var maxTag uint64
+	for i, f := range pd.Fields {
+		p.fields[i] = compileField(f)
+		if f.Tag > maxTag && f.Tag <= maxDenseTag {
+			maxTag = f.Tag
+		}
+	}
+	if pd.IsWellKnown(WellKnownValue) {
+		compileOneof(p, pd.GetField(TagValueKind))
+	}
+	p.byTag = make([]int32, maxTag+1)
+	for i := range p.byTag {
+		p.byTag[i] = -1
+	}
+	for i, f := range pd.Fields {
+		if f.Tag <= maxTag {
+			p.byTag[f.Tag] = int32(i)
+		} else {
+			if p.byTagMap == nil {
+				p.byTagMap = make(map[uint64]int)
+			}
+			p.byTagMap[f.Tag] = i
+		}
+	}
+	return p
+}
+
+func compileField(f *MessageFieldDef) (fp fieldPlan) {
+	fp.MessageFieldDef = f
+	var wire uint64 = WireBytes
+	switch {
+	case f.DataType == DtString || f.DataType == DtBytes:
+		fp.encodeItem, fp.sizeItem = (*encoder).encodeBytes, (*encoder).sizeOfBytes
+	case f.DataType.IsEntity():
+		fp.encodeItem, fp.sizeItem = (*encoder).encodeEntity, (*encoder).sizeOfEntity
+	default:
+		wire = compileValueCoders(&fp)
+	}
+	switch {
+	case f.Repeated && f.DataType.IsRefType():
+		fp.encode, fp.size = (*encoder).encodeRefs, (*encoder).sizeOfRefs
+	case f.Repeated:
+		// The repeated primitive values are always packed.
+		fp.encode, fp.size = (*encoder).encodeValues, (*encoder).sizeOfValues
+		wire = WireBytes
+	case f.DataType.IsRefType():
+		fp.encode, fp.size = (*encoder).encodeRef, (*encoder).sizeOfRef
+	default:
+		fp.encode, fp.size = (*encoder).encodeValue, (*encoder).sizeOfValue
+	}
+	var buf Buffer
+	_ = buf.EncodeVarint((f.Tag << 3) | wire)
+	fp.tag = buf.Bytes()
+	return
+}
+
+// compileValueCoders assigns the encoders and decoders of the primitive value
+// to the field plan and returns the wire type of a single value.
+func compileValueCoders(fp *fieldPlan) (wire uint64) {
+	f := fp.MessageFieldDef
+	extension, ok := tryGetExtension(f)
+	if ok && extension.integerKind != ikDefault {
+		switch ik := extension.integerKind; ik {
+		case ikVarint:
+			fp.encodeValue, fp.decodeValue, fp.sizeValue =
+				(*Buffer).EncodeVarint, (*Buffer).DecodeVarint, SizeVarint
+		case ikZigZag:
+			switch f.DataType {
+			case DtInt32:
+				fp.encodeValue, fp.decodeValue, fp.sizeValue =
+					(*Buffer).EncodeZigzag32, (*Buffer).DecodeZigzag32, SizeZigzag32
+			case DtInt64:
+				fp.encodeValue, fp.decodeValue, fp.sizeValue =
+					(*Buffer).EncodeZigzag64, (*Buffer).DecodeZigzag64, SizeZigzag64
+			default:
+				panic(fmt.Sprintf("ZigZag encoding is applied to invalid data type %d", f.DataType))
+			}
+		default:
+			panic(fmt.Sprintf("unsupported value of integer kind %d", ik))
+		}
+		return WireVarint
+	}
+	switch f.DataType {
+	case DtInt32, DtUint32, DtFloat32:
+		fp.encodeValue, fp.decodeValue, fp.sizeValue =
+			(*Buffer).EncodeFixed32, (*Buffer).DecodeFixed32, SizeFixed32
+		return WireFixed32
+	case DtInt64, DtUint64, DtFloat64:
+		fp.encodeValue, fp.decodeValue, fp.sizeValue =
+			(*Buffer).EncodeFixed64, (*Buffer).DecodeFixed64, SizeFixed64
+		return WireFixed64
+	case DtBool:
+		fp.encodeValue, fp.decodeValue, fp.sizeValue =
+			(*Buffer).EncodeVarint, (*Buffer).DecodeVarint, SizeVarint
+		return WireVarint
+	default:
+		panic(fmt.Sprintf("unsupported encoding data type %d", f.DataType))
+	}
+}
@@ -1,6 +1,7 @@
 package protobuf
 
 import (
+	"io"
 	"sync"
 
 	"github.com/umk/go-dymessage"
@@ -21,6 +22,23 @@ type encoder struct {
 	// A collection of buffers to reuse for encoding and decoding of the
 	// nested entities.
 	bufs []*impl.Buffer
+	// The sizes of nested entities and packed collections in the order
+	// they are visited by the encoder. The sizes are computed by the size
+	// pass before the entity is encoded, so the length prefixes can be
+	// written without encoding the nested data into separate buffers.
+	sizes []int
+	// Index of the next item in sizes to be consumed by the encoder.
+	sizeAt int
+	// A buffer to reuse for writing the encoded entities to io.Writer.
+	scratch []byte
+	// The options of decoding and the nesting depth of the entity
+	// currently being decoded.
+	opts  decodeOptions
+	depth int
+	// Indicates whether the data being decoded has already been checked,
+	// which is the case for the lazy entities, so the data of the nested
+	// lazy entities is not checked again.
+	checked bool
 }
 
 func init() {
@@ -31,16 +49,60 @@ func init() {
 // format. If the entity type doesn't correspond the data type of the message
 // definition, the method will panic.
 func Encode(e *dymessage.Entity, pd *dymessage.MessageDef) ([]byte, error) {
-	encoder := getEncoder()
-	buf, err := encoder.encode(e, pd, true)
-	putEncoder(encoder)
-	return buf, err
+	return AppendEncode(nil, e, pd)
+}
+
+// AppendEncode encodes the data from the dynamic entity into a protocol
+// buffers format and appends the result to dst, growing it if necessary. The
+// extended buffer is returned. If the entity type doesn't correspond the data
+// type of the message definition, the method will panic.
+func AppendEncode(
+	dst []byte, e *dymessage.Entity, pd *dymessage.MessageDef) ([]byte, error) {
+	ec := getEncoder()
+	n := ec.size(e, pd)
+	if cap(dst)-len(dst) < n {
+		buf := make([]byte, len(dst), len(dst)+n)
+		copy(buf, dst)
+		dst = buf
+	}
+	result, err := ec.encodeInto(dst, e, pd)
+	putEncoder(ec)
+	return result, err
+}
+
+// EncodeTo encodes the data from the dynamic entity into a protocol buffers
+// format and writes the result to w with a single call of its Write method.
+// If the entity type doesn't correspond the data type of the message
+// definition, the method will panic.
+func EncodeTo(w io.Writer, e *dymessage.Entity, pd *dymessage.MessageDef) error {
+	ec := getEncoder()
+	n := ec.size(e, pd)
+	if cap(ec.scratch) < n {
+		ec.scratch = make([]byte, 0, n)
+	}
+	buf, err := ec.encodeInto(ec.scratch[:0], e, pd)
+	if err == nil {
+		_, err = w.Write(buf)
+	}
+	putEncoder(ec)
+	return err
+}
+
+// Size returns the number of bytes the dynamic entity takes when encoded into
+// a protocol buffers format. If the entity type doesn't correspond the data
+// type of the message definition, the method will panic.
+func Size(e *dymessage.Entity, pd *dymessage.MessageDef) int {
+	ec := getEncoder()
+	n := ec.size(e, pd)
+	putEncoder(ec)
+	return n
 }
 
 // DecodeNew transforms the protocol buffers representation of the message to a
 // dynamic entity against the provided message definition.
-func DecodeNew(b []byte, pd *dymessage.MessageDef) (*dymessage.Entity, error) {
-	return Decode(b, pd, pd.NewEntity())
+func DecodeNew(
+	b []byte, pd *dymessage.MessageDef, opts ...DecodeOption) (*dymessage.Entity, error) {
+	return Decode(b, pd, pd.NewEntity(), opts...)
 }
 
 // Decode transforms the protocol buffers representation of the message to
@@ -50,9 +112,27 @@ func DecodeNew(b []byte, pd *dymessage.MessageDef) (*dymessage.Entity, error) {
 //
 // If the entity type doesn't correspond the data type of the message
 // definition, the method will panic.
-func Decode(b []byte, pd *dymessage.MessageDef, e *dymessage.Entity) (*dymessage.Entity, error) {
+func Decode(
+	b []byte, pd *dymessage.MessageDef, e *dymessage.Entity,
+	opts ...DecodeOption) (*dymessage.Entity, error) {
 	ec := getEncoder()
-	err := ec.decode(b, pd, e)
+	ec.opts, ec.checked = newDecodeOptions(opts), false
+	err := ec.opts.limits.CheckBytes(len(b))
+	var sel *selection
+	if err == nil {
+		sel, err = ec.opts.getSelection(pd)
+	}
+	if err == nil {
+		if ec.opts.lazy && !ec.opts.zeroCopy {
+			// The lazy entities keep the parts of the input, so it
+			// must not be modified by the caller afterwards.
+			b = append([]byte(nil), b...)
+		}
+		err = ec.decode(b, pd, e, sel)
+	}
+	if err == nil && ec.opts.validate != nil {
+		err = ec.opts.validate(e, pd)
+	}
 	putEncoder(ec)
 	return e, err
 }
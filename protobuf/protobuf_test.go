@@ -1,12 +1,18 @@
 package protobuf
 
 import (
+	"bytes"
 	"github.com/umk/go-dymessage"
 	"github.com/umk/go-dymessage/protobuf/internal/testdata"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/stretchr/testify/require"
 
 	. "github.com/umk/go-dymessage/internal/testing"
@@ -87,3 +93,543 @@ func testEncodeDecode(t *testing.T, message proto.Message, setup func(*dymessage
 	// Checking values of the converted message.
 	AssertEncodeDecode(t, def, entity2)
 }
+
+func TestAppendEncode(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+	require.Equal(t, Size(entity, def), len(data))
+
+	prefix := []byte{1, 2, 3}
+	appended, err := AppendEncode(prefix, entity, def)
+	require.NoError(t, err)
+	require.Equal(t, prefix, appended[:len(prefix)])
+	require.Equal(t, data, appended[len(prefix):])
+
+	var buf bytes.Buffer
+	err = EncodeTo(&buf, entity, def)
+	require.NoError(t, err)
+	require.Equal(t, data, buf.Bytes())
+
+	entity2, err := DecodeNew(appended[len(prefix):], def)
+	require.NoError(t, err)
+	AssertEncodeDecode(t, def, entity2)
+}
+
+func TestEncodeDecodeResetPlans(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+
+	// Compiling the plans for the regular encoding first.
+	_, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	// Altering the fields in place requires the plans to be reset in
+	// order to take effect.
+	WithVarint()(def.GetField(TagRegInt32))
+	WithVarint()(def.GetField(TagArrInt32))
+	def.Registry.ResetPlans()
+
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	message := new(testdata.TestMessageRegular)
+	require.Error(t, proto.Unmarshal(data, message))
+
+	entity2, err := DecodeNew(data, def)
+	require.NoError(t, err)
+	AssertEncodeDecode(t, def, entity2)
+}
+
+func TestDecodeLimits(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opt  DecodeOption
+		fail bool
+	}{
+		{"depth", WithMaxDepth(3), false},
+		{"depth exceeded", WithMaxDepth(2), true},
+		{"bytes", WithMaxBytes(len(data)), false},
+		{"bytes exceeded", WithMaxBytes(len(data) - 1), true},
+		{"repeated", WithMaxRepeated(3), false},
+		{"repeated exceeded", WithMaxRepeated(2), true},
+		{"string", WithMaxStringLength(20), false},
+		{"string exceeded", WithMaxStringLength(19), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeNew(data, def, test.opt)
+			if test.fail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDecodeDefaultMaxDepth(t *testing.T) {
+	def, _ := ArrangeEncodeDecode()
+
+	// Composing a message nested deeper than allowed by default.
+	var data []byte
+	for i := 0; i < 10001; i++ {
+		size := proto.EncodeVarint(uint64(len(data)))
+		data = append(append([]byte{TagRegEntity<<3 | 2}, size...), data...)
+	}
+
+	_, err := DecodeNew(data, def)
+	require.Error(t, err)
+}
+
+func TestDecodeUnexpectedWire(t *testing.T) {
+	def, _ := ArrangeEncodeDecode()
+
+	tests := []struct {
+		data    []byte
+		message string
+	}{
+		{[]byte{TagRegString<<3 | 0, 1}, "dymessage: field RegString has unexpected wire type 0"},
+		{[]byte{TagRegString<<3 | 5, 1, 2, 3, 4}, "dymessage: field RegString has unexpected wire type 5"},
+		{[]byte{TagRegEntity<<3 | 1, 1, 2, 3, 4, 5, 6, 7, 8}, "dymessage: field RegEntity has unexpected wire type 1"},
+		{[]byte{TagArrEntity<<3 | 0, 1}, "dymessage: field ArrEntity has unexpected wire type 0"},
+	}
+	for _, test := range tests {
+		_, err := DecodeNew(test.data, def)
+		require.EqualError(t, err, test.message)
+	}
+}
+
+func TestTimestampDuration(t *testing.T) {
+	rb := dymessage.NewRegistryBuilder()
+	tsType, durType := ForTimestamp(rb), ForDuration(rb)
+	require.Equal(t, tsType, ForTimestamp(rb))
+	def := rb.ForMessageDef("message").
+		WithField("Time", 1, tsType).
+		WithField("Elapsed", 2, durType).
+		Build()
+	rb.Build()
+	tsDef, durDef := def.Registry.GetMessageDef(tsType), def.Registry.GetMessageDef(durType)
+
+	e := def.NewEntity()
+	_, ok := GetTime(e, def, 1)
+	require.False(t, ok)
+	now := time.Date(2019, 5, 1, 10, 20, 30, 123456789, time.FixedZone("", 3600))
+	SetTime(e, def, 1, now)
+	SetDuration(e, def, 2, -1500*time.Millisecond)
+	actual, ok := GetTime(e, def, 1)
+	require.True(t, ok)
+	require.True(t, now.Equal(actual))
+	elapsed, ok := GetDuration(e, def, 2)
+	require.True(t, ok)
+	require.Equal(t, -1500*time.Millisecond, elapsed)
+
+	// The messages are compatible with the generated ones.
+	data, err := Encode(def.GetField(1).GetReference(e).ToEntity(), tsDef)
+	require.NoError(t, err)
+	var ts timestamp.Timestamp
+	require.NoError(t, proto.Unmarshal(data, &ts))
+	require.Equal(t, now.Unix(), ts.Seconds)
+	require.Equal(t, int32(123456789), ts.Nanos)
+	data, err = Encode(def.GetField(2).GetReference(e).ToEntity(), durDef)
+	require.NoError(t, err)
+	var dur duration.Duration
+	require.NoError(t, proto.Unmarshal(data, &dur))
+	require.Equal(t, int64(-1), dur.Seconds)
+	require.Equal(t, int32(-500000000), dur.Nanos)
+
+	data, err = proto.Marshal(&duration.Duration{Seconds: 3, Nanos: 5})
+	require.NoError(t, err)
+	nested, err := DecodeNew(data, durDef)
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Second+5, ToDuration(nested, durDef))
+}
+
+func TestWrappers(t *testing.T) {
+	rb := dymessage.NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithField("Int64", 1, ForWrapper(rb, dymessage.DtInt64)).
+		WithField("String", 2, ForWrapper(rb, dymessage.DtString)).
+		Build()
+	rb.Build()
+
+	e := def.NewEntity()
+	_, ok := GetWrappedPrimitive(e, def, 1)
+	require.False(t, ok)
+	SetWrappedPrimitive(e, def, 1, dymessage.FromInt64(-5))
+	value, ok := GetWrappedPrimitive(e, def, 1)
+	require.True(t, ok)
+	require.Equal(t, int64(-5), value.ToInt64())
+	SetWrappedReference(e, def, 2, dymessage.FromString("string"))
+	ref, ok := GetWrappedReference(e, def, 2)
+	require.True(t, ok)
+	require.Equal(t, "string", ref.ToString())
+
+	// The messages are compatible with the generated ones.
+	wrapperDef := def.Registry.GetMessageDef(def.GetField(1).DataType)
+	data, err := Encode(def.GetField(1).GetReference(e).ToEntity(), wrapperDef)
+	require.NoError(t, err)
+	var w wrappers.Int64Value
+	require.NoError(t, proto.Unmarshal(data, &w))
+	require.Equal(t, int64(-5), w.Value)
+
+	require.Panics(t, func() { ForWrapper(rb, def.DataType) })
+}
+
+func TestStruct(t *testing.T) {
+	rb := dymessage.NewRegistryBuilder()
+	structType := ForStruct(rb)
+	require.Equal(t, structType, ForStruct(rb))
+	reg := rb.Build()
+	def := reg.GetMessageDef(structType)
+
+	m := map[string]interface{}{
+		"null":   nil,
+		"number": 0,
+		"string": "",
+		"bool":   false,
+		"struct": map[string]interface{}{"nested": 1.5},
+		"list":   []interface{}{true, "item", nil},
+	}
+	e, err := StructFromMap(m, def)
+	require.NoError(t, err)
+	m["number"] = float64(0)
+	require.Equal(t, m, StructToMap(e, def))
+
+	// The messages are compatible with the generated ones.
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	var s structpb.Struct
+	require.NoError(t, proto.Unmarshal(data, &s))
+	require.Equal(t, &structpb.Value_NullValue{}, s.Fields["null"].Kind)
+	require.Equal(t, &structpb.Value_NumberValue{}, s.Fields["number"].Kind)
+	require.Equal(t, &structpb.Value_StringValue{}, s.Fields["string"].Kind)
+	require.Equal(t, &structpb.Value_BoolValue{}, s.Fields["bool"].Kind)
+	require.Equal(t, 1.5, s.Fields["struct"].GetStructValue().Fields["nested"].GetNumberValue())
+	require.Len(t, s.Fields["list"].GetListValue().Values, 3)
+
+	data, err = proto.Marshal(&s)
+	require.NoError(t, err)
+	e, err = DecodeNew(data, def)
+	require.NoError(t, err)
+	require.Equal(t, m, StructToMap(e, def))
+
+	_, err = StructFromMap(map[string]interface{}{"invalid": struct{}{}}, def)
+	require.Error(t, err)
+}
+
+func TestDecodeSelectedFields(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	// Decoding into an entity populated with all of the fields, so the
+	// fields, which are not selected, must be reset.
+	decoded, err := Decode(data, def, entity,
+		WithFields("RegInt64", "RegEntity.RegString", "RegEntity.ArrEntity.RegBool"),
+		WithFieldTags(TagRegEntity, TagArrInt32))
+	require.NoError(t, err)
+
+	require.Equal(t, int64(-254715376635680503), def.GetField(TagRegInt64).GetPrimitive(decoded).ToInt64())
+	require.Equal(t, int32(0), def.GetField(TagRegInt32).GetPrimitive(decoded).ToInt32())
+	require.Empty(t, def.GetField(TagRegString).GetReference(decoded).ToString())
+	child := def.GetField(TagRegEntity).GetReference(decoded).Entity
+	require.NotNil(t, child)
+	require.Equal(t, "Zy0RVazdEe459Y0DErUJ", def.GetField(TagRegString).GetReference(child).ToString())
+	require.Equal(t, 2, def.GetField(TagArrInt32).Len(child))
+	require.Equal(t, 0, def.GetField(TagArrInt64).Len(child))
+	require.Equal(t, 0.0, def.GetField(TagRegFloat64).GetPrimitive(child).ToFloat64())
+	require.Nil(t, def.GetField(TagRegEntity).GetReference(child).Entity)
+	require.Equal(t, 3, def.GetField(TagArrEntity).Len(child))
+
+	// Selecting a nested entity as a whole overrides its selected fields.
+	decoded, err = DecodeNew(data, def, WithFields("RegEntity.RegString", "RegEntity"))
+	require.NoError(t, err)
+	child = def.GetField(TagRegEntity).GetReference(decoded).Entity
+	require.Equal(t, uint32(783509315), def.GetField(TagRegUint32).GetPrimitive(child).ToUint32())
+	require.Equal(t, 2, def.GetField(TagArrString).Len(child))
+
+	// The selection resolved in advance gives the same result.
+	fs, err := NewFieldSelection(def, "RegEntity.RegString", "RegEntity")
+	require.NoError(t, err)
+	decoded, err = DecodeNew(data, def, WithSelection(fs))
+	require.NoError(t, err)
+	child = def.GetField(TagRegEntity).GetReference(decoded).Entity
+	require.Equal(t, 2, def.GetField(TagArrString).Len(child))
+	require.Equal(t, int64(0), def.GetField(TagRegInt64).GetPrimitive(decoded).ToInt64())
+	fs, err = NewFieldTagSelection(def, []uint64{TagRegInt64})
+	require.NoError(t, err)
+	decoded, err = DecodeNew(data, def, WithSelection(fs))
+	require.NoError(t, err)
+	require.Equal(t, int64(-254715376635680503), def.GetField(TagRegInt64).GetPrimitive(decoded).ToInt64())
+	require.Nil(t, def.GetField(TagRegEntity).GetReference(decoded).Entity)
+	_, err = NewFieldSelection(def, "Unknown")
+	require.EqualError(t, err, `dymessage: unknown field "Unknown" of koala.goshawk.Message`)
+	_, err = NewFieldTagSelection(def, nil)
+	require.EqualError(t, err, "dymessage: path of field tags is empty")
+
+	tests := []struct {
+		opt     DecodeOption
+		message string
+	}{
+		{WithFields("RegEntity.Unknown"), `dymessage: unknown field "Unknown" of koala.goshawk.Message`},
+		{WithFields("RegString.Length"), `dymessage: field "RegString" of koala.goshawk.Message is not a nested entity`},
+		{WithFieldTags(TagRegEntity, 100), "dymessage: unknown field with tag 100 of koala.goshawk.Message"},
+		{WithFieldTags(TagArrInt32, 1), "dymessage: field with tag 11 of koala.goshawk.Message is not a nested entity"},
+		{WithFieldTags(), "dymessage: path of field tags is empty"},
+	}
+	for _, test := range tests {
+		_, err = DecodeNew(data, def, test.opt)
+		require.EqualError(t, err, test.message)
+	}
+	_, err = DecodeNew(data, def, WithSelection(fs), WithFields("RegInt32"))
+	require.EqualError(t, err, "dymessage: selection cannot be combined with the paths of fields")
+	rb := dymessage.NewRegistryBuilder()
+	other := rb.ForMessageDef("other").WithName("Other").WithField("RegInt64", TagRegInt64, dymessage.DtInt64).Build()
+	rb.Build()
+	fs, err = NewFieldSelection(other, "RegInt64")
+	require.NoError(t, err)
+	_, err = DecodeNew(data, def, WithSelection(fs))
+	require.EqualError(t, err, "dymessage: selection of Other cannot be applied to koala.goshawk.Message")
+}
+
+func TestDecodeLazy(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	decoded, err := DecodeNew(data, def, WithLazy(), WithMaxDepth(3))
+	require.NoError(t, err)
+	regEntity, arrEntity := def.GetField(TagRegEntity), def.GetField(TagArrEntity)
+	_, lazy := decoded.Entities[regEntity.Offset].LazyData()
+	require.True(t, lazy)
+
+	// The nested entities, which haven't been accessed, are encoded as
+	// they were in the input.
+	encoded, err := Encode(decoded, def)
+	require.NoError(t, err)
+	require.Equal(t, data, encoded)
+
+	child := regEntity.GetReference(decoded).Entity
+	_, lazy = child.LazyData()
+	require.False(t, lazy)
+	require.Equal(t, "Zy0RVazdEe459Y0DErUJ", def.GetField(TagRegString).GetReference(child).ToString())
+	_, lazy = arrEntity.GetReference(child).Entities[1].LazyData()
+	require.True(t, lazy)
+
+	require.NoError(t, arrEntity.GetReference(child).Entities[1].Load())
+
+	def.GetField(TagRegInt32).SetPrimitive(child, dymessage.FromInt32(1))
+	encoded, err = Encode(decoded, def)
+	require.NoError(t, err)
+	decoded, err = DecodeNew(encoded, def)
+	require.NoError(t, err)
+	child = regEntity.GetReference(decoded).Entity
+	require.Equal(t, int32(1), def.GetField(TagRegInt32).GetPrimitive(child).ToInt32())
+	require.Equal(t, 3, arrEntity.Len(child))
+
+	// The data of the nested entities is checked by the decoder, so the
+	// limits and the malformed data are reported before the entities are
+	// accessed.
+	_, err = DecodeNew(data, def, WithLazy(), WithMaxDepth(2))
+	require.EqualError(t, err, "dymessage: entities are nested deeper than the maximum depth of 2")
+	_, err = DecodeNew(data, def, WithLazy(), WithMaxRepeated(2))
+	require.EqualError(t, err, `dymessage: field "ArrEntity" contains more than the maximum of 2 items`)
+	malformed := []byte{TagRegEntity<<3 | 2, 3, TagRegEntity<<3 | 2, 1, 0xff}
+	validate := func(e *dymessage.Entity, pd *dymessage.MessageDef) error {
+		regEntity.GetReference(e)
+		return nil
+	}
+	_, err = DecodeNew(malformed, def, WithLazy(), WithValidation(validate))
+	require.Error(t, err)
+	malformed = []byte{TagRegEntity<<3 | 2, 2, TagRegString<<3 | 0, 1}
+	_, err = DecodeNew(malformed, def, WithLazy())
+	require.EqualError(t, err, "dymessage: field RegString has unexpected wire type 0")
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	decoded, err := DecodeNew(data, def, WithZeroCopy(), WithLazy())
+	require.NoError(t, err)
+	AssertEncodeDecode(t, def, decoded)
+
+	// The values refer the input, so changing the input changes them.
+	regString, regBytes := def.GetField(TagRegString), def.GetField(TagRegBytes)
+	value := regString.GetReference(decoded).ToBytes()
+	require.Equal(t, len(value), cap(value))
+	i := bytes.Index(data, value)
+	require.True(t, i >= 0)
+	data[i] = 'X'
+	require.Equal(t, "XJFzUzsO2O8auQAlVmJy", regString.GetReference(decoded).ToString())
+	// The lazy entities refer the input as well.
+	i = bytes.Index(data, []byte("HN89fTSfx2it9Ma11Ufj"))
+	require.True(t, i >= 0)
+	data[i] = 'X'
+	child := def.GetField(TagRegEntity).GetReference(decoded).Entity
+	require.Equal(t, "XN89fTSfx2it9Ma11Ufj", def.GetField(TagArrString).GetReferenceAt(child, 0).ToString())
+
+	// Without the option the values are copied.
+	decoded, err = DecodeNew(data, def)
+	require.NoError(t, err)
+	value = regBytes.GetReference(decoded).ToBytes()
+	i = bytes.Index(data, value)
+	data[i]++
+	require.Equal(t, []byte{24, 40, 107, 129, 64}, value)
+
+	// Reusing the entity decoded with the option doesn't overwrite its input.
+	decoded, err = DecodeNew(data, def, WithZeroCopy())
+	require.NoError(t, err)
+	input := append([]byte(nil), data...)
+	other := def.NewEntity()
+	regString.SetReference(other, dymessage.FromString("abc"))
+	regBytes.SetReference(other, dymessage.FromBytes([]byte{1, 2}, false))
+	otherData, err := Encode(other, def)
+	require.NoError(t, err)
+	_, err = Decode(otherData, def, decoded)
+	require.NoError(t, err)
+	require.Equal(t, input, data)
+	require.Equal(t, "abc", regString.GetReference(decoded).ToString())
+}
+
+func TestView(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	for _, indexed := range []bool{false, true} {
+		v := NewView(data, def)
+		if indexed {
+			require.NoError(t, v.BuildIndex())
+		}
+		value, err := v.GetPrimitive(def.GetField(TagRegInt64))
+		require.NoError(t, err)
+		require.Equal(t, int64(-254715376635680503), value.ToInt64())
+		s, err := v.GetString(def.GetField(TagRegString))
+		require.NoError(t, err)
+		require.Equal(t, "LJFzUzsO2O8auQAlVmJy", s)
+		has, err := v.Has(def.GetField(TagArrEntity))
+		require.NoError(t, err)
+		require.False(t, has)
+
+		child, err := v.GetView(def.GetField(TagRegEntity))
+		require.NoError(t, err)
+		require.NotNil(t, child)
+		value, err = child.GetPrimitive(def.GetField(TagRegFloat32))
+		require.NoError(t, err)
+		require.Equal(t, float32(80116.7676), value.ToFloat32())
+		b, err := child.GetBytes(def.GetField(TagRegBytes))
+		require.NoError(t, err)
+		require.Equal(t, []byte{232, 153, 178, 190, 4, 82}, b)
+
+		var values []int32
+		it := child.Items(def.GetField(TagArrInt32))
+		for it.Next() {
+			values = append(values, it.Primitive().ToInt32())
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []int32{313261865, 209295014}, values)
+
+		var strings []string
+		it = child.Items(def.GetField(TagArrString))
+		for it.Next() {
+			strings = append(strings, string(it.Bytes()))
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, []string{"HN89fTSfx2it9Ma11Ufj", "f4nuZTeXQmsvR6MBPkC"}, strings)
+
+		n := 0
+		it = child.Items(def.GetField(TagArrEntity))
+		for it.Next() {
+			nested, err := it.View().GetView(def.GetField(TagRegEntity))
+			require.NoError(t, err)
+			require.Nil(t, nested)
+			n++
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, 3, n)
+		n, err = child.Len(def.GetField(TagArrBool))
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+	}
+
+	// The malformed data and unexpected wire types are reported.
+	_, err = NewView([]byte{TagRegInt64<<3 | 1, 1}, def).GetPrimitive(def.GetField(TagRegInt64))
+	require.Error(t, err)
+	require.Error(t, NewView([]byte{TagRegString<<3 | 2, 5, 1}, def).BuildIndex())
+	_, err = NewView([]byte{TagRegString<<3 | 0, 1}, def).GetString(def.GetField(TagRegString))
+	require.EqualError(t, err, "dymessage: field RegString has unexpected wire type 0")
+}
+
+func TestPatchFields(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+	original := append([]byte(nil), data...)
+
+	replaced := def.NewEntity()
+	def.GetField(TagRegBool).SetPrimitive(replaced, dymessage.FromBool(true))
+	patched, err := PatchFields(data, def,
+		FieldUpdate{Path: "RegInt32", Primitive: dymessage.FromInt32(7)},
+		FieldUpdate{Path: "RegString", Reference: dymessage.FromString("patched")},
+		FieldUpdate{Path: "RegBytes"},
+		FieldUpdate{Path: "RegEntity.RegUint64", Primitive: dymessage.FromUint64(1 << 40)},
+		FieldUpdate{Path: "RegEntity.RegEntity.RegEntity.RegFloat64", Primitive: dymessage.FromFloat64(2.5)},
+		FieldUpdate{Path: "RegEntity.RegEntity.RegString", Reference: dymessage.FromString("nested")})
+	require.NoError(t, err)
+	require.Equal(t, original, data)
+
+	// Applying the same changes to the entity.
+	def.GetField(TagRegInt32).SetPrimitive(entity, dymessage.FromInt32(7))
+	def.GetField(TagRegString).SetReference(entity, dymessage.FromString("patched"))
+	def.GetField(TagRegBytes).SetReference(entity, dymessage.GetDefaultReference())
+	child := def.GetField(TagRegEntity).GetReference(entity).Entity
+	def.GetField(TagRegUint64).SetPrimitive(child, dymessage.FromUint64(1<<40))
+	grandchild := def.GetField(TagRegEntity).GetReference(child).Entity
+	def.GetField(TagRegString).SetReference(grandchild, dymessage.FromString("nested"))
+	nested := def.NewEntity()
+	def.GetField(TagRegFloat64).SetPrimitive(nested, dymessage.FromFloat64(2.5))
+	def.GetField(TagRegEntity).SetReference(grandchild, dymessage.FromEntity(nested))
+
+	decoded, err := DecodeNew(patched, def)
+	require.NoError(t, err)
+	require.True(t, dymessage.Equal(entity, decoded, def))
+
+	// The nested entity is replaced as a whole.
+	patched, err = PatchFields(patched, def,
+		FieldUpdate{Path: "RegEntity", Reference: dymessage.FromEntity(replaced)})
+	require.NoError(t, err)
+	decoded, err = DecodeNew(patched, def)
+	require.NoError(t, err)
+	def.GetField(TagRegEntity).SetReference(entity, dymessage.FromEntity(replaced))
+	require.True(t, dymessage.Equal(entity, decoded, def))
+
+	// The last occurrence of the field is replaced.
+	duplicated := []byte{TagRegBool << 3, 1, TagRegInt32<<3 | 5, 1, 0, 0, 0, TagRegBool << 3, 0}
+	patched, err = PatchFields(duplicated, def, FieldUpdate{Path: "RegBool", Primitive: dymessage.FromBool(true)})
+	require.NoError(t, err)
+	require.Equal(t, []byte{TagRegInt32<<3 | 5, 1, 0, 0, 0, TagRegBool << 3, 1}, patched)
+
+	tests := []struct {
+		update  FieldUpdate
+		message string
+	}{
+		{FieldUpdate{Path: "RegEntity.Unknown"}, `dymessage: unknown field "Unknown" of koala.goshawk.Message`},
+		{FieldUpdate{Path: "ArrInt32"}, `dymessage: repeated field "ArrInt32" of koala.goshawk.Message cannot be patched`},
+		{FieldUpdate{Path: "RegInt32.RegInt32"}, `dymessage: field "RegInt32" of koala.goshawk.Message is not a nested entity`},
+	}
+	for _, test := range tests {
+		_, err = PatchFields(data, def, test.update)
+		require.EqualError(t, err, test.message)
+	}
+	_, err = PatchFields(data, def, FieldUpdate{Path: "RegEntity"}, FieldUpdate{Path: "RegEntity.RegInt32"})
+	require.EqualError(t, err, `dymessage: update of "RegEntity.RegInt32" overlaps other updates`)
+	_, err = PatchFields(data[:len(data)-1], def, FieldUpdate{Path: "RegInt32"})
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package protocod
+
+import (
+	"github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+// PackAny creates an entity of google.protobuf.Any message definition, which
+// contains the provided entity of the message definition encoded to the
+// protocol buffers format along with the URL of its type, provided by the
+// resolver.
+func PackAny(
+	value *dymessage.Entity, def, anyDef *dymessage.MessageDef, r Resolver) (*dymessage.Entity, error) {
+	url, err := r.TypeURL(def)
+	if err != nil {
+		return nil, err
+	}
+	data, err := protobuf.Encode(value, def)
+	if err != nil {
+		return nil, err
+	}
+	e := anyDef.NewEntity()
+	anyDef.GetField(protobuf.TagAnyTypeUrl).SetReference(e, dymessage.FromString(url))
+	anyDef.GetField(protobuf.TagAnyValue).SetReference(e, dymessage.FromBytes(data, false))
+	return e, nil
+}
+
+// UnpackAny decodes the entity contained in the entity of google.protobuf.Any
+// message definition. If the type URL doesn't represent a type, known to the
+// resolver, the method will return an error.
+func UnpackAny(a *dymessage.Entity, anyDef *dymessage.MessageDef, r Resolver) (*dymessage.Entity, error) {
+	url := anyDef.GetField(protobuf.TagAnyTypeUrl).GetReference(a).ToString()
+	def, err := r.ResolveURL(url)
+	if err != nil {
+		return nil, err
+	}
+	value := anyDef.GetField(protobuf.TagAnyValue).GetReference(a).ToBytes()
+	return protobuf.DecodeNew(value, def)
+}
+
+// SetAny packs the entity and sets it to the field of google.protobuf.Any type.
+// Both the entity and the field must belong to the registry of the cache.
+// Setting a nil entity clears the field. See PackAny for details.
+func SetAny(e *dymessage.Entity, f *dymessage.MessageFieldDef, value *dymessage.Entity, cache *QnameCache) error {
+	if value == nil {
+		f.SetReference(e, dymessage.GetDefaultReference())
+		return nil
+	}
+	def := cache.reg.GetMessageDef(value.DataType)
+	a, err := PackAny(value, def, cache.reg.GetMessageDef(f.DataType), cache)
+	if err != nil {
+		return err
+	}
+	f.SetReference(e, dymessage.FromEntity(a))
+	return nil
+}
+
+// GetAny unpacks the entity contained in the field of google.protobuf.Any type.
+// The field must belong to the registry of the cache. If the field is not set,
+// the method returns nil. See UnpackAny for details.
+func GetAny(e *dymessage.Entity, f *dymessage.MessageFieldDef, cache *QnameCache) (*dymessage.Entity, error) {
+	a := f.GetReference(e).ToEntity()
+	if a == nil {
+		return nil, nil
+	}
+	return UnpackAny(a, cache.reg.GetMessageDef(f.DataType), cache)
+}
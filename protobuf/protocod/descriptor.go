@@ -0,0 +1,132 @@
+package protocod
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+// A message declared in the file descriptor set.
+type declaredMessage struct {
+	desc    *descriptor.DescriptorProto
+	builder *dymessage.MessageDefBuilder
+}
+
+// NewDescriptorRegistry creates a registry of the message definitions declared
+// in the file descriptor set, like the one produced by protoc with the
+// --descriptor_set_out option. The nested messages are named after the
+// messages they are nested in, like "Outer.Inner", and the enumerations are
+// represented by 32-bit integers. The groups are not supported.
+func NewDescriptorRegistry(fds *descriptor.FileDescriptorSet) (*dymessage.Registry, error) {
+	rb := dymessage.NewRegistryBuilder()
+	var messages []declaredMessage
+	var declare func(ns, prefix string, desc *descriptor.DescriptorProto)
+	declare = func(ns, prefix string, desc *descriptor.DescriptorProto) {
+		name := prefix + desc.GetName()
+		mb := rb.ForMessageDef(getFullName(ns, name)).
+			WithNamespace(ns).
+			WithName(name)
+		messages = append(messages, declaredMessage{desc: desc, builder: mb})
+		for _, nested := range desc.GetNestedType() {
+			declare(ns, name+".", nested)
+		}
+	}
+	for _, file := range fds.GetFile() {
+		for _, desc := range file.GetMessageType() {
+			declare(file.GetPackage(), "", desc)
+		}
+	}
+	for _, m := range messages {
+		for _, f := range m.desc.GetField() {
+			if err := addDescriptorField(rb, m.builder, f); err != nil {
+				return nil, err
+			}
+		}
+		m.builder.Build()
+	}
+	return rb.Build(), nil
+}
+
+// NewDescriptorResolver creates a resolver of the message types declared in the
+// file descriptor set. See NewDescriptorRegistry for details.
+func NewDescriptorResolver(fds *descriptor.FileDescriptorSet) (*QnameCache, error) {
+	reg, err := NewDescriptorRegistry(fds)
+	if err != nil {
+		return nil, err
+	}
+	return NewQnameCache(reg), nil
+}
+
+func addDescriptorField(
+	rb *dymessage.RegistryBuilder, mb *dymessage.MessageDefBuilder, f *descriptor.FieldDescriptorProto) error {
+	var dt dymessage.DataType
+	var ext func(*dymessage.MessageFieldDef)
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		dt, ext = dymessage.DtInt32, protobuf.WithVarint()
+	case descriptor.FieldDescriptorProto_TYPE_INT64:
+		dt, ext = dymessage.DtInt64, protobuf.WithVarint()
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		dt, ext = dymessage.DtUint32, protobuf.WithVarint()
+	case descriptor.FieldDescriptorProto_TYPE_UINT64:
+		dt, ext = dymessage.DtUint64, protobuf.WithVarint()
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		dt, ext = dymessage.DtInt32, protobuf.WithZigZag()
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		dt, ext = dymessage.DtInt64, protobuf.WithZigZag()
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		dt = dymessage.DtInt32
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		dt = dymessage.DtInt64
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		dt = dymessage.DtUint32
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		dt = dymessage.DtUint64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		dt = dymessage.DtFloat32
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		dt = dymessage.DtFloat64
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		dt = dymessage.DtBool
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		dt = dymessage.DtString
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		dt = dymessage.DtBytes
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		// The type names produced by protoc are fully qualified and
+		// start with a dot.
+		name := f.GetTypeName()
+		if len(name) > 0 && name[0] == '.' {
+			name = name[1:]
+		}
+		nested, ok := rb.TryGetMessageDef(name)
+		if !ok {
+			return fmt.Errorf("dymessage: type %q of field %q could not be found", name, f.GetName())
+		}
+		dt = nested.GetDataType()
+	default:
+		return fmt.Errorf("dymessage: type %v of field %q is not supported", f.GetType(), f.GetName())
+	}
+	tag := uint64(f.GetNumber())
+	if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		mb.WithArrayField(f.GetName(), tag, dt)
+	} else {
+		mb.WithField(f.GetName(), tag, dt)
+	}
+	if ext != nil {
+		mb.ExtendField(ext)
+	}
+	return nil
+}
+
+// getFullName gets the qualified name of the message, which is used as a key of
+// the message definition in the registry builder.
+func getFullName(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+	return ns + "." + name
+}
@@ -1,67 +1,135 @@
 package protocod
 
 import (
-	"fmt"
+	"errors"
+	"strings"
 
-	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
 
 	"github.com/umk/go-dymessage"
 	"github.com/umk/go-dymessage/protobuf"
 )
 
-// Provides the fast lookup of the message definitions by its qualified names
-// for the purpose of encoding and decoding the dynamic messages.
+var errNoRegistry = errors.New("dymessage: resolver is not bound to a single registry")
+
+// Provides the fast lookup of the message definitions of a registry by their
+// qualified names for the purpose of encoding and decoding the dynamic
+// messages. The cache implements the Resolver interface.
 type QnameCache struct {
 	reg *dymessage.Registry
 	// The mapping from the qualified name of the messages to its definitions.
 	types map[string]*dymessage.MessageDef
+	// The prefix of the type URLs, and a value indicating whether only the
+	// URLs with this prefix can be resolved.
+	prefix string
+	strict bool
 }
 
+// NewQnameCache creates a cache of the message definitions of the registry.
+// The cache produces the type URLs with the default prefix, and resolves the
+// URLs with any prefix.
 func NewQnameCache(reg *dymessage.Registry) *QnameCache {
 	types := make(map[string]*dymessage.MessageDef)
 	for _, def := range reg.Defs {
-		qname := getMessageQname(def)
+		qname := def.QualifiedName()
 		types[qname] = def
 	}
-	return &QnameCache{reg: reg, types: types}
+	return &QnameCache{reg: reg, types: types, prefix: DefaultURLPrefix}
+}
+
+// WithPrefix creates a cache, which shares the message definitions with the
+// current one, but produces the type URLs with specified prefix, like
+// "example.com/types/", and resolves only the URLs with this prefix. This
+// makes possible to chain the caches of the registries, which contain the
+// types with the same names.
+func (c *QnameCache) WithPrefix(prefix string) *QnameCache {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &QnameCache{reg: c.reg, types: c.types, prefix: prefix, strict: true}
+}
+
+// ResolveURL gets the message definition by the type URL.
+func (c *QnameCache) ResolveURL(url string) (*dymessage.MessageDef, error) {
+	prefix, name, err := splitTypeURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if def, ok := c.types[name]; ok && (!c.strict || prefix == c.prefix) {
+		return def, nil
+	}
+	return nil, &UnknownTypeError{Name: name}
 }
 
-// EncodeAny encodes provided dynamic entity to Protocol Buffer's Any type. If
-// the type of the entity doesn't belong to the registry, provided during
-// creation of Encoder, the method will panic.
-func EncodeAny(value *dymessage.Entity, cache *QnameCache) (*any.Any, error) {
-	def := cache.reg.GetMessageDef(value.DataType)
-	if data, err := protobuf.Encode(value, def); err != nil {
+// TypeURL gets the type URL of the message definition, which must belong to the
+// registry of the cache.
+func (c *QnameCache) TypeURL(def *dymessage.MessageDef) (string, error) {
+	name := def.QualifiedName()
+	if c.types[name] != def {
+		return "", &UnknownTypeError{Name: name}
+	}
+	return c.prefix + name, nil
+}
+
+// EncodeAny encodes provided dynamic entity to Protocol Buffer's Any type. The
+// message definition of the entity is looked up by its data type in the
+// registry the resolver is bound to, and the type URL is provided by the
+// resolver. If the resolver isn't bound to a single registry, like the chain
+// of resolvers of different registries, use the EncodeAnyDef function.
+func EncodeAny(value *dymessage.Entity, r Resolver) (*any.Any, error) {
+	reg, err := getRegistry(r)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeAnyDef(value, reg.GetMessageDef(value.DataType), r)
+}
+
+// EncodeAnyDef encodes provided dynamic entity of the message definition to
+// Protocol Buffer's Any type. The type URL is provided by the resolver.
+func EncodeAnyDef(value *dymessage.Entity, def *dymessage.MessageDef, r Resolver) (*any.Any, error) {
+	url, err := r.TypeURL(def)
+	if err != nil {
+		return nil, err
+	}
+	data, err := protobuf.Encode(value, def)
+	if err != nil {
 		return nil, err
-	} else {
-		return &any.Any{
-			TypeUrl: "type.googleapis.com/" + getMessageQname(def),
-			Value:   data,
-		}, nil
 	}
+	return &any.Any{TypeUrl: url, Value: data}, nil
 }
 
-// EncodeAny encodes provided Protocol Buffer's Any type to dynamic entity. If
-// the type URL of provided value doesn't represent a type, known to Encoder,
-// the method will return an error.
-func DecodeAny(value *any.Any, cache *QnameCache) (*dymessage.Entity, error) {
-	if name, err := ptypes.AnyMessageName(value); err != nil {
+// DecodeAny decodes provided Protocol Buffer's Any type to dynamic entity. If
+// the type URL of provided value doesn't represent a type, known to the
+// resolver, the method will return an error.
+func DecodeAny(value *any.Any, r Resolver) (*dymessage.Entity, error) {
+	def, err := r.ResolveURL(value.TypeUrl)
+	if err != nil {
 		return nil, err
-	} else if def, ok := cache.types[name]; !ok {
-		return nil, fmt.Errorf("dymessage: type %q could not be found", name)
-	} else {
-		return protobuf.DecodeNew(value.Value, def)
 	}
+	return protobuf.DecodeNew(value.Value, def)
 }
 
-// getMessageQname gets the qualified name of dynamic type as it would be
-// generated by a Protocol Buffers code on the client side when using
-// the .proto definitions, autogenerated from dynamic message definitions.
-func getMessageQname(def *dymessage.MessageDef) string {
-	if len(def.Namespace) == 0 {
-		return def.Name
-	} else {
-		return def.Namespace + "." + def.Name
+// getRegistry gets the registry the resolver is bound to, which is either the
+// registry of the cache, or the registry shared by all of the caches of the
+// chain of resolvers.
+func getRegistry(r Resolver) (reg *dymessage.Registry, err error) {
+	switch r := r.(type) {
+	case *QnameCache:
+		return r.reg, nil
+	case ChainResolver:
+		for _, nested := range r {
+			var nr *dymessage.Registry
+			if nr, err = getRegistry(nested); err != nil {
+				return nil, err
+			}
+			if reg != nil && reg != nr {
+				return nil, errNoRegistry
+			}
+			reg = nr
+		}
+		if reg != nil {
+			return reg, nil
+		}
 	}
+	return nil, errNoRegistry
 }
@@ -3,8 +3,12 @@ package protocod
 import (
 	"testing"
 
+	"github.com/golang/protobuf/ptypes/any"
 	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
 	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/protobuf"
 )
 
 func TestEncodeDecode(t *testing.T) {
@@ -33,3 +37,40 @@ func TestDecodeUnknownType(t *testing.T) {
 	entity, err = DecodeAny(any, cache)
 	require.Error(t, err)
 }
+
+func TestAnyField(t *testing.T) {
+	rb := TestBuilder{RegistryBuilder: NewRegistryBuilder()}
+	def := rb.CreateTestMessage("message", "koala.goshawk", "Message").
+		WithField("RegAny", 100, protobuf.ForAny(rb.RegistryBuilder)).
+		Build()
+	cache := NewQnameCache(def.Registry)
+	f := def.GetField(100)
+
+	value := def.NewEntity()
+	def.GetField(TagRegInt32).SetPrimitive(value, FromInt32(5))
+
+	e := def.NewEntity()
+	actual, err := GetAny(e, f, cache)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+
+	require.NoError(t, SetAny(e, f, value, cache))
+	actual, err = GetAny(e, f, cache)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), def.GetField(TagRegInt32).GetPrimitive(actual).ToInt32())
+
+	// The field is compatible with the Any message of golang/protobuf.
+	data, err := protobuf.Encode(e, def)
+	require.NoError(t, err)
+	e, err = protobuf.DecodeNew(data, def)
+	require.NoError(t, err)
+	var a any.Any
+	require.NoError(t, ToProto(f.GetReference(e).ToEntity(), def.Registry.GetMessageDef(f.DataType), &a))
+	require.Equal(t, "type.googleapis.com/koala.goshawk.Message", a.TypeUrl)
+	actual, err = DecodeAny(&a, cache)
+	require.NoError(t, err)
+	require.Equal(t, int32(5), def.GetField(TagRegInt32).GetPrimitive(actual).ToInt32())
+
+	require.NoError(t, SetAny(e, f, nil, cache))
+	require.Nil(t, f.GetReference(e).ToEntity())
+}
@@ -0,0 +1,74 @@
+package protocod
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	"github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+// Wraps the dynamic entity along with its message definition, so the entity
+// can be passed to the APIs expecting proto.Message. The message implements
+// the proto.Marshaler and proto.Unmarshaler interfaces, so the functions of
+// the golang/protobuf library encode and decode it just like the dynamic
+// entity would be encoded and decoded by the protobuf package.
+type Message struct {
+	Entity *dymessage.Entity
+	Def    *dymessage.MessageDef
+}
+
+// NewMessage creates a message with an empty entity of the message definition.
+func NewMessage(md *dymessage.MessageDef) *Message {
+	return &Message{Entity: md.NewEntity(), Def: md}
+}
+
+// FromProto converts the generated message to the entity of the message
+// definition, which must be compatible with the message in terms of the
+// protocol buffers wire format. The values of the fields, which are not known
+// to the message definition, are discarded.
+func FromProto(m proto.Message, md *dymessage.MessageDef) (*dymessage.Entity, error) {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return protobuf.DecodeNew(data, md)
+}
+
+// ToProto converts the entity of the message definition to the generated
+// message, which must be compatible with the message definition in terms of
+// the protocol buffers wire format. The message is reset before the values are
+// copied to it.
+func ToProto(e *dymessage.Entity, md *dymessage.MessageDef, m proto.Message) error {
+	data, err := protobuf.Encode(e, md)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// -----------------------------------------------------------------------------
+// Message implementation
+
+func (m *Message) Reset() { m.Entity = m.Def.NewEntity() }
+
+func (m *Message) String() string { return dymessage.Sprint(m.Entity, m.Def) }
+
+func (*Message) ProtoMessage() {}
+
+// XXX_MessageName gets the qualified name of the message definition. The
+// golang/protobuf library uses this method to get the name of the message,
+// which is not registered in its registry of types, like when packing the
+// message to Any.
+func (m *Message) XXX_MessageName() string { return m.Def.QualifiedName() }
+
+func (m *Message) Marshal() ([]byte, error) {
+	return protobuf.Encode(m.Entity, m.Def)
+}
+
+func (m *Message) Unmarshal(b []byte) error {
+	if m.Entity == nil {
+		m.Entity = m.Def.NewEntity()
+	}
+	_, err := protobuf.Decode(b, m.Def, m.Entity)
+	return err
+}
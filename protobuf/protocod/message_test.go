@@ -0,0 +1,65 @@
+package protocod
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+func TestProtoConversion(t *testing.T) {
+	def := arrangeTimestamp()
+
+	ts := &timestamp.Timestamp{Seconds: -62135596800, Nanos: 123456789}
+	e, err := FromProto(ts, def)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-62135596800), def.GetField(1).GetPrimitive(e).ToInt64())
+	assert.Equal(t, int32(123456789), def.GetField(2).GetPrimitive(e).ToInt32())
+
+	var actual timestamp.Timestamp
+	require.NoError(t, ToProto(e, def, &actual))
+	assert.True(t, proto.Equal(ts, &actual))
+}
+
+func TestMessage(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+
+	// Encoding the message with the golang/protobuf library.
+	data, err := proto.Marshal(&Message{Entity: entity, Def: def})
+	require.NoError(t, err)
+
+	m := NewMessage(def)
+	require.NoError(t, proto.Unmarshal(data, m))
+	AssertEncodeDecode(t, def, m.Entity)
+
+	// Checking the message is compatible with the APIs accepting the
+	// proto.Message interface.
+	any, err := ptypes.MarshalAny(m)
+	require.NoError(t, err)
+	assert.Equal(t, "type.googleapis.com/koala.goshawk.Message", any.TypeUrl)
+	data2, err := protobuf.Encode(entity, def)
+	require.NoError(t, err)
+	assert.Equal(t, data2, any.Value)
+	assert.NotEmpty(t, m.String())
+
+	m.Reset()
+	assert.Equal(t, int32(0), def.GetField(TagRegInt32).GetPrimitive(m.Entity).ToInt32())
+}
+
+func arrangeTimestamp() *dymessage.MessageDef {
+	return dymessage.NewRegistryBuilder().ForMessageDef("timestamp").
+		WithNamespace("google.protobuf").
+		WithName("Timestamp").
+		WithField("seconds", 1, dymessage.DtInt64).
+		ExtendField(protobuf.WithVarint()).
+		WithField("nanos", 2, dymessage.DtInt32).
+		ExtendField(protobuf.WithVarint()).
+		Build()
+}
@@ -0,0 +1,85 @@
+package protocod
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/umk/go-dymessage"
+)
+
+type (
+	// Resolves the message definitions by the URLs of their types, which
+	// are stored in Any along with the packed entities, and the type URLs
+	// by the message definitions.
+	Resolver interface {
+		// ResolveURL gets the message definition by the type URL. If
+		// the resolver doesn't know the type, it must return an error
+		// of *UnknownTypeError type.
+		ResolveURL(url string) (*dymessage.MessageDef, error)
+		// TypeURL gets the type URL of the message definition. If the
+		// resolver doesn't know the message definition, it must return
+		// an error of *UnknownTypeError type.
+		TypeURL(def *dymessage.MessageDef) (string, error)
+	}
+
+	// Combines multiple resolvers, which are queried in order until
+	// one of them knows the type.
+	ChainResolver []Resolver
+
+	// An error returned by the resolvers, which don't know the type.
+	UnknownTypeError struct {
+		Name string // Qualified name of the type
+	}
+)
+
+// The prefix of the type URLs used by default.
+const DefaultURLPrefix = "type.googleapis.com/"
+
+// -----------------------------------------------------------------------------
+// Chain resolver
+
+// ResolveURL gets the message definition by the type URL from the first of the
+// resolvers, which knows the type.
+func (cr ChainResolver) ResolveURL(url string) (*dymessage.MessageDef, error) {
+	for _, r := range cr {
+		def, err := r.ResolveURL(url)
+		if _, ok := err.(*UnknownTypeError); !ok {
+			return def, err
+		}
+	}
+	return nil, &UnknownTypeError{Name: getTypeName(url)}
+}
+
+// TypeURL gets the type URL of the message definition from the first of the
+// resolvers, which knows the message definition.
+func (cr ChainResolver) TypeURL(def *dymessage.MessageDef) (string, error) {
+	for _, r := range cr {
+		url, err := r.TypeURL(def)
+		if _, ok := err.(*UnknownTypeError); !ok {
+			return url, err
+		}
+	}
+	return "", &UnknownTypeError{Name: def.QualifiedName()}
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("dymessage: type %q could not be found", e.Name)
+}
+
+// splitTypeURL splits the type URL into the prefix, which ends with a slash,
+// and the qualified name of the type.
+func splitTypeURL(url string) (prefix, name string, err error) {
+	slash := strings.LastIndexByte(url, '/')
+	if slash < 0 || slash == len(url)-1 {
+		return "", "", fmt.Errorf("dymessage: type URL %q is invalid", url)
+	}
+	return url[:slash+1], url[slash+1:], nil
+}
+
+// getTypeName gets the qualified name of the type for the error messages.
+func getTypeName(url string) string {
+	return url[strings.LastIndexByte(url, '/')+1:]
+}
@@ -0,0 +1,130 @@
+package protocod
+
+import (
+	"testing"
+
+	gendesc "github.com/golang/protobuf/descriptor"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+func TestPrefix(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	cache := NewQnameCache(def.Registry).WithPrefix("example.com/types")
+	any, err := EncodeAny(entity, cache)
+	require.NoError(t, err)
+	require.Equal(t, "example.com/types/"+def.QualifiedName(), any.TypeUrl)
+	entity, err = DecodeAny(any, cache)
+	require.NoError(t, err)
+	AssertEncodeDecode(t, def, entity)
+	// Only the URLs with the prefix of the cache are resolved.
+	any.TypeUrl = DefaultURLPrefix + def.QualifiedName()
+	_, err = DecodeAny(any, cache)
+	require.IsType(t, &UnknownTypeError{}, err)
+	// The default cache resolves the URLs with any prefix.
+	_, err = DecodeAny(any, NewQnameCache(def.Registry))
+	require.NoError(t, err)
+	_, err = NewQnameCache(def.Registry).ResolveURL(def.QualifiedName())
+	require.Error(t, err)
+}
+
+func TestChainResolver(t *testing.T) {
+	createDef := func(tag uint64) *MessageDef {
+		return NewRegistryBuilder().ForMessageDef(0).
+			WithNamespace("koala.goshawk").
+			WithName("Message").
+			WithField("value", tag, DtInt32).
+			ExtendField(protobuf.WithVarint()).
+			Build()
+	}
+	def1, def2 := createDef(1), createDef(2)
+	r := ChainResolver{
+		NewQnameCache(def1.Registry).WithPrefix("tenant1.example.com"),
+		NewQnameCache(def2.Registry).WithPrefix("tenant2.example.com"),
+	}
+
+	e := def2.NewEntity()
+	def2.GetField(2).SetPrimitive(e, FromInt32(7))
+	any, err := EncodeAnyDef(e, def2, r)
+	require.NoError(t, err)
+	require.Equal(t, "tenant2.example.com/koala.goshawk.Message", any.TypeUrl)
+	e, err = DecodeAny(any, r)
+	require.NoError(t, err)
+	require.Equal(t, def2.DataType, e.DataType)
+	require.Equal(t, int32(7), def2.GetField(2).GetPrimitive(e).ToInt32())
+
+	any.TypeUrl = "tenant3.example.com/koala.goshawk.Message"
+	_, err = DecodeAny(any, r)
+	require.IsType(t, &UnknownTypeError{}, err)
+	_, err = EncodeAnyDef(e, createDef(3), r)
+	require.IsType(t, &UnknownTypeError{}, err)
+	// The data type of the entity is ambiguous for the chain of the
+	// resolvers of different registries.
+	_, err = EncodeAny(e, r)
+	require.EqualError(t, err, "dymessage: resolver is not bound to a single registry")
+}
+
+func TestDescriptorResolver(t *testing.T) {
+	fd, _ := gendesc.ForMessage(&timestamp.Timestamp{})
+	fds := &descriptor.FileDescriptorSet{File: []*descriptor.FileDescriptorProto{fd, {
+		Name:    proto.String("koala.proto"),
+		Package: proto.String("koala.goshawk"),
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Message"),
+			Field: []*descriptor.FieldDescriptorProto{{
+				Name:     proto.String("created"),
+				Number:   proto.Int32(1),
+				Label:    descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".google.protobuf.Timestamp"),
+			}, {
+				Name:     proto.String("items"),
+				Number:   proto.Int32(2),
+				Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".koala.goshawk.Message.Item"),
+			}},
+			NestedType: []*descriptor.DescriptorProto{{
+				Name: proto.String("Item"),
+				Field: []*descriptor.FieldDescriptorProto{{
+					Name:   proto.String("value"),
+					Number: proto.Int32(1),
+					Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:   descriptor.FieldDescriptorProto_TYPE_SINT64.Enum(),
+				}},
+			}},
+		}},
+	}}}
+	r, err := NewDescriptorResolver(fds)
+	require.NoError(t, err)
+
+	def, err := r.ResolveURL(DefaultURLPrefix + "koala.goshawk.Message")
+	require.NoError(t, err)
+	itemDef, err := r.ResolveURL(DefaultURLPrefix + "koala.goshawk.Message.Item")
+	require.NoError(t, err)
+	require.Equal(t, "koala.goshawk", itemDef.Namespace)
+	require.Equal(t, "Message.Item", itemDef.Name)
+	require.True(t, def.GetFieldByName("items").Repeated)
+	require.Equal(t, itemDef.DataType, def.GetFieldByName("items").DataType)
+
+	// The messages are compatible with the generated ones.
+	data, err := proto.Marshal(&timestamp.Timestamp{Seconds: 1500000000, Nanos: 15})
+	require.NoError(t, err)
+	tsDef, err := r.ResolveURL(DefaultURLPrefix + "google.protobuf.Timestamp")
+	require.NoError(t, err)
+	e, err := protobuf.DecodeNew(data, tsDef)
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000000), tsDef.GetFieldByName("seconds").GetPrimitive(e).ToInt64())
+	require.Equal(t, int32(15), tsDef.GetFieldByName("nanos").GetPrimitive(e).ToInt32())
+
+	// Groups are not supported.
+	fds.File[1].MessageType[0].Field[0].Type = descriptor.FieldDescriptorProto_TYPE_GROUP.Enum()
+	_, err = NewDescriptorResolver(fds)
+	require.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package protobuf
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// The fields of the message definition selected to be decoded, which
+	// are resolved once and then provided to the decoder with the
+	// WithSelection option any number of times. The selection is safe for
+	// concurrent use.
+	FieldSelection struct {
+		pd  *MessageDef
+		sel *selection
+	}
+
+	// A path of the field to decode, given either by the names of the
+	// fields or by their tags.
+	selector struct {
+		path string
+		tags []uint64
+	}
+
+	// The fields of the entity selected to be decoded by their tags. The
+	// selection of the nested entity is nil if all of its fields are
+	// selected.
+	selection struct {
+		fields map[uint64]*selection
+	}
+)
+
+// NewFieldSelection resolves the paths of the fields of the message definition
+// to be decoded. See WithFields for the format of the paths.
+func NewFieldSelection(pd *MessageDef, paths ...string) (*FieldSelection, error) {
+	selectors := make([]selector, len(paths))
+	for i, path := range paths {
+		selectors[i].path = path
+	}
+	return compileSelection(selectors, pd)
+}
+
+// NewFieldTagSelection resolves the paths of the fields of the message
+// definition to be decoded, where each of the paths is given by the tags of the
+// fields starting at the root entity.
+func NewFieldTagSelection(pd *MessageDef, paths ...[]uint64) (*FieldSelection, error) {
+	selectors := make([]selector, len(paths))
+	for i, tags := range paths {
+		// Copying the tags also makes them non-nil, which tells the
+		// path of tags from the path of names.
+		selectors[i].tags = append(make([]uint64, 0, len(tags)), tags...)
+	}
+	return compileSelection(selectors, pd)
+}
+
+func compileSelection(selectors []selector, pd *MessageDef) (*FieldSelection, error) {
+	sel, err := newSelection(selectors, pd)
+	if err != nil {
+		return nil, err
+	}
+	return &FieldSelection{pd: pd, sel: sel}, nil
+}
+
+// newSelection gets the fields of the message definition selected by the paths
+// to be decoded.
+func newSelection(selectors []selector, pd *MessageDef) (*selection, error) {
+	sel := new(selection)
+	for _, s := range selectors {
+		var fields []*MessageFieldDef
+		var err error
+		if s.tags != nil {
+			fields, err = resolveTags(s.tags, pd)
+		} else {
+			fields, err = resolveNames(s.path, pd)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sel.add(fields)
+	}
+	return sel, nil
+}
+
+// add adds the path of the fields to the selection. If any of the fields on the
+// path has been selected as a whole, the selection is left intact.
+func (sel *selection) add(fields []*MessageFieldDef) {
+	for i, f := range fields {
+		if sel.fields == nil {
+			sel.fields = make(map[uint64]*selection)
+		}
+		if i == len(fields)-1 {
+			sel.fields[f.Tag] = nil
+			return
+		}
+		nested, ok := sel.fields[f.Tag]
+		if ok && nested == nil {
+			return
+		}
+		if !ok {
+			nested = new(selection)
+			sel.fields[f.Tag] = nested
+		}
+		sel = nested
+	}
+}
+
+func resolveNames(path string, pd *MessageDef) ([]*MessageFieldDef, error) {
+	names := strings.Split(path, ".")
+	fields := make([]*MessageFieldDef, len(names))
+	for i, name := range names {
+		f, ok := pd.TryGetFieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("dymessage: unknown field %q of %s", name, pd.QualifiedName())
+		}
+		fields[i] = f
+		if pd, ok = nextDef(f, pd, i < len(names)-1); !ok {
+			return nil, fmt.Errorf("dymessage: field %q of %s is not a nested entity", name, pd.QualifiedName())
+		}
+	}
+	return fields, nil
+}
+
+func resolveTags(tags []uint64, pd *MessageDef) ([]*MessageFieldDef, error) {
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("dymessage: path of field tags is empty")
+	}
+	fields := make([]*MessageFieldDef, len(tags))
+	for i, tag := range tags {
+		f, ok := pd.TryGetField(tag)
+		if !ok {
+			return nil, fmt.Errorf("dymessage: unknown field with tag %d of %s", tag, pd.QualifiedName())
+		}
+		fields[i] = f
+		if pd, ok = nextDef(f, pd, i < len(tags)-1); !ok {
+			return nil, fmt.Errorf("dymessage: field with tag %d of %s is not a nested entity", tag, pd.QualifiedName())
+		}
+	}
+	return fields, nil
+}
+
+// nextDef gets the message definition of the field if the path continues past
+// the field, which then must be of an entity type.
+func nextDef(f *MessageFieldDef, pd *MessageDef, more bool) (*MessageDef, bool) {
+	if !more {
+		return pd, true
+	}
+	if !f.DataType.IsEntity() {
+		return pd, false
+	}
+	return pd.Registry.GetMessageDef(f.DataType), true
+}
@@ -0,0 +1,85 @@
+package protobuf
+
+import (
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/protobuf/internal/impl"
+)
+
+// size computes the number of bytes the specified entity takes when encoded
+// against the specified message definition. While computing, the sizes of the
+// nested entities and packed collections are recorded in the order the
+// encoder will visit them.
+func (ec *encoder) size(e *Entity, pd *MessageDef) int {
+	ec.sizes = ec.sizes[:0]
+	return ec.sizeOf(e, pd)
+}
+
+func (ec *encoder) sizeOf(e *Entity, pd *MessageDef) (n int) {
+	p := getPlan(pd)
+	for i := range p.fields {
+		fp := &p.fields[i]
+		n += fp.size(ec, e, pd, fp)
+	}
+	return
+}
+
+func (ec *encoder) sizeOfValue(e *Entity, _ *MessageDef, fp *fieldPlan) int {
+	value := fp.GetPrimitive(e)
+	return len(fp.tag) + fp.sizeValue(uint64(value))
+}
+
+func (ec *encoder) sizeOfValues(e *Entity, _ *MessageDef, fp *fieldPlan) int {
+	data := e.Entities[fp.Offset]
+	if data == nil || len(data.Data) == 0 {
+		return 0
+	}
+	m, count := 0, fp.Len(e)
+	for i := 0; i < count; i++ {
+		value := fp.GetPrimitiveAt(e, i)
+		m += fp.sizeValue(uint64(value))
+	}
+	ec.sizes = append(ec.sizes, m)
+	return len(fp.tag) + SizeRawBytes(m)
+}
+
+func (ec *encoder) sizeOfRef(e *Entity, pd *MessageDef, fp *fieldPlan) int {
+	item := e.Entities[fp.Offset]
+	if item == nil {
+		return 0
+	}
+	return fp.sizeItem(ec, item, pd, fp)
+}
+
+func (ec *encoder) sizeOfRefs(e *Entity, pd *MessageDef, fp *fieldPlan) (n int) {
+	data := e.Entities[fp.Offset]
+	if data == nil {
+		return 0
+	}
+	for _, item := range data.Entities {
+		// The null items will be reported by the encoder, so just
+		// ignoring them here.
+		if item != nil {
+			n += fp.sizeItem(ec, item, pd, fp)
+		}
+	}
+	return
+}
+
+func (ec *encoder) sizeOfBytes(item *Entity, _ *MessageDef, fp *fieldPlan) int {
+	return len(fp.tag) + SizeRawBytes(len(item.Data))
+}
+
+func (ec *encoder) sizeOfEntity(item *Entity, pd *MessageDef, fp *fieldPlan) int {
+	if data, ok := item.LazyData(); ok {
+		return len(fp.tag) + SizeRawBytes(len(data))
+	}
+	// Reserving a place for the size of nested entity before going
+	// deeper, so the sizes are recorded in the order the encoder visits
+	// the entities.
+	at := len(ec.sizes)
+	ec.sizes = append(ec.sizes, 0)
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	m := ec.sizeOf(item, def)
+	ec.sizes[at] = m
+	return len(fp.tag) + SizeRawBytes(m)
+}
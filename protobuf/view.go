@@ -0,0 +1,301 @@
+package protobuf
+
+import (
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/protobuf/internal/impl"
+)
+
+type (
+	// A read-only view of the message encoded in protocol buffers, which
+	// reads the values of the fields straight from the encoded data
+	// without decoding the entity. The data is scanned every time a field
+	// is read, unless the view has been indexed with the BuildIndex method.
+	//
+	// The values of the bytes and the nested views refer the encoded data,
+	// so the data must not be modified while the view is in use. The view
+	// is safe for concurrent reading once it has been indexed, if at all.
+	View struct {
+		data []byte
+		pd   *MessageDef
+		// The offsets of the fields in the data by the tags of the
+		// fields. Nil if the view is not indexed.
+		index map[uint64][]int
+	}
+
+	// An iterator over the items of the repeated field of the view. Call
+	// the Next method before reading each of the items, including the
+	// first one.
+	Items struct {
+		v   *View
+		fp  *fieldPlan
+		occ occurrences
+		// The remaining values of the packed collection of the
+		// primitive values, which is being read.
+		packed Buffer
+		// The current item.
+		primitive Primitive
+		raw       []byte
+		err       error
+	}
+
+	// Enumerates the occurrences of the field in the data of the view.
+	occurrences struct {
+		v   *View
+		tag uint64
+		// The position in the index of the view, or the offset in the
+		// data to scan from if the view is not indexed.
+		at int
+	}
+)
+
+// NewView creates a view of the message of the message definition encoded in
+// protocol buffers. The data is not read until the fields are accessed.
+func NewView(data []byte, pd *MessageDef) *View {
+	return &View{data: data, pd: pd}
+}
+
+// Bytes gets the encoded data of the view.
+func (v *View) Bytes() []byte { return v.data }
+
+// Def gets the message definition of the view.
+func (v *View) Def() *MessageDef { return v.pd }
+
+// BuildIndex scans the data of the view once and records the offsets of the
+// fields by their tags, so the subsequent reads of the fields don't scan the
+// data. The method returns an error if the data is malformed.
+func (v *View) BuildIndex() error {
+	index := make(map[uint64][]int)
+	for at := 0; at < len(v.data); {
+		tag, _, _, end, err := readField(v.data, at)
+		if err != nil {
+			return err
+		}
+		index[tag] = append(index[tag], at)
+		at = end
+	}
+	v.index = index
+	return nil
+}
+
+// Has gets a value indicating whether the field is present in the data.
+func (v *View) Has(f *MessageFieldDef) (bool, error) {
+	v.plan(f)
+	occ := v.occurrences(f)
+	_, _, ok, err := occ.next()
+	return ok, err
+}
+
+// GetPrimitive gets the value of the non-repeated field of a primitive type. If
+// the field is present several times, the last value is returned. If the field
+// is not present, the default value is returned.
+func (v *View) GetPrimitive(f *MessageFieldDef) (value Primitive, err error) {
+	fp := v.plan(f)
+	occ := v.occurrences(f)
+	for {
+		wire, raw, ok, err := occ.next()
+		if err != nil || !ok {
+			return value, err
+		}
+		if wire == WireBytes {
+			return value, unexpectedWire(f, wire)
+		}
+		var b Buffer
+		b.SetBuf(raw)
+		var x uint64
+		if x, err = fp.decodeValue(&b); err != nil {
+			return value, err
+		}
+		value = Primitive(x)
+	}
+}
+
+// GetBytes gets the value of the non-repeated field of the string or bytes type
+// as a slice of the encoded data. If the field is present several times, the
+// last value is returned. If the field is not present, nil is returned.
+func (v *View) GetBytes(f *MessageFieldDef) ([]byte, error) {
+	return v.last(f)
+}
+
+// GetString gets the value of the non-repeated field of the string type. See
+// GetBytes for details.
+func (v *View) GetString(f *MessageFieldDef) (string, error) {
+	raw, err := v.last(f)
+	return string(raw), err
+}
+
+// GetView gets the view of the nested entity of the non-repeated field of an
+// entity type. If the field is present several times, the view of the last
+// nested entity is returned rather than the merged one. If the field is not
+// present, nil is returned.
+func (v *View) GetView(f *MessageFieldDef) (*View, error) {
+	raw, err := v.last(f)
+	if raw == nil || err != nil {
+		return nil, err
+	}
+	return NewView(raw, v.pd.Registry.GetMessageDef(f.DataType)), nil
+}
+
+// Items gets the iterator over the items of the repeated field. Both packed and
+// unpacked primitive values are read.
+func (v *View) Items(f *MessageFieldDef) *Items {
+	return &Items{v: v, fp: v.plan(f), occ: v.occurrences(f)}
+}
+
+// Len gets the number of items of the repeated field, reading all of them.
+func (v *View) Len(f *MessageFieldDef) (n int, err error) {
+	it := v.Items(f)
+	for it.Next() {
+		n++
+	}
+	return n, it.Err()
+}
+
+// Next advances the iterator to the next item, returning false if there are no
+// more items or an error has occurred.
+func (it *Items) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		if !it.packed.Eob() {
+			var x uint64
+			if x, it.err = it.fp.decodeValue(&it.packed); it.err != nil {
+				return false
+			}
+			it.primitive = Primitive(x)
+			return true
+		}
+		wire, raw, ok, err := it.occ.next()
+		if err != nil || !ok {
+			it.err = err
+			return false
+		}
+		switch {
+		case it.fp.DataType.IsRefType() && wire != WireBytes:
+			it.err = unexpectedWire(it.fp.MessageFieldDef, wire)
+			return false
+		case it.fp.DataType.IsRefType():
+			it.raw = raw
+			return true
+		case wire == WireBytes:
+			// The items of the packed collection are read before
+			// going to the next occurrence of the field.
+			it.packed.SetBuf(raw)
+		default:
+			var b Buffer
+			b.SetBuf(raw)
+			var x uint64
+			if x, it.err = it.fp.decodeValue(&b); it.err != nil {
+				return false
+			}
+			it.primitive = Primitive(x)
+			return true
+		}
+	}
+}
+
+// Primitive gets the current item of the field of a primitive type.
+func (it *Items) Primitive() Primitive { return it.primitive }
+
+// Bytes gets the current item of the field of the string or bytes type as a
+// slice of the encoded data.
+func (it *Items) Bytes() []byte { return it.raw }
+
+// View gets the view of the current item of the field of an entity type.
+func (it *Items) View() *View {
+	return NewView(it.raw, it.v.pd.Registry.GetMessageDef(it.fp.DataType))
+}
+
+// Err gets the error, which has stopped the iteration, if any.
+func (it *Items) Err() error { return it.err }
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+// plan gets the plan of the field, which must belong to the message definition
+// of the view.
+func (v *View) plan(f *MessageFieldDef) *fieldPlan {
+	fp := getFieldPlan(v.pd, f)
+	if fp == nil {
+		panic(fmt.Sprintf("field %s doesn't belong to %s", f.Name, v.pd.QualifiedName()))
+	}
+	return fp
+}
+
+func (v *View) occurrences(f *MessageFieldDef) occurrences {
+	return occurrences{v: v, tag: f.Tag}
+}
+
+// last gets the content of the last occurrence of the field of a reference
+// type, or nil if the field is not present.
+func (v *View) last(f *MessageFieldDef) (result []byte, err error) {
+	v.plan(f)
+	occ := v.occurrences(f)
+	for {
+		wire, raw, ok, err := occ.next()
+		if err != nil || !ok {
+			return result, err
+		}
+		if wire != WireBytes {
+			return nil, unexpectedWire(f, wire)
+		}
+		result = raw
+	}
+}
+
+// next gets the wire type and the value of the next occurrence of the field.
+// For the values of the bytes wire type the length prefix is excluded.
+func (o *occurrences) next() (wire uint64, raw []byte, ok bool, err error) {
+	v := o.v
+	if v.index != nil {
+		offsets := v.index[o.tag]
+		if o.at >= len(offsets) {
+			return
+		}
+		_, wire, raw, _, err = readField(v.data, offsets[o.at])
+		o.at++
+		return wire, raw, err == nil, err
+	}
+	for o.at < len(v.data) {
+		var tag uint64
+		if tag, wire, raw, o.at, err = readField(v.data, o.at); err != nil {
+			return
+		}
+		if tag == o.tag {
+			return wire, raw, true, nil
+		}
+	}
+	return
+}
+
+// readField reads the field at the offset of the data, returning the tag and
+// the wire type of the field, its value and the offset of the next field. For
+// the values of the bytes wire type the length prefix is excluded.
+func readField(data []byte, at int) (tag, wire uint64, raw []byte, end int, err error) {
+	var b Buffer
+	b.SetBuf(data[at:])
+	var key uint64
+	if key, err = b.DecodeVarint(); err != nil {
+		return
+	}
+	tag, wire = key>>3, key&7
+	start := b.Offset()
+	if wire == WireBytes {
+		if raw, err = b.DecodeRawBytes(false); err != nil {
+			return
+		}
+	} else {
+		if err = skipWire(&b, wire); err != nil {
+			return
+		}
+		raw = data[at+start : at+b.Offset()]
+	}
+	end = at + b.Offset()
+	return
+}
+
+func unexpectedWire(f *MessageFieldDef, wire uint64) error {
+	return fmt.Errorf("dymessage: field %s has unexpected wire type %d", f.Name, wire)
+}
@@ -0,0 +1,513 @@
+package protobuf
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// A key of the well-known message definitions in the registry builder, which
+// cannot collide with the keys provided by the user.
+type wellKnownKey string
+
+// Tags of the fields of google.protobuf.Any message.
+const (
+	TagAnyTypeUrl = 1
+	TagAnyValue   = 2
+)
+
+// Tags of the fields of google.protobuf.Timestamp message.
+const (
+	TagTimestampSeconds = 1
+	TagTimestampNanos   = 2
+)
+
+// Tags of the fields of google.protobuf.Duration message.
+const (
+	TagDurationSeconds = 1
+	TagDurationNanos   = 2
+)
+
+// The tag of the value field of the wrapper messages, like
+// google.protobuf.Int32Value.
+const TagWrapperValue = 1
+
+// Tags of the fields of google.protobuf.Struct message and its entries.
+const (
+	TagStructFields     = 1
+	TagStructEntryKey   = 1
+	TagStructEntryValue = 2
+)
+
+// Tags of the fields of google.protobuf.Value message. Only one of the fields
+// is encoded, which is the field with the tag held by the field with
+// TagValueKind tag. This field is internal, so it's neither encoded nor shown
+// by the printer and the text format, and holds zero if the kind of the value
+// is not set.
+const (
+	TagValueNull   = 1
+	TagValueNumber = 2
+	TagValueString = 3
+	TagValueBool   = 4
+	TagValueStruct = 5
+	TagValueList   = 6
+
+	TagValueKind = 19000
+)
+
+// The tag of the field of google.protobuf.ListValue message.
+const TagListValues = 1
+
+// The name of the message, which represents the entries of the fields map of
+// google.protobuf.Struct message.
+const structEntryName = WellKnownStruct + ".FieldsEntry"
+
+// The names of the wrapper messages by the data types of their values.
+var wrapperNames = map[DataType]string{
+	DtFloat64: WellKnownDoubleValue,
+	DtFloat32: WellKnownFloatValue,
+	DtInt64:   WellKnownInt64Value,
+	DtUint64:  WellKnownUInt64Value,
+	DtInt32:   WellKnownInt32Value,
+	DtUint32:  WellKnownUInt32Value,
+	DtBool:    WellKnownBoolValue,
+	DtString:  WellKnownStringValue,
+	DtBytes:   WellKnownBytesValue,
+}
+
+// ForAny gets the data type of google.protobuf.Any message, adding its
+// definition to the registry if it hasn't been added yet. The message
+// definition is compatible with the well-known type in terms of the wire
+// format, and the json package encodes it as described by the proto3 JSON
+// mapping.
+func ForAny(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownAny, func(mb *MessageDefBuilder) {
+		mb.WithField("TypeUrl", TagAnyTypeUrl, DtString).
+			WithField("Value", TagAnyValue, DtBytes)
+	})
+}
+
+// ForTimestamp gets the data type of google.protobuf.Timestamp message, adding
+// its definition to the registry if it hasn't been added yet. The json package
+// encodes the timestamps as RFC 3339 strings, like "1972-01-01T10:00:20.021Z".
+func ForTimestamp(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownTimestamp, func(mb *MessageDefBuilder) {
+		mb.WithField("Seconds", TagTimestampSeconds, DtInt64).
+			ExtendField(WithVarint()).
+			WithField("Nanos", TagTimestampNanos, DtInt32).
+			ExtendField(WithVarint())
+	})
+}
+
+// ForDuration gets the data type of google.protobuf.Duration message, adding its
+// definition to the registry if it hasn't been added yet. The json package
+// encodes the durations as the number of seconds with the "s" suffix, like
+// "1.5s".
+func ForDuration(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownDuration, func(mb *MessageDefBuilder) {
+		mb.WithField("Seconds", TagDurationSeconds, DtInt64).
+			ExtendField(WithVarint()).
+			WithField("Nanos", TagDurationNanos, DtInt32).
+			ExtendField(WithVarint())
+	})
+}
+
+// ForWrapper gets the data type of the wrapper message for the values of the
+// specified data type, like google.protobuf.Int32Value for DtInt32, adding its
+// definition to the registry if it hasn't been added yet. The fields of the
+// wrapper types can be used to distinguish the absence of a value from the
+// default one. The json package encodes the wrappers as bare values. If there
+// is no wrapper for the data type, the method will panic.
+func ForWrapper(rb *RegistryBuilder, dt DataType) DataType {
+	name, ok := wrapperNames[dt]
+	if !ok {
+		panic(fmt.Sprintf("there is no wrapper for data type %d", dt))
+	}
+	return forWellKnown(rb, name, func(mb *MessageDefBuilder) {
+		mb.WithField("Value", TagWrapperValue, dt)
+		if _, ok := varintProtoTypes[dt]; ok {
+			mb.ExtendField(WithVarint())
+		}
+	})
+}
+
+// ForStruct gets the data type of google.protobuf.Struct message, adding its
+// definition to the registry along with the definitions of Value and ListValue
+// if they haven't been added yet. The fields of the struct are represented by
+// a repeated field of the entries, just like the maps are represented on the
+// wire. The json package encodes the struct as a JSON object. See the
+// StructToMap and StructFromMap functions to access the struct from Go.
+func ForStruct(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownStruct, func(mb *MessageDefBuilder) {
+		entry := forWellKnown(rb, structEntryName, func(mb *MessageDefBuilder) {
+			mb.WithField("Key", TagStructEntryKey, DtString).
+				WithField("Value", TagStructEntryValue, ForValue(rb))
+		})
+		mb.WithArrayField("Fields", TagStructFields, entry)
+	})
+}
+
+// ForValue gets the data type of google.protobuf.Value message, adding its
+// definition to the registry along with the definitions of Struct and
+// ListValue if they haven't been added yet. The json package encodes the value
+// as an arbitrary JSON value. See the ValueToInterface and ValueFromInterface
+// functions to access the value from Go.
+func ForValue(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownValue, func(mb *MessageDefBuilder) {
+		mb.WithField("NullValue", TagValueNull, DtInt32).
+			ExtendField(WithVarint()).
+			WithField("NumberValue", TagValueNumber, DtFloat64).
+			WithField("StringValue", TagValueString, DtString).
+			WithField("BoolValue", TagValueBool, DtBool).
+			WithField("StructValue", TagValueStruct, ForStruct(rb)).
+			WithField("ListValue", TagValueList, ForListValue(rb)).
+			WithField("Kind", TagValueKind, DtUint64)
+	})
+}
+
+// ForListValue gets the data type of google.protobuf.ListValue message, adding
+// its definition to the registry along with the definitions of Struct and
+// Value if they haven't been added yet. The json package encodes the list as a
+// JSON array.
+func ForListValue(rb *RegistryBuilder) DataType {
+	return forWellKnown(rb, WellKnownListValue, func(mb *MessageDefBuilder) {
+		mb.WithArrayField("Values", TagListValues, ForValue(rb))
+	})
+}
+
+// -----------------------------------------------------------------------------
+// Time accessors
+
+// ToTime converts the entity of google.protobuf.Timestamp message to the time
+// in UTC. A nil entity is converted to the Unix epoch.
+func ToTime(e *Entity, pd *MessageDef) time.Time {
+	if e == nil {
+		return time.Unix(0, 0).UTC()
+	}
+	seconds := pd.GetField(TagTimestampSeconds).GetPrimitive(e).ToInt64()
+	nanos := pd.GetField(TagTimestampNanos).GetPrimitive(e).ToInt32()
+	return time.Unix(seconds, int64(nanos)).UTC()
+}
+
+// FromTime creates an entity of google.protobuf.Timestamp message, which
+// represents the time.
+func FromTime(t time.Time, pd *MessageDef) *Entity {
+	e := pd.NewEntity()
+	pd.GetField(TagTimestampSeconds).SetPrimitive(e, FromInt64(t.Unix()))
+	pd.GetField(TagTimestampNanos).SetPrimitive(e, FromInt32(int32(t.Nanosecond())))
+	return e
+}
+
+// ToDuration converts the entity of google.protobuf.Duration message to the
+// duration. The durations, which don't fit time.Duration, are truncated. A
+// nil entity is converted to zero duration.
+func ToDuration(e *Entity, pd *MessageDef) time.Duration {
+	if e == nil {
+		return 0
+	}
+	seconds := pd.GetField(TagDurationSeconds).GetPrimitive(e).ToInt64()
+	nanos := pd.GetField(TagDurationNanos).GetPrimitive(e).ToInt32()
+	return time.Duration(seconds)*time.Second + time.Duration(nanos)
+}
+
+// FromDuration creates an entity of google.protobuf.Duration message, which
+// represents the duration.
+func FromDuration(d time.Duration, pd *MessageDef) *Entity {
+	e := pd.NewEntity()
+	pd.GetField(TagDurationSeconds).SetPrimitive(e, FromInt64(int64(d/time.Second)))
+	pd.GetField(TagDurationNanos).SetPrimitive(e, FromInt32(int32(d%time.Second)))
+	return e
+}
+
+// GetTime gets the time from the field of google.protobuf.Timestamp type. The
+// flag indicates whether the field is set.
+func GetTime(e *Entity, pd *MessageDef, tag uint64) (t time.Time, ok bool) {
+	f := pd.GetField(tag)
+	nested := f.GetReference(e).ToEntity()
+	return ToTime(nested, pd.Registry.GetMessageDef(f.DataType)), nested != nil
+}
+
+// SetTime sets the time to the field of google.protobuf.Timestamp type.
+func SetTime(e *Entity, pd *MessageDef, tag uint64, t time.Time) {
+	f := pd.GetField(tag)
+	f.SetReference(e, FromEntity(FromTime(t, pd.Registry.GetMessageDef(f.DataType))))
+}
+
+// GetDuration gets the duration from the field of google.protobuf.Duration
+// type. The flag indicates whether the field is set.
+func GetDuration(e *Entity, pd *MessageDef, tag uint64) (d time.Duration, ok bool) {
+	f := pd.GetField(tag)
+	nested := f.GetReference(e).ToEntity()
+	return ToDuration(nested, pd.Registry.GetMessageDef(f.DataType)), nested != nil
+}
+
+// SetDuration sets the duration to the field of google.protobuf.Duration type.
+func SetDuration(e *Entity, pd *MessageDef, tag uint64, d time.Duration) {
+	f := pd.GetField(tag)
+	f.SetReference(e, FromEntity(FromDuration(d, pd.Registry.GetMessageDef(f.DataType))))
+}
+
+// -----------------------------------------------------------------------------
+// Wrapper accessors
+
+// GetWrappedPrimitive gets the value from the field of a wrapper type of the
+// primitive values. The flag indicates whether the field is set.
+func GetWrappedPrimitive(e *Entity, pd *MessageDef, tag uint64) (value Primitive, ok bool) {
+	f := pd.GetField(tag)
+	nested := f.GetReference(e).ToEntity()
+	if nested == nil {
+		return
+	}
+	def := pd.Registry.GetMessageDef(f.DataType)
+	return def.GetField(TagWrapperValue).GetPrimitive(nested), true
+}
+
+// SetWrappedPrimitive sets the value to the field of a wrapper type of the
+// primitive values. The field can be cleared by setting the default reference.
+func SetWrappedPrimitive(e *Entity, pd *MessageDef, tag uint64, value Primitive) {
+	f := pd.GetField(tag)
+	def := pd.Registry.GetMessageDef(f.DataType)
+	nested := def.NewEntity()
+	def.GetField(TagWrapperValue).SetPrimitive(nested, value)
+	f.SetReference(e, FromEntity(nested))
+}
+
+// GetWrappedReference gets the value from the field of StringValue or
+// BytesValue type. The flag indicates whether the field is set.
+func GetWrappedReference(e *Entity, pd *MessageDef, tag uint64) (value Reference, ok bool) {
+	f := pd.GetField(tag)
+	nested := f.GetReference(e).ToEntity()
+	if nested == nil {
+		return
+	}
+	def := pd.Registry.GetMessageDef(f.DataType)
+	return def.GetField(TagWrapperValue).GetReference(nested), true
+}
+
+// SetWrappedReference sets the value to the field of StringValue or BytesValue
+// type. The field can be cleared by setting the default reference.
+func SetWrappedReference(e *Entity, pd *MessageDef, tag uint64, value Reference) {
+	f := pd.GetField(tag)
+	def := pd.Registry.GetMessageDef(f.DataType)
+	nested := def.NewEntity()
+	def.GetField(TagWrapperValue).SetReference(nested, value)
+	f.SetReference(e, FromEntity(nested))
+}
+
+// -----------------------------------------------------------------------------
+// Struct accessors
+
+// StructToMap converts the entity of google.protobuf.Struct message to a map.
+// The values of the map are converted by the ValueToInterface function.
+func StructToMap(e *Entity, pd *MessageDef) map[string]interface{} {
+	f := pd.GetField(TagStructFields)
+	entryDef := pd.Registry.GetMessageDef(f.DataType)
+	keyField := entryDef.GetField(TagStructEntryKey)
+	valueField := entryDef.GetField(TagStructEntryValue)
+	valueDef := pd.Registry.GetMessageDef(valueField.DataType)
+	n := f.Len(e)
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		entry := f.GetReferenceAt(e, i).ToEntity()
+		if entry == nil {
+			continue
+		}
+		value := valueField.GetReference(entry).ToEntity()
+		m[keyField.GetReference(entry).ToString()] = ValueToInterface(value, valueDef)
+	}
+	return m
+}
+
+// StructFromMap creates an entity of google.protobuf.Struct message from the
+// map. The values of the map are converted by the ValueFromInterface function,
+// and the fields of the struct are sorted by their names.
+func StructFromMap(m map[string]interface{}, pd *MessageDef) (*Entity, error) {
+	f := pd.GetField(TagStructFields)
+	entryDef := pd.Registry.GetMessageDef(f.DataType)
+	keyField := entryDef.GetField(TagStructEntryKey)
+	valueField := entryDef.GetField(TagStructEntryValue)
+	valueDef := pd.Registry.GetMessageDef(valueField.DataType)
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	e := pd.NewEntity()
+	f.Reserve(e, len(keys))
+	for i, key := range keys {
+		value, err := ValueFromInterface(m[key], valueDef)
+		if err != nil {
+			return nil, err
+		}
+		entry := entryDef.NewEntity()
+		keyField.SetReference(entry, FromString(key))
+		valueField.SetReference(entry, FromEntity(value))
+		f.SetReferenceAt(e, i, FromEntity(entry))
+	}
+	return e, nil
+}
+
+// ValueToInterface converts the entity of google.protobuf.Value message to a Go
+// value, which is either nil, float64, string, bool, map[string]interface{} or
+// []interface{}, just like the encoding/json package does. A nil entity and
+// the entity, which kind is not set, are converted to nil.
+func ValueToInterface(e *Entity, pd *MessageDef) interface{} {
+	if e == nil {
+		return nil
+	}
+	kind := pd.GetField(TagValueKind).GetPrimitive(e).ToUint64()
+	switch kind {
+	case TagValueNumber, TagValueBool:
+		value := pd.GetField(kind).GetPrimitive(e)
+		if kind == TagValueBool {
+			return value.ToBool()
+		}
+		return value.ToFloat64()
+	case TagValueString:
+		return pd.GetField(kind).GetReference(e).ToString()
+	case TagValueStruct:
+		f := pd.GetField(kind)
+		return StructToMap(f.GetReference(e).ToEntity(), pd.Registry.GetMessageDef(f.DataType))
+	case TagValueList:
+		f := pd.GetField(kind)
+		list := f.GetReference(e).ToEntity()
+		listDef := pd.Registry.GetMessageDef(f.DataType)
+		values := listDef.GetField(TagListValues)
+		n := values.Len(list)
+		s := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			s[i] = ValueToInterface(values.GetReferenceAt(list, i).ToEntity(), pd)
+		}
+		return s
+	default:
+		return nil
+	}
+}
+
+// ValueFromInterface creates an entity of google.protobuf.Value message from a
+// Go value. The value may be nil, a number of any of the Go numeric types or
+// json.Number, a string, a bool, map[string]interface{} or []interface{}. The
+// maps and slices are converted recursively.
+func ValueFromInterface(v interface{}, pd *MessageDef) (*Entity, error) {
+	e := pd.NewEntity()
+	kind := uint64(TagValueNumber)
+	var number float64
+	switch v := v.(type) {
+	case nil:
+		kind = TagValueNull
+	case bool:
+		kind = TagValueBool
+		pd.GetField(kind).SetPrimitive(e, FromBool(v))
+	case string:
+		kind = TagValueString
+		pd.GetField(kind).SetReference(e, FromString(v))
+	case map[string]interface{}:
+		kind = TagValueStruct
+		f := pd.GetField(kind)
+		nested, err := StructFromMap(v, pd.Registry.GetMessageDef(f.DataType))
+		if err != nil {
+			return nil, err
+		}
+		f.SetReference(e, FromEntity(nested))
+	case []interface{}:
+		kind = TagValueList
+		f := pd.GetField(kind)
+		listDef := pd.Registry.GetMessageDef(f.DataType)
+		values := listDef.GetField(TagListValues)
+		list := listDef.NewEntity()
+		values.Reserve(list, len(v))
+		for i, item := range v {
+			nested, err := ValueFromInterface(item, pd)
+			if err != nil {
+				return nil, err
+			}
+			values.SetReferenceAt(list, i, FromEntity(nested))
+		}
+		f.SetReference(e, FromEntity(list))
+	case float64:
+		number = v
+	case float32:
+		number = float64(v)
+	case int:
+		number = float64(v)
+	case int8:
+		number = float64(v)
+	case int16:
+		number = float64(v)
+	case int32:
+		number = float64(v)
+	case int64:
+		number = float64(v)
+	case uint:
+		number = float64(v)
+	case uint8:
+		number = float64(v)
+	case uint16:
+		number = float64(v)
+	case uint32:
+		number = float64(v)
+	case uint64:
+		number = float64(v)
+	case json.Number:
+		var err error
+		if number, err = v.Float64(); err != nil {
+			return nil, fmt.Errorf("dymessage: cannot use value %v as %s", v, pd.QualifiedName())
+		}
+	default:
+		return nil, fmt.Errorf("dymessage: cannot use value %v of type %T as %s", v, v, pd.QualifiedName())
+	}
+	if kind == TagValueNumber {
+		pd.GetField(kind).SetPrimitive(e, FromFloat64(number))
+	}
+	pd.GetField(TagValueKind).SetPrimitive(e, FromUint64(kind))
+	return e, nil
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+// compileOneof makes the fields of the plan the members of a oneof, so only
+// the field, which tag is held by the kind field, is encoded. The kind field
+// itself is not encoded.
+func compileOneof(p *plan, kind *MessageFieldDef) {
+	for i := range p.fields {
+		fp := &p.fields[i]
+		if fp.MessageFieldDef == kind {
+			fp.encode = func(*encoder, *Entity, *MessageDef, *fieldPlan) error { return nil }
+			fp.size = func(*encoder, *Entity, *MessageDef, *fieldPlan) int { return 0 }
+			continue
+		}
+		fp.oneof = kind
+		encode, size := fp.encode, fp.size
+		fp.encode = func(ec *encoder, e *Entity, pd *MessageDef, fp *fieldPlan) error {
+			if kind.GetPrimitive(e).ToUint64() != fp.Tag {
+				return nil
+			}
+			return encode(ec, e, pd, fp)
+		}
+		fp.size = func(ec *encoder, e *Entity, pd *MessageDef, fp *fieldPlan) int {
+			if kind.GetPrimitive(e).ToUint64() != fp.Tag {
+				return 0
+			}
+			return size(ec, e, pd, fp)
+		}
+	}
+}
+
+// forWellKnown gets the data type of the well-known message, building its
+// definition with the provided function if the registry doesn't contain it.
+func forWellKnown(rb *RegistryBuilder, name string, build func(*MessageDefBuilder)) DataType {
+	key := wellKnownKey(name)
+	if mb, ok := rb.TryGetMessageDef(key); ok {
+		return mb.GetDataType()
+	}
+	mb := rb.ForMessageDef(key).
+		WithNamespace(WellKnownNamespace).
+		WithName(name)
+	build(mb)
+	mb.Build()
+	return mb.GetDataType()
+}
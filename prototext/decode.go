@@ -0,0 +1,241 @@
+package prototext
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/prototext/internal/impl"
+)
+
+type decoder struct {
+	lx impl.Lexer
+	// The options of decoding and the nesting depth of the entity
+	// currently being decoded.
+	opts  decodeOptions
+	depth int
+}
+
+// DecodeNew transforms the protocol buffers text format representation of the
+// message to dynamic entity against the provided message definition. The
+// fields may be separated by commas or semicolons, and the colon after the
+// name of the field is optional for the nested entities, which can be
+// enclosed either in curly or in angle brackets. The repeated fields can be
+// represented as multiple entries with the same name or as a list of values in
+// square brackets.
+func DecodeNew(b []byte, pd *MessageDef, opts ...DecodeOption) (e *Entity, err error) {
+	dc := decoder{opts: newDecodeOptions(opts)}
+	if err = dc.opts.limits.CheckBytes(len(b)); err != nil {
+		return
+	}
+	dc.lx.Reset(b)
+	dc.lx.Next()
+	return dc.decode(pd, impl.TkEof)
+}
+
+// decode decodes the fields of the entity until the token of specified kind,
+// which is not accepted by the method.
+func (dc *decoder) decode(pd *MessageDef, end impl.TokenKind) (r *Entity, err error) {
+	if err = dc.enter(); err != nil {
+		return
+	}
+	r = pd.NewEntity()
+	for !dc.probably(end) {
+		if err = dc.decodeField(r, pd); err != nil {
+			return
+		}
+		dc.tryAcceptAny(impl.TkComma, impl.TkSemicolon)
+	}
+	dc.leave()
+	return
+}
+
+func (dc *decoder) decodeField(r *Entity, pd *MessageDef) (err error) {
+	if err = dc.expect(impl.TkIdent); err != nil {
+		return
+	}
+	p := getPlan(pd)
+	i, ok := p.byName[dc.lx.Tok.Value]
+	if !ok {
+		return fmt.Errorf("dymessage: %v: unknown field %q",
+			dc.lx.Tok.Pos, dc.lx.Tok.Value)
+	}
+	dc.lx.Next()
+	fp := &p.fields[i]
+	if !dc.tryAccept(impl.TkColon) && !fp.DataType.IsEntity() {
+		return errors.New(dc.createErrorMessage(impl.TkColon))
+	}
+	switch {
+	case fp.Repeated && dc.tryAccept(impl.TkSqBrOpen):
+		err = dc.decodeList(r, pd, fp)
+	case fp.Repeated:
+		err = dc.decodeItem(r, pd, fp)
+	default:
+		err = dc.decodeSingle(r, pd, fp)
+	}
+	if err == nil && fp.oneof != nil {
+		fp.oneof.SetPrimitive(r, FromUint64(fp.Tag))
+	}
+	return
+}
+
+func (dc *decoder) decodeSingle(
+	r *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	if fp.DataType.IsRefType() {
+		var ref Reference
+		if ref, err = fp.decodeItem(dc, pd, fp); err != nil {
+			return
+		}
+		fp.SetReference(r, ref)
+	} else {
+		var p Primitive
+		if p, err = fp.decodeValue(dc, fp); err != nil {
+			return
+		}
+		fp.SetPrimitive(r, p)
+	}
+	return
+}
+
+// decodeList decodes the items of the repeated field enclosed in square
+// brackets. The opening bracket must be accepted before calling the method.
+func (dc *decoder) decodeList(
+	r *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	if dc.tryAccept(impl.TkSqBrClose) {
+		return
+	}
+	for {
+		if err = dc.decodeItem(r, pd, fp); err != nil {
+			return
+		}
+		if !dc.tryAccept(impl.TkComma) {
+			break
+		}
+	}
+	err = dc.accept(impl.TkSqBrClose)
+	return
+}
+
+// decodeItem decodes a single item and appends it to the repeated field.
+func (dc *decoder) decodeItem(
+	r *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	if err = dc.opts.limits.CheckRepeated(fp.MessageFieldDef, fp.Len(r)+1); err != nil {
+		return
+	}
+	if fp.DataType.IsRefType() {
+		var ref Reference
+		if ref, err = fp.decodeItem(dc, pd, fp); err != nil {
+			return
+		}
+		n := fp.Reserve(r, 1)
+		fp.SetReferenceAt(r, n, ref)
+	} else {
+		var p Primitive
+		if p, err = fp.decodeValue(dc, fp); err != nil {
+			return
+		}
+		n := fp.Reserve(r, 1)
+		fp.SetPrimitiveAt(r, n, p)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Item decoders
+
+func (dc *decoder) decodeString(_ *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	var str string
+	if str, err = dc.acceptString(); err != nil {
+		return
+	}
+	if err = dc.opts.limits.CheckString(fp.MessageFieldDef, len(str)); err == nil {
+		ref = FromString(str)
+	}
+	return
+}
+
+func (dc *decoder) decodeBytes(_ *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	var str string
+	if str, err = dc.acceptString(); err != nil {
+		return
+	}
+	if err = dc.opts.limits.CheckString(fp.MessageFieldDef, len(str)); err == nil {
+		ref = FromBytes([]byte(str), false)
+	}
+	return
+}
+
+func (dc *decoder) decodeEntity(pd *MessageDef, fp *fieldPlan) (ref Reference, err error) {
+	var end impl.TokenKind
+	switch {
+	case dc.tryAccept(impl.TkCrBrOpen):
+		end = impl.TkCrBrClose
+	case dc.tryAccept(impl.TkAnBrOpen):
+		end = impl.TkAnBrClose
+	default:
+		if err = dc.lx.Err; err == nil {
+			err = errors.New(dc.createErrorMessage(impl.TkCrBrOpen, impl.TkAnBrOpen))
+		}
+		return
+	}
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	var nested *Entity
+	if nested, err = dc.decode(def, end); err != nil {
+		return
+	}
+	if err = dc.accept(end); err == nil {
+		ref = FromEntity(nested)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Value decoders
+
+func (dc *decoder) decodeInt32(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptInt(32)
+	return FromInt32(int32(value)), err
+}
+
+func (dc *decoder) decodeInt64(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptInt(64)
+	return FromInt64(value), err
+}
+
+func (dc *decoder) decodeUint32(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptUint(32)
+	return FromUint32(uint32(value)), err
+}
+
+func (dc *decoder) decodeUint64(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptUint(64)
+	return FromUint64(value), err
+}
+
+func (dc *decoder) decodeFloat32(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptFloat(32)
+	return FromFloat32(float32(value)), err
+}
+
+func (dc *decoder) decodeFloat64(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptFloat(64)
+	return FromFloat64(value), err
+}
+
+func (dc *decoder) decodeBool(*fieldPlan) (Primitive, error) {
+	value, err := dc.acceptBool()
+	return FromBool(value), err
+}
+
+// -----------------------------------------------------------------------------
+// Nesting depth
+
+// enter increases the nesting depth before decoding an entity, and checks
+// whether the depth doesn't exceed the limit.
+func (dc *decoder) enter() error {
+	dc.depth++
+	return dc.opts.limits.CheckDepth(dc.depth)
+}
+
+// leave decreases the nesting depth after an entity has been decoded.
+func (dc *decoder) leave() { dc.depth-- }
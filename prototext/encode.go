@@ -0,0 +1,218 @@
+package prototext
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/helpers"
+)
+
+type encoder struct {
+	buf    []byte
+	indent int // The nesting level of the entity being encoded
+}
+
+var errNullItem = errors.New("dymessage: repeated field has null item")
+
+// Encode transforms the data from the dynamic entity to a buffer, containing
+// the protocol buffers text format. Each field is written on its own line, and
+// the repeated fields are written as multiple entries with the same name. The
+// fields with null references are omitted. If the entity type doesn't
+// correspond the data type of the message definition, the method will panic.
+func Encode(e *Entity, pd *MessageDef) ([]byte, error) {
+	helpers.DataTypesMustMatch(e, pd)
+	var ec encoder
+	if err := ec.encode(e, pd); err != nil {
+		return nil, err
+	}
+	return ec.buf, nil
+}
+
+func (ec *encoder) encode(e *Entity, pd *MessageDef) (err error) {
+	p := getPlan(pd)
+	for i := range p.fields {
+		fp := &p.fields[i]
+		if fp.IsInternal() || (fp.oneof != nil && fp.oneof.GetPrimitive(e).ToUint64() != fp.Tag) {
+			continue
+		}
+		switch {
+		case fp.Repeated && fp.DataType.IsRefType():
+			err = ec.encodeRefs(e, pd, fp)
+		case fp.Repeated:
+			n := fp.Len(e)
+			for i := 0; i < n; i++ {
+				ec.encodeName(fp, true)
+				fp.encodeValue(ec, fp.GetPrimitiveAt(e, i))
+				ec.buf = append(ec.buf, '\n')
+			}
+		case fp.DataType.IsRefType():
+			if item := e.Entities[fp.Offset]; item != nil {
+				if err = item.Load(); err == nil {
+					err = fp.encodeItem(ec, item, pd, fp)
+				}
+			}
+		default:
+			ec.encodeName(fp, true)
+			fp.encodeValue(ec, fp.GetPrimitive(e))
+			ec.buf = append(ec.buf, '\n')
+		}
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (ec *encoder) encodeRefs(e *Entity, pd *MessageDef, fp *fieldPlan) error {
+	data := e.Entities[fp.Offset]
+	if data == nil {
+		return nil
+	}
+	for _, item := range data.Entities {
+		if item == nil {
+			return errNullItem
+		}
+		if err := item.Load(); err != nil {
+			return err
+		}
+		if err := fp.encodeItem(ec, item, pd, fp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeName writes the indentation followed by the name of the field. The
+// colon parameter indicates whether the name must be followed by a colon,
+// which is the case for all fields except the nested entities.
+func (ec *encoder) encodeName(fp *fieldPlan, colon bool) {
+	for i := 0; i < ec.indent; i++ {
+		ec.buf = append(ec.buf, ' ', ' ')
+	}
+	ec.buf = append(ec.buf, fp.Name...)
+	if colon {
+		ec.buf = append(ec.buf, ':', ' ')
+	} else {
+		ec.buf = append(ec.buf, ' ')
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Item encoders
+
+func (ec *encoder) encodeString(item *Entity, _ *MessageDef, fp *fieldPlan) error {
+	ec.encodeName(fp, true)
+	ec.buf = appendQuoted(ec.buf, item.Data, true)
+	ec.buf = append(ec.buf, '\n')
+	return nil
+}
+
+func (ec *encoder) encodeBytes(item *Entity, _ *MessageDef, fp *fieldPlan) error {
+	ec.encodeName(fp, true)
+	ec.buf = appendQuoted(ec.buf, item.Data, false)
+	ec.buf = append(ec.buf, '\n')
+	return nil
+}
+
+func (ec *encoder) encodeEntity(item *Entity, pd *MessageDef, fp *fieldPlan) (err error) {
+	def := pd.Registry.GetMessageDef(fp.DataType)
+	ec.encodeName(fp, false)
+	ec.buf = append(ec.buf, '{', '\n')
+	ec.indent++
+	if err = ec.encode(item, def); err != nil {
+		return
+	}
+	ec.indent--
+	for i := 0; i < ec.indent; i++ {
+		ec.buf = append(ec.buf, ' ', ' ')
+	}
+	ec.buf = append(ec.buf, '}', '\n')
+	return
+}
+
+// -----------------------------------------------------------------------------
+// Value encoders
+
+func (ec *encoder) encodeInt32(value Primitive) {
+	ec.buf = strconv.AppendInt(ec.buf, int64(value.ToInt32()), 10)
+}
+
+func (ec *encoder) encodeInt64(value Primitive) {
+	ec.buf = strconv.AppendInt(ec.buf, value.ToInt64(), 10)
+}
+
+func (ec *encoder) encodeUint32(value Primitive) {
+	ec.buf = strconv.AppendUint(ec.buf, uint64(value.ToUint32()), 10)
+}
+
+func (ec *encoder) encodeUint64(value Primitive) {
+	ec.buf = strconv.AppendUint(ec.buf, value.ToUint64(), 10)
+}
+
+func (ec *encoder) encodeFloat32(value Primitive) {
+	ec.buf = appendFloat(ec.buf, float64(value.ToFloat32()), 32)
+}
+
+func (ec *encoder) encodeFloat64(value Primitive) {
+	ec.buf = appendFloat(ec.buf, value.ToFloat64(), 64)
+}
+
+func (ec *encoder) encodeBool(value Primitive) {
+	ec.buf = strconv.AppendBool(ec.buf, value.ToBool())
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func appendFloat(buf []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(buf, "inf"...)
+	case math.IsInf(f, -1):
+		return append(buf, "-inf"...)
+	case math.IsNaN(f):
+		return append(buf, "nan"...)
+	default:
+		return strconv.AppendFloat(buf, f, 'g', -1, bits)
+	}
+}
+
+// appendQuoted appends the data to the buffer as a quoted string. The utf
+// parameter indicates whether the printable UTF-8 characters can be written
+// as is. Otherwise all non-ASCII bytes are written as octal escapes.
+func appendQuoted(buf []byte, data []byte, utf bool) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch b {
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '"', '\\':
+			buf = append(buf, '\\', b)
+		default:
+			if utf && b >= utf8.RuneSelf {
+				r, size := utf8.DecodeRune(data[i:])
+				if size > 1 && unicode.IsPrint(r) {
+					buf = append(buf, data[i:i+size]...)
+					i += size
+					continue
+				}
+			}
+			if b < 0x20 || b >= 0x7f {
+				buf = append(buf, '\\', '0'+(b>>6), '0'+((b>>3)&7), '0'+(b&7))
+			} else {
+				buf = append(buf, b)
+			}
+		}
+		i++
+	}
+	return append(buf, '"')
+}
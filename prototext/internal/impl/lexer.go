@@ -0,0 +1,280 @@
+package impl
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+type Lexer struct {
+	reader reader
+	Err    error // Optional error occurred during the parse
+	// Represents a token that has been read the last time
+	Tok struct {
+		Kind  TokenKind
+		Pos   Pos    // A zero-based line and column indexes of the token
+		Value string // Optional value of the token
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Lexer implementation
+
+// Reset prepares the lexer for new deserialization by assigning it with a
+// buffer that contains the input text.
+func (lex *Lexer) Reset(buf []byte) { lex.reader.reset(buf) }
+
+// Eof gets a value indicating whether an end of file has been reached.
+func (lex *Lexer) Eof() bool { return lex.Tok.Kind == TkEof }
+
+func (lex *Lexer) Next() {
+	var err error
+	cur := lex.skipSpace()
+	lex.Tok.Pos = lex.reader.pos
+	lex.Tok.Value = ""
+	switch {
+	case cur == eof:
+		lex.Tok.Kind = TkEof
+	case cur == '{':
+		lex.acceptTok(TkCrBrOpen)
+	case cur == '}':
+		lex.acceptTok(TkCrBrClose)
+	case cur == '<':
+		lex.acceptTok(TkAnBrOpen)
+	case cur == '>':
+		lex.acceptTok(TkAnBrClose)
+	case cur == '[':
+		lex.acceptTok(TkSqBrOpen)
+	case cur == ']':
+		lex.acceptTok(TkSqBrClose)
+	case cur == ':':
+		lex.acceptTok(TkColon)
+	case cur == ',':
+		lex.acceptTok(TkComma)
+	case cur == ';':
+		lex.acceptTok(TkSemicolon)
+	case cur == '"' || cur == '\'':
+		err = lex.parseString(cur)
+	case cur == '-':
+		err = lex.parseSigned()
+	case isDecDigit(cur) || cur == '.':
+		lex.parseNumber(nil)
+	case isIdentStart(cur):
+		lex.parseIdent(nil)
+	default:
+		err = fmt.Errorf("unexpected character '%c'", cur)
+	}
+	if err != nil {
+		// Annotating the error with the position, where the lexer
+		// has stopped reading the input.
+		err = fmt.Errorf("dymessage: %v: %v", lex.reader.pos, err)
+	}
+	lex.Err = err
+}
+
+// skipSpace skips the whitespaces and comments and returns the first character
+// after them.
+func (lex *Lexer) skipSpace() rune {
+	for {
+		switch cur := lex.reader.peek(); cur {
+		case ws, tab, vt, ff, nl:
+			lex.reader.accept()
+		case '#':
+			for cur != nl && cur != eof {
+				lex.reader.accept()
+				cur = lex.reader.peek()
+			}
+		default:
+			return cur
+		}
+	}
+}
+
+func (lex *Lexer) acceptTok(tk TokenKind) {
+	lex.Tok.Kind = tk
+	lex.reader.accept()
+}
+
+// parseSigned parses either a negative number or a negative identifier, like
+// -inf, which starts with the minus sign.
+func (lex *Lexer) parseSigned() error {
+	lex.reader.accept()
+	buf := []byte{'-'}
+	switch r := lex.reader.peek(); {
+	case isDecDigit(r) || r == '.':
+		lex.parseNumber(buf)
+	case isIdentStart(r):
+		lex.parseIdent(buf)
+	default:
+		return fmt.Errorf("unexpected character '%c' after a minus sign", r)
+	}
+	return nil
+}
+
+// parseNumber reads the characters the number may consist of. The number is
+// not validated here, since its format depends on the type of the field, so
+// the decoder is responsible for reporting the invalid numbers.
+func (lex *Lexer) parseNumber(buf []byte) {
+	var prev rune
+	for {
+		r := lex.reader.peek()
+		if !isIdentPart(r) && r != '.' &&
+			!((r == '-' || r == '+') && (prev == 'e' || prev == 'E') && !isHex(buf)) {
+			break
+		}
+		buf = append(buf, string(r)...)
+		lex.reader.accept()
+		prev = r
+	}
+	lex.Tok.Kind = TkNumber
+	lex.Tok.Value = string(buf)
+}
+
+func (lex *Lexer) parseIdent(buf []byte) {
+	for r := lex.reader.peek(); isIdentPart(r); r = lex.reader.peek() {
+		buf = append(buf, byte(r))
+		lex.reader.accept()
+	}
+	lex.Tok.Kind = TkIdent
+	lex.Tok.Value = string(buf)
+}
+
+func (lex *Lexer) parseString(quote rune) (err error) {
+	var buf []byte
+	lex.reader.accept()
+	for {
+		r := lex.reader.peek()
+		switch r {
+		case eof:
+			return io.ErrUnexpectedEOF
+		case nl:
+			return fmt.Errorf("unexpected newline in a string")
+		case quote:
+			lex.reader.accept()
+			lex.Tok.Kind = TkString
+			lex.Tok.Value = string(buf)
+			return nil
+		case '\\':
+			lex.reader.accept()
+			if buf, err = lex.parseEscape(buf); err != nil {
+				return
+			}
+		default:
+			lex.reader.accept()
+			var rb [utf8.UTFMax]byte
+			n := utf8.EncodeRune(rb[:], r)
+			buf = append(buf, rb[:n]...)
+		}
+	}
+}
+
+func (lex *Lexer) parseEscape(buf []byte) ([]byte, error) {
+	r := lex.reader.peek()
+	if r == eof {
+		return buf, io.ErrUnexpectedEOF
+	}
+	lex.reader.accept()
+	switch r {
+	case 'a':
+		return append(buf, '\a'), nil
+	case 'b':
+		return append(buf, '\b'), nil
+	case 'f':
+		return append(buf, '\f'), nil
+	case 'n':
+		return append(buf, '\n'), nil
+	case 'r':
+		return append(buf, '\r'), nil
+	case 't':
+		return append(buf, '\t'), nil
+	case 'v':
+		return append(buf, '\v'), nil
+	case '\\', '\'', '"', '?':
+		return append(buf, byte(r)), nil
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		// Up to three octal digits, the first of which has been
+		// accepted already.
+		digits := []rune{r}
+		for len(digits) < 3 && isOctDigit(lex.reader.peek()) {
+			digits = append(digits, lex.reader.peek())
+			lex.reader.accept()
+		}
+		n, err := strconv.ParseUint(string(digits), 8, 8)
+		if err != nil {
+			return buf, fmt.Errorf("bad octal escape '\\%s'", string(digits))
+		}
+		return append(buf, byte(n)), nil
+	case 'x', 'X':
+		digits, err := lex.acceptHex(1, 2)
+		if err != nil {
+			return buf, err
+		}
+		n, _ := strconv.ParseUint(digits, 16, 8)
+		return append(buf, byte(n)), nil
+	case 'u', 'U':
+		count := 4
+		if r == 'U' {
+			count = 8
+		}
+		digits, err := lex.acceptHex(count, count)
+		if err != nil {
+			return buf, err
+		}
+		n, _ := strconv.ParseUint(digits, 16, 32)
+		if !utf8.ValidRune(rune(n)) {
+			return buf, fmt.Errorf("bad unicode escape '\\%c%s'", r, digits)
+		}
+		var rb [utf8.UTFMax]byte
+		m := utf8.EncodeRune(rb[:], rune(n))
+		return append(buf, rb[:m]...), nil
+	default:
+		return buf, fmt.Errorf("bad escape character '%c'", r)
+	}
+}
+
+// acceptHex accepts from min to max hex digits and returns them.
+func (lex *Lexer) acceptHex(min, max int) (string, error) {
+	var digits []rune
+	for len(digits) < max && isHexDigit(lex.reader.peek()) {
+		digits = append(digits, lex.reader.peek())
+		lex.reader.accept()
+	}
+	if len(digits) < min {
+		r := lex.reader.peek()
+		if r == eof {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", fmt.Errorf("'%c' is not a valid hex digit", r)
+	}
+	return string(digits), nil
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func isDecDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+func isOctDigit(r rune) bool { return r >= '0' && r <= '7' }
+
+func isHexDigit(r rune) bool {
+	return (r >= '0' && r <= '9') ||
+		(r >= 'a' && r <= 'f') ||
+		(r >= 'A' && r <= 'F')
+}
+
+func isIdentStart(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+}
+
+func isIdentPart(r rune) bool { return isIdentStart(r) || isDecDigit(r) }
+
+// isHex gets a value indicating whether the number being parsed is written in
+// the hexadecimal notation, where the letter 'e' is a digit rather than the
+// exponent.
+func isHex(buf []byte) bool {
+	if len(buf) > 0 && buf[0] == '-' {
+		buf = buf[1:]
+	}
+	return len(buf) > 1 && buf[0] == '0' && (buf[1] == 'x' || buf[1] == 'X')
+}
@@ -0,0 +1,61 @@
+package impl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`a: 1`, `a : 1`},
+		{`a: -1.5e-3f, b: 0x1F`, `a : -1.5e-3f , b : 0x1F`},
+		{`a: -inf; b: nan`, `a : -inf ; b : nan`},
+		{"a { b: 'x' } # comment\nc < >", `a { b : "x" } c < >`},
+		{`a: [1, 2]`, `a : [ 1 , 2 ]`},
+		{`a: "\a\b\f\n\r\t\v\\\'\"\?"`, `a : "\a\b\f\n\r\t\v\\'\"?"`},
+		{`a: "\101\x42C\U00000044\0"`, `a : "ABCD\x00"`},
+		{`a: "тест"`, `a : "тест"`},
+		{`a: "abc`, `a : ERROR: dymessage: (1:8): unexpected EOF`},
+		{"a: \"abc\n\"", `a : ERROR: dymessage: (2:1): unexpected newline in a string`},
+		{`a: "\q"`, `a : ERROR: dymessage: (1:7): bad escape character 'q'`},
+		{`a: "\xZ"`, `a : ERROR: dymessage: (1:7): 'Z' is not a valid hex digit`},
+		{`a: -"x"`, `a : ERROR: dymessage: (1:5): unexpected character '"' after a minus sign`},
+		{`a: @`, `a : ERROR: dymessage: (1:4): unexpected character '@'`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			assert.Equal(t, test.expected, createLexerOutput(test.input))
+		})
+	}
+}
+
+func createLexerOutput(input string) string {
+	var lex Lexer
+	var out []string
+	lex.Reset([]byte(input))
+	for {
+		lex.Next()
+		if lex.Err != nil {
+			out = append(out, "ERROR: "+lex.Err.Error())
+			break
+		}
+		if lex.Eof() {
+			break
+		}
+		switch lex.Tok.Kind {
+		case TkString:
+			out = append(out, fmt.Sprintf("%q", lex.Tok.Value))
+		case TkIdent, TkNumber:
+			out = append(out, lex.Tok.Value)
+		default:
+			out = append(out, lex.Tok.Kind.String())
+		}
+	}
+	return strings.Join(out, " ")
+}
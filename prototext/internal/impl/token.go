@@ -0,0 +1,75 @@
+package impl
+
+import "fmt"
+
+type (
+	TokenKind int
+
+	// Represents a zero-based position of the token in the input.
+	Pos struct{ line, col int }
+)
+
+const (
+	TkEof TokenKind = iota
+	TkIdent
+	TkString
+	TkNumber
+	TkCrBrOpen
+	TkCrBrClose
+	TkAnBrOpen
+	TkAnBrClose
+	TkSqBrOpen
+	TkSqBrClose
+	TkColon
+	TkComma
+	TkSemicolon
+)
+
+// MessageString gets a representation of the token kind based on whether it
+// corresponds to a known character sequence or must correspond an arbitrary
+// value.
+func (tk TokenKind) MessageString() string {
+	switch tk {
+	case TkEof, TkIdent, TkString, TkNumber:
+		return tk.String()
+	default:
+		return fmt.Sprintf("%q", tk)
+	}
+}
+
+func (tk TokenKind) String() string {
+	switch tk {
+	case TkEof:
+		return "EOF"
+	case TkIdent:
+		return "identifier"
+	case TkString:
+		return "string"
+	case TkNumber:
+		return "number"
+	case TkCrBrOpen:
+		return "{"
+	case TkCrBrClose:
+		return "}"
+	case TkAnBrOpen:
+		return "<"
+	case TkAnBrClose:
+		return ">"
+	case TkSqBrOpen:
+		return "["
+	case TkSqBrClose:
+		return "]"
+	case TkColon:
+		return ":"
+	case TkComma:
+		return ","
+	case TkSemicolon:
+		return ";"
+	default:
+		panic(fmt.Sprintf("dymessage: invalid token kind %d", tk))
+	}
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("(%d:%d)", pos.line+1, pos.col+1)
+}
@@ -0,0 +1,217 @@
+package prototext
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/umk/go-dymessage/prototext/internal/impl"
+)
+
+// -----------------------------------------------------------------------------
+// Token query methods
+
+func (dc *decoder) accept(tk impl.TokenKind) error {
+	if dc.lx.Err != nil {
+		return dc.lx.Err
+	} else if dc.lx.Tok.Kind != tk {
+		return errors.New(dc.createErrorMessage(tk))
+	}
+	dc.lx.Next()
+	return nil
+}
+
+func (dc *decoder) tryAccept(tk impl.TokenKind) (accepted bool) {
+	accepted = dc.lx.Err == nil && dc.lx.Tok.Kind == tk
+	if accepted {
+		dc.lx.Next()
+	}
+	return
+}
+
+func (dc *decoder) tryAcceptAny(tk ...impl.TokenKind) (accepted bool) {
+	if dc.lx.Err != nil {
+		return false
+	}
+	for _, t := range tk {
+		if t == dc.lx.Tok.Kind {
+			dc.lx.Next()
+			return true
+		}
+	}
+	return false
+}
+
+func (dc *decoder) acceptValue(tk impl.TokenKind) (str string, err error) {
+	if err = dc.lx.Err; err != nil {
+		return
+	} else if dc.lx.Tok.Kind != tk {
+		return "", errors.New(dc.createErrorMessage(tk))
+	}
+	// The value must be taken before the lexer moves to the next token,
+	// which overwrites it.
+	str = dc.lx.Tok.Value
+	dc.lx.Next()
+	return
+}
+
+// acceptString accepts one or more adjacent strings and concatenates them,
+// just like the text format requires.
+func (dc *decoder) acceptString() (str string, err error) {
+	if str, err = dc.acceptValue(impl.TkString); err != nil {
+		return
+	}
+	if !dc.probably(impl.TkString) {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(str)
+	for dc.probably(impl.TkString) {
+		sb.WriteString(dc.lx.Tok.Value)
+		dc.lx.Next()
+	}
+	return sb.String(), nil
+}
+
+func (dc *decoder) acceptBool() (b bool, err error) {
+	if err = dc.lx.Err; err != nil {
+		return
+	}
+	switch tk := dc.lx.Tok; {
+	case tk.Kind == impl.TkIdent && (tk.Value == "true" || tk.Value == "True" || tk.Value == "t"):
+		b = true
+	case tk.Kind == impl.TkIdent && (tk.Value == "false" || tk.Value == "False" || tk.Value == "f"):
+		b = false
+	case tk.Kind == impl.TkNumber && (tk.Value == "1" || tk.Value == "0"):
+		b = tk.Value == "1"
+	default:
+		return false, errors.New(dc.createErrorMessage(impl.TkIdent, impl.TkNumber))
+	}
+	dc.lx.Next()
+	return
+}
+
+func (dc *decoder) acceptInt(bitSize int) (i int64, err error) {
+	if err = dc.expect(impl.TkNumber); err != nil {
+		return
+	}
+	if i, err = strconv.ParseInt(dc.lx.Tok.Value, 0, bitSize); err != nil {
+		return 0, dc.createNumberError(err)
+	}
+	dc.lx.Next()
+	return
+}
+
+func (dc *decoder) acceptUint(bitSize int) (i uint64, err error) {
+	if err = dc.expect(impl.TkNumber); err != nil {
+		return
+	}
+	if i, err = strconv.ParseUint(dc.lx.Tok.Value, 0, bitSize); err != nil {
+		return 0, dc.createNumberError(err)
+	}
+	dc.lx.Next()
+	return
+}
+
+// acceptFloat accepts a floating point number, which may be followed by the
+// 'f' suffix, or one of the identifiers representing infinity or not a
+// number.
+func (dc *decoder) acceptFloat(bitSize int) (f float64, err error) {
+	if err = dc.lx.Err; err != nil {
+		return
+	}
+	switch tk := dc.lx.Tok; tk.Kind {
+	case impl.TkNumber:
+		n := tk.Value
+		if l := len(n); l > 1 && (n[l-1] == 'f' || n[l-1] == 'F') && !isHex(n) {
+			n = n[:l-1]
+		}
+		if f, err = strconv.ParseFloat(n, bitSize); err != nil {
+			return 0, dc.createNumberError(err)
+		}
+	case impl.TkIdent:
+		switch strings.ToLower(tk.Value) {
+		case "inf", "infinity":
+			f, err = strconv.ParseFloat("+Inf", bitSize)
+		case "-inf", "-infinity":
+			f, err = strconv.ParseFloat("-Inf", bitSize)
+		case "nan":
+			f, err = strconv.ParseFloat("NaN", bitSize)
+		default:
+			return 0, errors.New(dc.createErrorMessage(impl.TkNumber))
+		}
+	default:
+		return 0, errors.New(dc.createErrorMessage(impl.TkNumber))
+	}
+	dc.lx.Next()
+	return
+}
+
+// expect checks whether the current token is of specified kind without
+// accepting it.
+func (dc *decoder) expect(tk impl.TokenKind) error {
+	if dc.lx.Err != nil {
+		return dc.lx.Err
+	} else if dc.lx.Tok.Kind != tk {
+		return errors.New(dc.createErrorMessage(tk))
+	}
+	return nil
+}
+
+func (dc *decoder) probably(tk impl.TokenKind) bool {
+	return dc.lx.Err == nil && dc.lx.Tok.Kind == tk
+}
+
+// -----------------------------------------------------------------------------
+// Helper methods
+
+// getCurrentToken gets the current token, just like it was represented in the
+// original text input.
+func (dc *decoder) getCurrentToken() string {
+	switch dc.lx.Tok.Kind {
+	case impl.TkString, impl.TkNumber, impl.TkIdent:
+		return dc.lx.Tok.Value
+	default:
+		return dc.lx.Tok.Kind.String()
+	}
+}
+
+func (dc *decoder) createErrorMessage(tk ...impl.TokenKind) string {
+	if len(tk) == 0 {
+		return fmt.Sprintf("dymessage: %v: unexpected token %q",
+			dc.lx.Tok.Pos, dc.getCurrentToken())
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("dymessage: %v: expected ", dc.lx.Tok.Pos))
+	n := len(tk)
+	sb.WriteString(tk[0].MessageString())
+	if n > 1 {
+		for i := 1; i < n-1; i++ {
+			sb.WriteString(", ")
+			sb.WriteString(tk[i].MessageString())
+		}
+		sb.WriteString(" or ")
+		sb.WriteString(tk[n-1].MessageString())
+	}
+	sb.WriteString(", but found ")
+	sb.WriteString(fmt.Sprintf("%q", dc.getCurrentToken()))
+	return sb.String()
+}
+
+// createNumberError annotates the error occurred while parsing the current
+// number token with its position.
+func (dc *decoder) createNumberError(err error) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		err = ne.Err
+	}
+	return fmt.Errorf("dymessage: %v: invalid number %q: %v",
+		dc.lx.Tok.Pos, dc.lx.Tok.Value, err)
+}
+
+// isHex gets a value indicating whether the number is written in the
+// hexadecimal notation.
+func isHex(n string) bool {
+	n = strings.TrimPrefix(n, "-")
+	return len(n) > 1 && n[0] == '0' && (n[1] == 'x' || n[1] == 'X')
+}
@@ -0,0 +1,47 @@
+package prototext
+
+import "github.com/umk/go-dymessage/internal/helpers"
+
+type (
+	// Represents an option, which alters the way the message is decoded
+	// from the text format. Provide the options to the DecodeNew
+	// function.
+	DecodeOption func(*decodeOptions)
+
+	decodeOptions struct {
+		limits helpers.Limits
+	}
+)
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	do := decodeOptions{limits: helpers.DefaultLimits()}
+	for _, opt := range opts {
+		opt(&do)
+	}
+	return do
+}
+
+// WithMaxDepth limits the nesting depth of the decoded entities, the root one
+// being at depth one. By default the depth is limited by 10000. Zero or a
+// negative value removes the limit.
+func WithMaxDepth(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxDepth = n }
+}
+
+// WithMaxBytes limits the size of the input in bytes. By default the size is
+// not limited.
+func WithMaxBytes(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxBytes = n }
+}
+
+// WithMaxRepeated limits the number of items in each of the repeated fields.
+// By default the number of items is not limited.
+func WithMaxRepeated(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxRepeated = n }
+}
+
+// WithMaxStringLength limits the length in bytes of the string and bytes
+// values. By default the length is not limited.
+func WithMaxStringLength(n int) DecodeOption {
+	return func(do *decodeOptions) { do.limits.MaxStringLength = n }
+}
@@ -0,0 +1,124 @@
+package prototext
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-stringutil"
+)
+
+type (
+	// A codec plan compiled once for a message definition. The plan
+	// provides the encoders and decoders for each of the fields, so the
+	// data types of the fields are not inspected every time the entity is
+	// encoded or decoded.
+	plan struct {
+		fields []fieldPlan
+		// Indices of the field plans by names of the fields. Both the
+		// names of the fields and the names used in the .proto files,
+		// produced by the ExportToProto function, are accepted.
+		byName map[string]int
+	}
+
+	// A plan to encode and decode a single field of the message.
+	fieldPlan struct {
+		*MessageFieldDef
+
+		// Encodes and decodes a single item of the reference field. Not
+		// set for the primitive fields.
+		encodeItem func(ec *encoder, item *Entity, pd *MessageDef, fp *fieldPlan) error
+		decodeItem func(dc *decoder, pd *MessageDef, fp *fieldPlan) (Reference, error)
+
+		// Encodes and decodes a single primitive value. Not set for the
+		// reference fields.
+		encodeValue func(ec *encoder, value Primitive)
+		decodeValue func(dc *decoder, fp *fieldPlan) (Primitive, error)
+
+		// The internal field, which holds the tag of the only field of
+		// the oneof to be encoded, and which is set to the tag of the
+		// field when it's decoded. Set only for the members of a oneof.
+		oneof *MessageFieldDef
+	}
+)
+
+var planMarker PlanMarker
+
+func init() {
+	planMarker = RegisterPlan()
+}
+
+// getPlan gets the codec plan for the message definition, compiling it if
+// necessary.
+func getPlan(pd *MessageDef) *plan {
+	return pd.GetPlan(planMarker, compilePlan).(*plan)
+}
+
+func compilePlan(pd *MessageDef) interface{} {
+	p := &plan{
+		fields: make([]fieldPlan, len(pd.Fields)),
+		byName: make(map[string]int, 2*len(pd.Fields)),
+	}
+	var kind *MessageFieldDef
+	for i, f := range pd.Fields {
+		p.fields[i] = compileField(f)
+		if f.IsInternal() {
+			kind = f
+			continue
+		}
+		p.byName[strings.ToLower(stringutil.SnakeCaps(f.Name))] = i
+	}
+	// The actual names of the fields take precedence over the names
+	// converted to the snake case.
+	for i, f := range pd.Fields {
+		if !f.IsInternal() {
+			p.byName[f.Name] = i
+		}
+	}
+	// The fields of google.protobuf.Value message are the members of a
+	// oneof, the kind of which is held by the internal field.
+	if kind != nil && pd.IsWellKnown(WellKnownValue) {
+		for i := range p.fields {
+			if fp := &p.fields[i]; fp.MessageFieldDef != kind {
+				fp.oneof = kind
+			}
+		}
+	}
+	return p
+}
+
+func compileField(f *MessageFieldDef) (fp fieldPlan) {
+	fp.MessageFieldDef = f
+	switch {
+	case f.DataType == DtString:
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeString, (*decoder).decodeString
+	case f.DataType == DtBytes:
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeBytes, (*decoder).decodeBytes
+	case f.DataType.IsEntity():
+		fp.encodeItem, fp.decodeItem = (*encoder).encodeEntity, (*decoder).decodeEntity
+	default:
+		compileValueCoders(&fp)
+	}
+	return
+}
+
+func compileValueCoders(fp *fieldPlan) {
+	switch fp.DataType {
+	case DtInt32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeInt32, (*decoder).decodeInt32
+	case DtInt64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeInt64, (*decoder).decodeInt64
+	case DtUint32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeUint32, (*decoder).decodeUint32
+	case DtUint64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeUint64, (*decoder).decodeUint64
+	case DtFloat32:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeFloat32, (*decoder).decodeFloat32
+	case DtFloat64:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeFloat64, (*decoder).decodeFloat64
+	case DtBool:
+		fp.encodeValue, fp.decodeValue = (*encoder).encodeBool, (*decoder).decodeBool
+	default:
+		panic(fmt.Sprintf("unsupported encoding data type %d", fp.DataType))
+	}
+}
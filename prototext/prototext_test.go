@@ -0,0 +1,185 @@
+package prototext
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/internal/testing"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+func TestTextEncodeDecode(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+
+	// Checking whether the message can be read right after is has been composed.
+	AssertEncodeDecode(t, def, entity)
+
+	// Converting message to text format and back.
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	entity2, err := DecodeNew(data, def)
+	require.NoError(t, err)
+
+	// Checking values of the converted message.
+	AssertEncodeDecode(t, def, entity2)
+}
+
+func TestTextEncode(t *testing.T) {
+	def := arrangeTextMessage()
+	e := def.NewEntity()
+	def.GetField(1).SetPrimitive(e, FromInt32(-5))
+	def.GetField(2).SetReference(e, FromString("a\"b\né"))
+	def.GetField(3).SetReference(e, FromBytes([]byte{0, 'x', 0xff}, false))
+	def.GetField(4).Reserve(e, 2)
+	def.GetField(4).SetPrimitiveAt(e, 0, FromFloat64(1.5))
+	def.GetField(4).SetPrimitiveAt(e, 1, FromFloat64(math.Inf(-1)))
+	nested := def.NewEntity()
+	def.GetField(1).SetPrimitive(nested, FromInt32(7))
+	def.GetField(5).SetReference(e, FromEntity(nested))
+
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+
+	expected := `Num: -5
+Str: "a\"b\n` + "é" + `"
+Raw: "\000x\377"
+Values: 1.5
+Values: -inf
+Child {
+  Num: 7
+}
+`
+	assert.Equal(t, expected, string(data))
+}
+
+func TestTextValue(t *testing.T) {
+	rb := NewRegistryBuilder()
+	def := rb.ForMessageDef("message").
+		WithName("Message").
+		WithField("Value", 1, protobuf.ForValue(rb)).
+		Build()
+	rb.Build()
+	valueDef := def.Registry.GetMessageDef(def.GetField(1).DataType)
+	value, err := protobuf.ValueFromInterface(0.0, valueDef)
+	require.NoError(t, err)
+	e := def.NewEntity()
+	def.GetField(1).SetReference(e, FromEntity(value))
+
+	// Only the field of the kind the value holds is encoded, and the kind
+	// itself is not shown.
+	data, err := Encode(e, def)
+	require.NoError(t, err)
+	assert.Equal(t, "Value {\n  NumberValue: 0\n}\n", string(data))
+	e, err = DecodeNew(data, def)
+	require.NoError(t, err)
+	value = def.GetField(1).GetReference(e).ToEntity()
+	assert.Equal(t, 0.0, protobuf.ValueToInterface(value, valueDef))
+	_, err = DecodeNew([]byte("Value { Kind: 2 }"), def)
+	assert.EqualError(t, err, `dymessage: (1:9): unknown field "Kind"`)
+
+	assert.NotContains(t, Sprint(e, def), "Kind")
+	assert.NotContains(t, ToMap(value, valueDef), "Kind")
+}
+
+func TestTextDecode(t *testing.T) {
+	def := arrangeTextMessage()
+
+	data := `
+		# The fields may be separated by commas or semicolons.
+		num: 0x10, Str: 'a' "b";
+		raw: "\x01\002"
+		values: [1, 2.5f, -Infinity]
+		Values: 3
+		child < Num: -8 child { } >
+	`
+	e, err := DecodeNew([]byte(data), def)
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(16), def.GetField(1).GetPrimitive(e).ToInt32())
+	assert.Equal(t, "ab", def.GetField(2).GetReference(e).ToString())
+	assert.Equal(t, []byte{1, 2}, def.GetField(3).GetReference(e).ToBytes())
+	values := def.GetField(4)
+	require.Equal(t, 4, values.Len(e))
+	assert.Equal(t, 1.0, values.GetPrimitiveAt(e, 0).ToFloat64())
+	assert.Equal(t, 2.5, values.GetPrimitiveAt(e, 1).ToFloat64())
+	assert.True(t, math.IsInf(values.GetPrimitiveAt(e, 2).ToFloat64(), -1))
+	assert.Equal(t, 3.0, values.GetPrimitiveAt(e, 3).ToFloat64())
+	nested := def.GetField(5).GetReference(e).ToEntity()
+	require.NotNil(t, nested)
+	assert.Equal(t, int32(-8), def.GetField(1).GetPrimitive(nested).ToInt32())
+	assert.NotNil(t, def.GetField(5).GetReference(nested).ToEntity())
+}
+
+func TestTextDecodeErrors(t *testing.T) {
+	def := arrangeTextMessage()
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`Unknown: 1`, `dymessage: (1:1): unknown field "Unknown"`},
+		{`Num 1`, `dymessage: (1:5): expected ":", but found "1"`},
+		{`Num: 1.5`, `dymessage: (1:6): invalid number "1.5": invalid syntax`},
+		{`Num: 99999999999`, `dymessage: (1:6): invalid number "99999999999": value out of range`},
+		{`Num: "1"`, `dymessage: (1:6): expected number, but found "1"`},
+		{"Child {\n  Num: 1", `dymessage: (2:10): expected identifier, but found "EOF"`},
+		{`Child: 1`, `dymessage: (1:8): expected "{" or "<", but found "1"`},
+		{`Child { Num: 1 >`, `dymessage: (1:16): expected identifier, but found ">"`},
+		{`Str: "abc`, `dymessage: (1:10): unexpected EOF`},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := DecodeNew([]byte(test.input), def)
+			require.Error(t, err)
+			assert.Equal(t, test.expected, err.Error())
+		})
+	}
+}
+
+func TestTextDecodeLimits(t *testing.T) {
+	def, entity := ArrangeEncodeDecode()
+	data, err := Encode(entity, def)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opt  DecodeOption
+		fail bool
+	}{
+		{"depth", WithMaxDepth(3), false},
+		{"depth exceeded", WithMaxDepth(2), true},
+		{"bytes", WithMaxBytes(len(data)), false},
+		{"bytes exceeded", WithMaxBytes(len(data) - 1), true},
+		{"repeated", WithMaxRepeated(3), false},
+		{"repeated exceeded", WithMaxRepeated(2), true},
+		{"string", WithMaxStringLength(20), false},
+		{"string exceeded", WithMaxStringLength(19), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeNew(data, def, test.opt)
+			if test.fail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func arrangeTextMessage() *MessageDef {
+	rb := NewRegistryBuilder()
+	return rb.ForMessageDef("text").
+		WithName("Text").
+		WithField("Num", 1, DtInt32).
+		WithField("Str", 2, DtString).
+		WithField("Raw", 3, DtBytes).
+		WithArrayField("Values", 4, DtFloat64).
+		WithField("Child", 5, rb.ForMessageDef("text").GetDataType()).
+		Build()
+}
@@ -0,0 +1,414 @@
+package query
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/lexer"
+)
+
+type (
+	// Compiles the filter into a predicate while parsing it.
+	parser struct {
+		*lexer.Scanner
+		md *MessageDef
+	}
+
+	// A literal of the filter, which is converted to a value of the data
+	// type of the field it is compared with.
+	literal struct {
+		lexer.Token
+		value interface{} // Either int64, float64, string or bool
+	}
+)
+
+// The operators ordered so that the longer ones go first.
+var operators = []string{"==", "!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", ",", ".", "-"}
+
+// parse compiles the filter for the message definition.
+func parse(src string, md *MessageDef) (predicate, error) {
+	scanner, err := lexer.NewScanner(src, operators, "end of filter")
+	if err != nil {
+		return nil, err
+	}
+	p := parser{Scanner: scanner, md: md}
+	pr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.Peek(); t.Kind != lexer.Eof {
+		return nil, p.Unexpected(t)
+	}
+	return pr, nil
+}
+
+func newError(pos int, format string, a ...interface{}) error {
+	return lexer.NewError(pos, format, a...)
+}
+
+// -----------------------------------------------------------------------------
+// Parser
+
+func (p *parser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.TryKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *Entity) bool { return l(e) || right(e) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.TryKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *Entity) bool { return l(e) && right(e) }
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (predicate, error) {
+	if p.TryKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *Entity) bool { return !operand(e) }, nil
+	}
+	if _, ok := p.TryOperator("("); ok {
+		pr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return pr, p.AcceptOperator(")")
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (predicate, error) {
+	start := p.Peek()
+	fp, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	f := fp.last()
+	t := p.Next()
+	switch {
+	case isComparison(t):
+		return p.parseComparison(fp, t)
+	case lexer.IsKeyword(t, "IN"):
+		return p.parseIn(fp)
+	case lexer.IsKeyword(t, "CONTAINS"):
+		return p.parseContains(fp)
+	case lexer.IsKeyword(t, "IS"):
+		negate := p.TryKeyword("NOT")
+		if err := p.AcceptKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		if f.Repeated || !f.DataType.IsEntity() {
+			return nil, newError(start.Start, "field %q is not a nested entity", f.Name)
+		}
+		return func(e *Entity) bool {
+			h := fp.holder(e)
+			return (h == nil || f.GetReference(h).Entity == nil) != negate
+		}, nil
+	}
+	return nil, p.Unexpected(t)
+}
+
+func (p *parser) parseComparison(fp fieldPath, op lexer.Token) (predicate, error) {
+	f := fp.last()
+	if err := p.checkScalar(fp, op); err != nil {
+		return nil, err
+	}
+	lit, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	value, ref, err := convert(f, lit)
+	if err != nil {
+		return nil, err
+	}
+	if f.DataType == DtBool && op.Text != "=" && op.Text != "==" && op.Text != "!=" && op.Text != "<>" {
+		return nil, newError(op.Start, "operator %s is not defined for bool field %q", op.Text, f.Name)
+	}
+	var test func(c int) bool
+	switch op.Text {
+	case "=", "==":
+		test = func(c int) bool { return c == 0 }
+	case "!=", "<>":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	default:
+		test = func(c int) bool { return c >= 0 }
+	}
+	cmp := getComparer(f.DataType)
+	return func(e *Entity) bool {
+		h := fp.holder(e)
+		return h != nil && test(cmp(fp.primitive(h), value, fp.reference(h), ref))
+	}, nil
+}
+
+func (p *parser) parseIn(fp fieldPath) (predicate, error) {
+	f := fp.last()
+	if err := p.checkScalar(fp, p.Prev()); err != nil {
+		return nil, err
+	}
+	if err := p.AcceptOperator("("); err != nil {
+		return nil, err
+	}
+	var values []Primitive
+	var refs []Reference
+	for {
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		value, ref, err := convert(f, lit)
+		if err != nil {
+			return nil, err
+		}
+		values, refs = append(values, value), append(refs, ref)
+		if _, ok := p.TryOperator(","); !ok {
+			break
+		}
+	}
+	if err := p.AcceptOperator(")"); err != nil {
+		return nil, err
+	}
+	cmp := getComparer(f.DataType)
+	return func(e *Entity) bool {
+		h := fp.holder(e)
+		if h == nil {
+			return false
+		}
+		value, ref := fp.primitive(h), fp.reference(h)
+		for i := range values {
+			if cmp(value, values[i], ref, refs[i]) == 0 {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func (p *parser) parseContains(fp fieldPath) (predicate, error) {
+	f := fp.last()
+	lit, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if !f.Repeated {
+		s, ok := lit.value.(string)
+		if f.DataType != DtString || !ok {
+			return nil, newError(lit.Start, "CONTAINS requires a repeated field or a string field with a string")
+		}
+		sub := []byte(s)
+		return func(e *Entity) bool {
+			h := fp.holder(e)
+			return h != nil && bytes.Contains(f.GetReference(h).ToBytes(), sub)
+		}, nil
+	}
+	if f.DataType.IsEntity() || f.DataType == DtBytes {
+		return nil, newError(lit.Start, "items of field %q cannot be compared", f.Name)
+	}
+	value, ref, err := convert(f, lit)
+	if err != nil {
+		return nil, err
+	}
+	cmp := getComparer(f.DataType)
+	return func(e *Entity) bool {
+		h := fp.holder(e)
+		if h == nil {
+			return false
+		}
+		for i, n := 0, f.Len(h); i < n; i++ {
+			var c int
+			if f.DataType.IsRefType() {
+				c = cmp(value, value, f.GetReferenceAt(h, i), ref)
+			} else {
+				c = cmp(f.GetPrimitiveAt(h, i), value, ref, ref)
+			}
+			if c == 0 {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func (p *parser) parsePath() (fp fieldPath, err error) {
+	t := p.Next()
+	if t.Kind != lexer.Ident || isReserved(t) {
+		return nil, p.Unexpected(t)
+	}
+	md := p.md
+	for {
+		f, ok := md.TryGetFieldByName(t.Text)
+		if !ok {
+			return nil, newError(t.Start, "unknown field %q of %s", t.Text, md.QualifiedName())
+		}
+		fp = append(fp, f)
+		if _, ok := p.TryOperator("."); !ok {
+			return fp, nil
+		}
+		if f.Repeated || !f.DataType.IsEntity() {
+			return nil, newError(t.Start, "field %q of %s is not a nested entity", t.Text, md.QualifiedName())
+		}
+		md = md.Registry.GetMessageDef(f.DataType)
+		if t = p.Next(); t.Kind != lexer.Ident {
+			return nil, p.Unexpected(t)
+		}
+	}
+}
+
+func (p *parser) parseLiteral() (lit literal, err error) {
+	minus, negative := p.TryOperator("-")
+	if lit.Token = p.Next(); negative {
+		if lit.Kind != lexer.Number {
+			err = p.Unexpected(lit.Token)
+			return
+		}
+		lit.Token = lexer.Token{Kind: lexer.Number, Text: "-" + lit.Text, Start: minus.Start}
+	}
+	switch lit.Kind {
+	case lexer.Number:
+		if v, e := strconv.ParseInt(lit.Text, 10, 64); e == nil {
+			lit.value = v
+		} else if v, e := strconv.ParseFloat(lit.Text, 64); e == nil {
+			lit.value = v
+		} else {
+			err = newError(lit.Start, "invalid number %s", lit.Text)
+		}
+		return
+	case lexer.String:
+		if lit.value, err = strconv.Unquote(lit.Text); err != nil {
+			err = newError(lit.Start, "invalid string %s", lit.Text)
+		}
+		return
+	case lexer.Ident:
+		if lexer.IsKeyword(lit.Token, "TRUE") || lexer.IsKeyword(lit.Token, "FALSE") {
+			lit.value = lexer.IsKeyword(lit.Token, "TRUE")
+			return
+		}
+	}
+	err = p.Unexpected(lit.Token)
+	return
+}
+
+// checkScalar checks whether the field can be compared with the literals.
+func (p *parser) checkScalar(fp fieldPath, op lexer.Token) error {
+	if f := fp.last(); f.Repeated || f.DataType.IsEntity() || f.DataType == DtBytes {
+		return newError(op.Start, "operator %s is not defined for field %q", op.Text, f.Name)
+	}
+	return nil
+}
+
+func isComparison(t lexer.Token) bool {
+	switch t.Text {
+	case "==", "!=", "<>", "<=", ">=", "=", "<", ">":
+		return t.Kind == lexer.Operator
+	}
+	return false
+}
+
+func isReserved(t lexer.Token) bool {
+	for _, kw := range []string{"AND", "OR", "NOT", "IN", "CONTAINS", "IS", "NULL", "TRUE", "FALSE"} {
+		if lexer.IsKeyword(t, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+// Values
+
+// convert converts the literal to the value of the data type of the field, so
+// the literal could be compared with the values of the field.
+func convert(f *MessageFieldDef, lit literal) (p Primitive, r Reference, err error) {
+	ok := false
+	switch v := lit.value.(type) {
+	case int64:
+		switch f.DataType {
+		case DtInt32:
+			p, ok = FromInt32(int32(v)), v >= math.MinInt32 && v <= math.MaxInt32
+		case DtInt64:
+			p, ok = FromInt64(v), true
+		case DtUint32:
+			p, ok = FromUint32(uint32(v)), v >= 0 && v <= math.MaxUint32
+		case DtUint64:
+			p, ok = FromUint64(uint64(v)), v >= 0
+		case DtFloat32:
+			p, ok = FromFloat32(float32(v)), true
+		case DtFloat64:
+			p, ok = FromFloat64(float64(v)), true
+		}
+	case float64:
+		switch f.DataType {
+		case DtFloat32:
+			p, ok = FromFloat32(float32(v)), true
+		case DtFloat64:
+			p, ok = FromFloat64(v), true
+		}
+	case string:
+		r, ok = FromString(v), f.DataType == DtString
+	case bool:
+		p, ok = FromBool(v), f.DataType == DtBool
+	}
+	if !ok {
+		err = newError(lit.Start, "field %q cannot be compared with %s", f.Name, lit.Text)
+	}
+	return
+}
+
+// getComparer gets the function, which compares two values of the data type.
+func getComparer(dt DataType) func(a, b Primitive, ra, rb Reference) int {
+	switch dt {
+	case DtInt32:
+		return func(a, b Primitive, _, _ Reference) int {
+			return lexer.Sign(a.ToInt32() < b.ToInt32(), a.ToInt32() > b.ToInt32())
+		}
+	case DtInt64:
+		return func(a, b Primitive, _, _ Reference) int {
+			return lexer.Sign(a.ToInt64() < b.ToInt64(), a.ToInt64() > b.ToInt64())
+		}
+	case DtUint32, DtUint64:
+		return func(a, b Primitive, _, _ Reference) int { return lexer.Sign(a < b, a > b) }
+	case DtFloat32:
+		return func(a, b Primitive, _, _ Reference) int {
+			return lexer.Sign(a.ToFloat32() < b.ToFloat32(), a.ToFloat32() > b.ToFloat32())
+		}
+	case DtFloat64:
+		return func(a, b Primitive, _, _ Reference) int {
+			return lexer.Sign(a.ToFloat64() < b.ToFloat64(), a.ToFloat64() > b.ToFloat64())
+		}
+	case DtBool:
+		return func(a, b Primitive, _, _ Reference) int {
+			return lexer.Sign(!a.ToBool() && b.ToBool(), a.ToBool() && !b.ToBool())
+		}
+	default:
+		return func(_, _ Primitive, ra, rb Reference) int { return bytes.Compare(ra.ToBytes(), rb.ToBytes()) }
+	}
+}
@@ -0,0 +1,279 @@
+// Package query filters, orders and projects the collections of entities. The
+// filters are written in a simple language:
+//
+//	age >= 18 AND tags CONTAINS "vip" AND NOT (status IN ("closed", "banned"))
+//
+// The filters are compiled once against the message definition, so the fields
+// referred by the filter are looked up only when compiling, and the filters may
+// then be evaluated against many entities of the definition.
+//
+// The filter consists of the conditions combined with AND, OR and NOT, and
+// grouped with the parentheses. The conditions compare the fields with the
+// literals, which are the numbers, strings in double quotes, true and false:
+//
+//	field = literal, field != literal, field <> literal
+//	field < literal, field <= literal, field > literal, field >= literal
+//	field IN (literal, ...)
+//	field CONTAINS literal
+//	field IS NULL, field IS NOT NULL
+//
+// The fields of the nested entities are referred by their paths, like
+// "address.city", and the conditions on the fields of null entities don't
+// hold, except for IS NULL. The CONTAINS condition checks whether a repeated
+// field contains the item or whether a string contains the substring, and the
+// IS NULL condition applies to the fields of entity types. The keywords are
+// case-insensitive.
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/lexer"
+)
+
+type (
+	// A query compiled for the message definition, which filters, orders
+	// and projects the entities of the definition. The query is safe for
+	// concurrent use, but the lazy entities are decoded when the query
+	// reads them, so the entities, which may be lazy, must not be passed
+	// to the queries running concurrently unless loaded beforehand.
+	Query struct {
+		md     *MessageDef
+		filter predicate  // Matches all of the entities if nil
+		order  []orderKey // The keys the entities are ordered by
+		fields []*MessageFieldDef
+	}
+
+	// Represents an option of the query.
+	Option func(*options)
+
+	options struct {
+		order  []orderOption
+		fields []string
+	}
+
+	orderOption struct {
+		path string
+		desc bool
+	}
+
+	// A condition compiled for the message definition.
+	predicate func(e *Entity) bool
+
+	// A key the entities are ordered by.
+	orderKey struct {
+		path fieldPath
+		cmp  func(a, b Primitive, ra, rb Reference) int
+		desc bool
+	}
+
+	// A path to the field of the entity or its nested entities. All but
+	// the last fields are the non-repeated fields of entity types.
+	fieldPath []*MessageFieldDef
+)
+
+// OrderBy produces an option of the query, which orders the entities by the
+// field in ascending order. The field must be a non-repeated field of a
+// numeric, boolean or string type, and may be a field of the nested entities.
+// The entities, which have null nested entities, go first. When specified
+// several times, the entities are ordered by the first field and then by the
+// following ones.
+func OrderBy(path string) Option {
+	return func(o *options) { o.order = append(o.order, orderOption{path: path}) }
+}
+
+// OrderByDesc produces an option of the query, which orders the entities by
+// the field in descending order. See OrderBy for details.
+func OrderByDesc(path string) Option {
+	return func(o *options) { o.order = append(o.order, orderOption{path: path, desc: true}) }
+}
+
+// Select produces an option of the query, which projects the entities to the
+// specified fields of the message definition, leaving other fields of the
+// entities produced by the query set to default values.
+func Select(fields ...string) Option {
+	return func(o *options) { o.fields = append(o.fields, fields...) }
+}
+
+// Compile compiles the filter along with the options of the query for the
+// message definition. An empty filter matches all of the entities.
+func Compile(filter string, md *MessageDef, opts ...Option) (q *Query, err error) {
+	q = &Query{md: md}
+	if strings.TrimSpace(filter) != "" {
+		if q.filter, err = parse(filter, md); err != nil {
+			return nil, err
+		}
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for _, oo := range o.order {
+		var key orderKey
+		if key, err = compileOrderKey(oo, md); err != nil {
+			return nil, err
+		}
+		q.order = append(q.order, key)
+	}
+	for _, name := range o.fields {
+		f, ok := md.TryGetFieldByName(name)
+		if !ok {
+			return nil, unknownField(name, md)
+		}
+		q.fields = append(q.fields, f)
+	}
+	return q, nil
+}
+
+// MustCompile compiles the query just like Compile, but panics if the query
+// cannot be compiled.
+func MustCompile(filter string, md *MessageDef, opts ...Option) *Query {
+	q, err := Compile(filter, md, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// Match gets a value indicating whether the entity matches the filter of the
+// query.
+func (q *Query) Match(e *Entity) bool {
+	return q.filter == nil || q.filter(e)
+}
+
+// Filter gets the entities, which match the filter of the query, preserving
+// their order. Neither ordering nor projection are applied.
+func (q *Query) Filter(entities []*Entity) (result []*Entity) {
+	for _, e := range entities {
+		if q.Match(e) {
+			result = append(result, e)
+		}
+	}
+	return
+}
+
+// Run gets the entities, which match the filter of the query, ordered and
+// projected according to the options of the query. The projected entities
+// share the values of reference types with the original ones.
+func (q *Query) Run(entities []*Entity) []*Entity {
+	result := q.Filter(entities)
+	if len(q.order) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return q.compare(result[i], result[j]) < 0
+		})
+	}
+	if q.fields != nil {
+		for i, e := range result {
+			result[i] = q.project(e)
+		}
+	}
+	return result
+}
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+func (q *Query) compare(a, b *Entity) int {
+	for _, key := range q.order {
+		ha, hb := key.path.holder(a), key.path.holder(b)
+		var c int
+		switch {
+		case ha == nil || hb == nil:
+			c = lexer.Sign(ha == nil && hb != nil, ha != nil && hb == nil)
+		default:
+			c = key.cmp(key.path.primitive(ha), key.path.primitive(hb),
+				key.path.reference(ha), key.path.reference(hb))
+		}
+		if c != 0 {
+			if key.desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+func (q *Query) project(e *Entity) *Entity {
+	projected := q.md.NewEntity()
+	for _, f := range q.fields {
+		if f.DataType.IsRefType() || f.Repeated {
+			// The repeated fields are stored as a reference to the
+			// collection of items.
+			f.SetReference(projected, f.GetReference(e))
+		} else {
+			f.SetPrimitive(projected, f.GetPrimitive(e))
+		}
+	}
+	return projected
+}
+
+func compileOrderKey(oo orderOption, md *MessageDef) (key orderKey, err error) {
+	if key.path, err = resolvePath(oo.path, md); err != nil {
+		return
+	}
+	f := key.path.last()
+	if f.Repeated || f.DataType.IsEntity() || f.DataType == DtBytes {
+		err = fmt.Errorf("dymessage: entities cannot be ordered by field %q", oo.path)
+		return
+	}
+	key.cmp, key.desc = getComparer(f.DataType), oo.desc
+	return
+}
+
+// resolvePath gets the fields, which the dot-separated path refers.
+func resolvePath(path string, md *MessageDef) (fieldPath, error) {
+	names := strings.Split(path, ".")
+	fp := make(fieldPath, len(names))
+	for i, name := range names {
+		f, ok := md.TryGetFieldByName(name)
+		if !ok {
+			return nil, unknownField(name, md)
+		}
+		fp[i] = f
+		if i < len(names)-1 {
+			if f.Repeated || !f.DataType.IsEntity() {
+				return nil, fmt.Errorf("dymessage: field %q of %s is not a nested entity", name, md.QualifiedName())
+			}
+			md = md.Registry.GetMessageDef(f.DataType)
+		}
+	}
+	return fp, nil
+}
+
+func unknownField(name string, md *MessageDef) error {
+	return fmt.Errorf("dymessage: unknown field %q of %s", name, md.QualifiedName())
+}
+
+// -----------------------------------------------------------------------------
+// Field paths
+
+func (fp fieldPath) last() *MessageFieldDef { return fp[len(fp)-1] }
+
+// holder gets the entity, which contains the last field of the path, or nil if
+// any of the nested entities is null.
+func (fp fieldPath) holder(e *Entity) *Entity {
+	for _, f := range fp[:len(fp)-1] {
+		if e = f.GetReference(e).Entity; e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+func (fp fieldPath) primitive(e *Entity) Primitive {
+	if f := fp.last(); !f.DataType.IsRefType() {
+		return f.GetPrimitive(e)
+	}
+	return GetDefaultPrimitive()
+}
+
+func (fp fieldPath) reference(e *Entity) Reference {
+	if f := fp.last(); f.DataType.IsRefType() {
+		return f.GetReference(e)
+	}
+	return GetDefaultReference()
+}
@@ -0,0 +1,133 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json"
+)
+
+func arrangeQuery(t *testing.T) (*MessageDef, []*Entity) {
+	rb := NewRegistryBuilder()
+	address := rb.ForMessageDef("address").
+		WithName("Address").
+		WithField("city", 1, DtString).
+		Build()
+	def := rb.ForMessageDef("person").
+		WithNamespace("koala").
+		WithName("Person").
+		WithField("name", 1, DtString).
+		WithField("age", 2, DtInt32).
+		WithField("score", 3, DtFloat64).
+		WithField("active", 4, DtBool).
+		WithArrayField("tags", 5, DtString).
+		WithArrayField("codes", 6, DtUint32).
+		WithField("address", 7, address.DataType).
+		Build()
+	rb.Build()
+	var entities []*Entity
+	for _, s := range []string{
+		`{"name":"Alice","age":30,"score":4.5,"active":true,"tags":["vip","new"],"codes":[1,2],"address":{"city":"Oslo"}}`,
+		`{"name":"Bob","age":17,"score":3.0,"active":false,"tags":["new"],"address":{"city":"Bergen"}}`,
+		`{"name":"Carol","age":45,"score":4.5,"active":true,"tags":["vip"],"codes":[3]}`,
+		`{"name":"Dave","age":-3,"score":1.5,"active":false}`,
+	} {
+		e, err := json.DecodeNew([]byte(s), def)
+		require.NoError(t, err)
+		entities = append(entities, e)
+	}
+	return def, entities
+}
+
+func names(def *MessageDef, entities []*Entity) (result []string) {
+	for _, e := range entities {
+		result = append(result, def.GetFieldByName("name").GetReference(e).ToString())
+	}
+	return
+}
+
+func TestFilter(t *testing.T) {
+	def, entities := arrangeQuery(t)
+	tests := []struct {
+		filter   string
+		expected []string
+	}{
+		{``, []string{"Alice", "Bob", "Carol", "Dave"}},
+		{`age >= 18 AND tags CONTAINS "vip"`, []string{"Alice", "Carol"}},
+		{`age < 0 or name = "Bob"`, []string{"Bob", "Dave"}},
+		{`NOT (age > 18) AND active == false`, []string{"Bob", "Dave"}},
+		{`score = 4.5 AND age <> 30`, []string{"Carol"}},
+		{`score > 2`, []string{"Alice", "Bob", "Carol"}},
+		{`score > 0.2e1 AND age > - 1`, []string{"Alice", "Bob", "Carol"}},
+		{`name IN ("Bob", "Dave", "Eve")`, []string{"Bob", "Dave"}},
+		{`age IN (-3, 45)`, []string{"Carol", "Dave"}},
+		{`name CONTAINS "a"`, []string{"Carol", "Dave"}},
+		{`codes CONTAINS 2 OR codes CONTAINS 3`, []string{"Alice", "Carol"}},
+		{`address.city = "Oslo"`, []string{"Alice"}},
+		{`address.city != "Oslo"`, []string{"Bob"}},
+		{`address IS NULL`, []string{"Carol", "Dave"}},
+		{`address is not null and active = true`, []string{"Alice"}},
+		{`name > "B" AND name <= "Carol"`, []string{"Bob", "Carol"}},
+	}
+	for _, tt := range tests {
+		q, err := Compile(tt.filter, def)
+		require.NoError(t, err, tt.filter)
+		assert.Equal(t, tt.expected, names(def, q.Filter(entities)), tt.filter)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	def, _ := arrangeQuery(t)
+	tests := []struct {
+		filter, message string
+	}{
+		{`age >`, `dymessage: (6): unexpected end of filter`},
+		{`age > 18 AND`, `dymessage: (13): unexpected end of filter`},
+		{`(age > 18`, `dymessage: (10): expected ")", but got end of filter`},
+		{`height > 18`, `dymessage: (1): unknown field "height" of koala.Person`},
+		{`address.zip = "1"`, `dymessage: (9): unknown field "zip" of Address`},
+		{`name.first = "A"`, `dymessage: (1): field "name" of koala.Person is not a nested entity`},
+		{`age = "18"`, `dymessage: (7): field "age" cannot be compared with "18"`},
+		{`age = 1.5`, `dymessage: (7): field "age" cannot be compared with 1.5`},
+		{`age = 3000000000`, `dymessage: (7): field "age" cannot be compared with 3000000000`},
+		{`active > true`, `dymessage: (8): operator > is not defined for bool field "active"`},
+		{`tags = "vip"`, `dymessage: (6): operator = is not defined for field "tags"`},
+		{`age CONTAINS 1`, `dymessage: (14): CONTAINS requires a repeated field or a string field with a string`},
+		{`name IS NULL`, `dymessage: (1): field "name" is not a nested entity`},
+		{`age ~ 1`, `dymessage: (5): unexpected character '~'`},
+		{`name = "Bob`, `dymessage: (8): unterminated string`},
+		{`age = -"1"`, `dymessage: (8): unexpected "\"1\""`},
+		{`age = -3000000000`, `dymessage: (7): field "age" cannot be compared with -3000000000`},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.filter, def)
+		assert.EqualError(t, err, tt.message, tt.filter)
+	}
+	_, err := Compile("", def, OrderBy("tags"))
+	assert.EqualError(t, err, `dymessage: entities cannot be ordered by field "tags"`)
+	_, err = Compile("", def, Select("height"))
+	assert.EqualError(t, err, `dymessage: unknown field "height" of koala.Person`)
+}
+
+func TestRun(t *testing.T) {
+	def, entities := arrangeQuery(t)
+	q := MustCompile(`age > 0`, def, OrderByDesc("score"), OrderBy("name"))
+	assert.Equal(t, []string{"Alice", "Carol", "Bob"}, names(def, q.Run(entities)))
+
+	q = MustCompile(``, def, OrderBy("address.city"), OrderByDesc("age"))
+	assert.Equal(t, []string{"Carol", "Dave", "Bob", "Alice"}, names(def, q.Run(entities)))
+
+	q = MustCompile(`active = true`, def, OrderBy("age"), Select("name", "tags"))
+	result := q.Run(entities)
+	require.Len(t, result, 2)
+	assert.Equal(t, []string{"Alice", "Carol"}, names(def, result))
+	tags := def.GetFieldByName("tags")
+	assert.Equal(t, 2, tags.Len(result[0]))
+	assert.Equal(t, int32(0), def.GetFieldByName("age").GetPrimitive(result[0]).ToInt32())
+	assert.Nil(t, def.GetFieldByName("address").GetReference(result[0]).Entity)
+	// The original entities are left intact.
+	assert.Equal(t, int32(30), def.GetFieldByName("age").GetPrimitive(entities[0]).ToInt32())
+}
@@ -1,6 +1,9 @@
 package dymessage
 
-import "fmt"
+import (
+	"fmt"
+	"sync/atomic"
+)
 
 type (
 	// Represents a collection of message definitions. The messages
@@ -15,6 +18,10 @@ type (
 
 	// Represents a definition of the message structure.
 	MessageDef struct {
+		// A collection of extensions which alter the way the messages
+		// of this definition are processed.
+		Extensions
+
 		Namespace string // An optional namespace of the message definition
 		Name      string // Name of the message definition
 
@@ -32,6 +39,10 @@ type (
 		// of entities and repeated primitive values are represented
 		// by a single entity.
 		EntityBufLength int
+
+		// The codec plans compiled for this message definition. See
+		// the GetPlan method for details.
+		plans atomic.Value
 	}
 
 	// Represents a single field of a message.
@@ -55,6 +66,13 @@ type (
 // -----------------------------------------------------------------------------
 // Implementation
 
+// IsInternal gets a value indicating whether the field holds an internal state
+// of the entity rather than its content, like the kind of google.protobuf.Value
+// message. The tags of such fields are in range from 19000 to 19999, which is
+// reserved by protocol buffers, and the fields are omitted by the printer, the
+// mapping to Go maps and the text format.
+func (f *MessageFieldDef) IsInternal() bool { return f.Tag >= 19000 && f.Tag < 20000 }
+
 // GetMessageDef gets the message definition by its data type.
 func (r *Registry) GetMessageDef(dt DataType) *MessageDef {
 	id, n := int(dt&^DtEntity), len(r.Defs)
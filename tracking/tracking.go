@@ -0,0 +1,320 @@
+// Package tracking records the changes of the entities, so only the fields,
+// which have been modified since a checkpoint, could be persisted.
+//
+// The tracker wraps the entity along with its message definition and provides
+// the setters similar to the ones of the message fields. The values set with
+// the tracker are recorded as modified, while the values set directly with the
+// message fields are not. The fields of the nested entities are tracked by the
+// nested trackers, which report their changes to the tracker of the containing
+// entity.
+package tracking
+
+import (
+	"sort"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// Tracks the modifications of the fields of the entity. The tracker is
+	// not safe for concurrent use.
+	Tracker struct {
+		e  *Entity
+		md *MessageDef
+
+		fields map[*MessageFieldDef]*fieldState
+		nested map[*MessageFieldDef]*Tracker
+		// The trackers of the items of the repeated fields, which are
+		// kept so their indices could be shifted along with the items.
+		items map[*MessageFieldDef][]*Tracker
+
+		// The tracker of the containing entity and the field, which
+		// refers the entity, or nil if the entity is the root one. The
+		// index is the position of the entity in the repeated field,
+		// or negative if the field is not repeated.
+		parent *Tracker
+		field  *MessageFieldDef
+		index  int
+	}
+
+	// The modifications of a single field.
+	fieldState struct {
+		all     bool             // The whole value of the field has been modified
+		indices map[int]struct{} // The modified items of the repeated field
+	}
+)
+
+// New creates a tracker for the entity of the message definition. No changes
+// are recorded for the entity until it is modified with the tracker.
+func New(e *Entity, md *MessageDef) *Tracker {
+	return &Tracker{e: e, md: md, index: -1}
+}
+
+// Entity gets the entity the tracker records the changes of.
+func (t *Tracker) Entity() *Entity { return t.e }
+
+// Def gets the message definition of the entity.
+func (t *Tracker) Def() *MessageDef { return t.md }
+
+// -----------------------------------------------------------------------------
+// Setters
+
+func (t *Tracker) SetPrimitive(f *MessageFieldDef, value Primitive) {
+	f.SetPrimitive(t.e, value)
+	t.touch(f)
+}
+
+func (t *Tracker) SetPrimitiveAt(f *MessageFieldDef, n int, value Primitive) {
+	f.SetPrimitiveAt(t.e, n, value)
+	t.touchAt(f, n, 1)
+}
+
+// SetReference sets the reference value of the field. For the fields of entity
+// types the nested tracker of the field, if any, is discarded along with its
+// changes, because the whole nested entity is recorded as modified. For the
+// repeated fields the trackers of the items are detached, so their further
+// changes are not recorded.
+func (t *Tracker) SetReference(f *MessageFieldDef, value Reference) {
+	f.SetReference(t.e, value)
+	delete(t.nested, f)
+	for _, nt := range t.items[f] {
+		nt.parent = nil
+	}
+	delete(t.items, f)
+	t.touch(f)
+}
+
+// SetReferenceAt sets the reference value of the item of the repeated field.
+// For the fields of entity types the nested tracker of the item, if any, is
+// detached, so its further changes are not recorded.
+func (t *Tracker) SetReferenceAt(f *MessageFieldDef, n int, value Reference) {
+	f.SetReferenceAt(t.e, n, value)
+	t.shiftItems(f, n, n+1, 0)
+	t.touchAt(f, n, 1)
+}
+
+// Reserve reserves a room for specified number of items just like the Reserve
+// method of the message field, recording the reserved items as modified.
+func (t *Tracker) Reserve(f *MessageFieldDef, count int) int {
+	n := f.Reserve(t.e, count)
+	t.touchAt(f, n, count)
+	return n
+}
+
+// Insert inserts a room for specified number of items just like the Insert
+// method of the message field. Because the following items are shifted, the
+// whole field is recorded as modified.
+func (t *Tracker) Insert(f *MessageFieldDef, n, count int) {
+	f.Insert(t.e, n, count)
+	t.shiftItems(f, n, n, count)
+	t.touch(f)
+}
+
+// Remove removes specified number of items just like the Remove method of the
+// message field. Because the following items are shifted, the whole field is
+// recorded as modified.
+func (t *Tracker) Remove(f *MessageFieldDef, n, count int) {
+	f.Remove(t.e, n, count)
+	t.shiftItems(f, n, n+count, -count)
+	t.touch(f)
+}
+
+// Nested gets the tracker of the nested entity of the non-repeated field of an
+// entity type, which records the changes of the fields of the nested entity as
+// the changes of the paths starting at the field. If the nested entity is null,
+// a new one is created and the whole field is recorded as modified.
+func (t *Tracker) Nested(f *MessageFieldDef) *Tracker {
+	e := f.GetReference(t.e).Entity
+	if e == nil {
+		e = t.md.Registry.GetMessageDef(f.DataType).NewEntity()
+		t.SetReference(f, FromEntity(e))
+	}
+	if nt, ok := t.nested[f]; ok && nt.e == e {
+		return nt
+	}
+	nt := t.newNested(e, f, -1)
+	if t.nested == nil {
+		t.nested = make(map[*MessageFieldDef]*Tracker)
+	}
+	t.nested[f] = nt
+	return nt
+}
+
+// NestedAt gets the tracker of the nested entity, which is an item of the
+// repeated field of an entity type. Any change of the nested entity is recorded
+// as the modification of the item. If the item is null, a new entity is created
+// and the item is recorded as modified. The tracker follows the item when the
+// items are shifted by the Insert and Remove methods of the tracker.
+func (t *Tracker) NestedAt(f *MessageFieldDef, n int) *Tracker {
+	e := f.GetReferenceAt(t.e, n).Entity
+	if e == nil {
+		e = t.md.Registry.GetMessageDef(f.DataType).NewEntity()
+		t.SetReferenceAt(f, n, FromEntity(e))
+	}
+	for _, nt := range t.items[f] {
+		if nt.index == n && nt.e == e {
+			return nt
+		}
+	}
+	nt := t.newNested(e, f, n)
+	if t.items == nil {
+		t.items = make(map[*MessageFieldDef][]*Tracker)
+	}
+	t.items[f] = append(t.items[f], nt)
+	return nt
+}
+
+// -----------------------------------------------------------------------------
+// Recorded changes
+
+// Modified gets a value indicating whether any of the fields of the entity or
+// its nested entities have been modified since the checkpoint.
+func (t *Tracker) Modified() bool {
+	if len(t.fields) > 0 {
+		return true
+	}
+	for _, nt := range t.nested {
+		if nt.Modified() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsModified gets a value indicating whether the field or any of the fields of
+// its nested entity have been modified since the checkpoint.
+func (t *Tracker) IsModified(f *MessageFieldDef) bool {
+	if _, ok := t.fields[f]; ok {
+		return true
+	}
+	nt, ok := t.nested[f]
+	return ok && nt.Modified()
+}
+
+// ModifiedIndices gets the ordered indices of the modified items of the
+// repeated field. If the whole field has been modified, for example because
+// its items have been shifted, the all flag is set and no indices are
+// returned.
+func (t *Tracker) ModifiedIndices(f *MessageFieldDef) (indices []int, all bool) {
+	fs, ok := t.fields[f]
+	if !ok {
+		return nil, false
+	}
+	if fs.all {
+		return nil, true
+	}
+	for n := range fs.indices {
+		indices = append(indices, n)
+	}
+	sort.Ints(indices)
+	return
+}
+
+// FieldMask gets the paths of the modified fields in the form of the paths of
+// google.protobuf.FieldMask, where the names of the fields of the nested
+// entities are separated by dots. The paths follow the order of the fields in
+// the message definitions. The repeated fields are included as a whole, even
+// if only some of their items have been modified.
+func (t *Tracker) FieldMask() (paths []string) {
+	return t.appendPaths(paths, "")
+}
+
+// Reset discards the recorded changes of the entity and its nested entities,
+// making the current state of the entity a new checkpoint.
+func (t *Tracker) Reset() {
+	t.fields = nil
+	for _, nt := range t.nested {
+		nt.Reset()
+	}
+	for _, items := range t.items {
+		for _, nt := range items {
+			nt.Reset()
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+func (t *Tracker) newNested(e *Entity, f *MessageFieldDef, n int) *Tracker {
+	nt := New(e, t.md.Registry.GetMessageDef(f.DataType))
+	nt.parent, nt.field, nt.index = t, f, n
+	return nt
+}
+
+// notify records the change of the entity in the trackers of the containing
+// entities. The change of an item of the repeated field is recorded as the
+// modification of the item, while the changes of the nested entities of the
+// fields, which are not repeated, are reported by their trackers.
+func (t *Tracker) notify() {
+	switch {
+	case t.parent == nil:
+	case t.index >= 0:
+		t.parent.touchAt(t.field, t.index, 1)
+	default:
+		t.parent.notify()
+	}
+}
+
+// shiftItems detaches the trackers of the items of the repeated field from n to
+// end exclusively, which have been replaced or removed, and shifts the indices
+// of the trackers of the following items by delta.
+func (t *Tracker) shiftItems(f *MessageFieldDef, n, end, delta int) {
+	items := t.items[f]
+	if items == nil {
+		return
+	}
+	kept := items[:0]
+	for _, nt := range items {
+		switch {
+		case nt.index >= end:
+			nt.index += delta
+		case nt.index >= n:
+			// The detached tracker no longer reports its changes.
+			nt.parent = nil
+			continue
+		}
+		kept = append(kept, nt)
+	}
+	t.items[f] = kept
+}
+
+func (t *Tracker) touch(f *MessageFieldDef) {
+	t.state(f).all = true
+	t.notify()
+}
+
+func (t *Tracker) touchAt(f *MessageFieldDef, n, count int) {
+	if fs := t.state(f); !fs.all {
+		if fs.indices == nil {
+			fs.indices = make(map[int]struct{})
+		}
+		for i := n; i < n+count; i++ {
+			fs.indices[i] = struct{}{}
+		}
+	}
+	t.notify()
+}
+
+func (t *Tracker) state(f *MessageFieldDef) *fieldState {
+	fs, ok := t.fields[f]
+	if !ok {
+		fs = new(fieldState)
+		if t.fields == nil {
+			t.fields = make(map[*MessageFieldDef]*fieldState)
+		}
+		t.fields[f] = fs
+	}
+	return fs
+}
+
+func (t *Tracker) appendPaths(paths []string, prefix string) []string {
+	for _, f := range t.md.Fields {
+		if _, ok := t.fields[f]; ok {
+			paths = append(paths, prefix+f.Name)
+		} else if nt, ok := t.nested[f]; ok {
+			paths = nt.appendPaths(paths, prefix+f.Name+".")
+		}
+	}
+	return paths
+}
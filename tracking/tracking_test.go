@@ -0,0 +1,133 @@
+package tracking
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/umk/go-dymessage"
+	. "github.com/umk/go-dymessage/internal/testing"
+)
+
+func TestTracker(t *testing.T) {
+	def := ArrangeOrder()
+	name, total := def.GetFieldByName("name"), def.GetFieldByName("total")
+	codes, lines := def.GetFieldByName("codes"), def.GetFieldByName("lines")
+	address, billing := def.GetFieldByName("address"), def.GetFieldByName("billing")
+	lineDef := def.Registry.GetMessageDef(lines.DataType)
+	addressDef := def.Registry.GetMessageDef(address.DataType)
+
+	e := def.NewEntity()
+	codes.Reserve(e, 3)
+	lines.Reserve(e, 2)
+	lines.SetReferenceAt(e, 1, FromEntity(lineDef.NewEntity()))
+	address.SetReference(e, FromEntity(addressDef.NewEntity()))
+
+	tr := New(e, def)
+	assert.False(t, tr.Modified())
+	assert.Nil(t, tr.FieldMask())
+
+	// The changes made directly with the fields are not recorded.
+	total.SetPrimitive(e, FromFloat64(1))
+	assert.False(t, tr.Modified())
+
+	tr.SetReference(name, FromString("Alice"))
+	tr.SetPrimitiveAt(codes, 2, FromInt64(5))
+	tr.SetPrimitiveAt(codes, 0, FromInt64(1))
+	tr.Nested(address).SetReference(addressDef.GetFieldByName("city"), FromString("Oslo"))
+	tr.NestedAt(lines, 1).SetPrimitive(lineDef.GetFieldByName("quantity"), FromInt32(2))
+	assert.True(t, tr.Modified())
+	assert.True(t, tr.IsModified(address))
+	assert.False(t, tr.IsModified(total))
+	assert.Equal(t, []string{"name", "codes", "lines", "address.city"}, tr.FieldMask())
+	indices, all := tr.ModifiedIndices(codes)
+	assert.Equal(t, []int{0, 2}, indices)
+	assert.False(t, all)
+	indices, all = tr.ModifiedIndices(lines)
+	assert.Equal(t, []int{1}, indices)
+	assert.False(t, all)
+	assert.Equal(t, int64(5), codes.GetPrimitiveAt(e, 2).ToInt64())
+	assert.Equal(t, "Oslo", addressDef.GetFieldByName("city").GetReference(address.GetReference(e).Entity).ToString())
+
+	tr.Reset()
+	assert.False(t, tr.Modified())
+	assert.Nil(t, tr.FieldMask())
+
+	// The null nested entities are created along with the trackers, and the
+	// shifted items mark the whole field as modified.
+	tr.Nested(billing).SetPrimitive(addressDef.GetFieldByName("zip"), FromUint32(150))
+	assert.NotNil(t, billing.GetReference(e).Entity)
+	tr.Insert(codes, 1, 1)
+	n := tr.Reserve(lines, 1)
+	tr.NestedAt(lines, n)
+	assert.Equal(t, []string{"codes", "lines", "billing"}, tr.FieldMask())
+	indices, all = tr.ModifiedIndices(codes)
+	assert.Nil(t, indices)
+	assert.True(t, all)
+	indices, all = tr.ModifiedIndices(lines)
+	assert.Equal(t, []int{2}, indices)
+	assert.False(t, all)
+
+	// Replacing the nested entity discards the changes of its fields.
+	tr.Reset()
+	nested := tr.Nested(address)
+	nested.SetPrimitive(addressDef.GetFieldByName("zip"), FromUint32(1))
+	assert.Equal(t, []string{"address.zip"}, tr.FieldMask())
+	tr.SetReference(address, FromEntity(addressDef.NewEntity()))
+	assert.Equal(t, []string{"address"}, tr.FieldMask())
+	tr.Reset()
+	assert.True(t, nested != tr.Nested(address))
+	assert.False(t, tr.Modified())
+
+	// Replacing the repeated field detaches the trackers of its items.
+	item := tr.NestedAt(lines, 1)
+	tr.SetReference(lines, GetDefaultReference())
+	tr.Reset()
+	item.SetPrimitive(lineDef.GetFieldByName("quantity"), FromInt32(3))
+	assert.False(t, tr.Modified())
+	assert.Nil(t, tr.FieldMask())
+	indices, all = tr.ModifiedIndices(lines)
+	assert.Nil(t, indices)
+	assert.False(t, all)
+}
+
+func TestTrackerItems(t *testing.T) {
+	def := ArrangeOrder()
+	lines := def.GetFieldByName("lines")
+	lineDef := def.Registry.GetMessageDef(lines.DataType)
+	quantity, address := lineDef.GetFieldByName("quantity"), lineDef.GetFieldByName("address")
+	city := def.Registry.GetMessageDef(address.DataType).GetFieldByName("city")
+
+	e := def.NewEntity()
+	for i := 0; i < 3; i++ {
+		lines.SetReferenceAt(e, lines.Reserve(e, 1), FromEntity(lineDef.NewEntity()))
+	}
+	tr := New(e, def)
+
+	// The changes of the entities nested into the items mark the items as
+	// modified.
+	tr.NestedAt(lines, 1).Nested(address).SetReference(city, FromString("Oslo"))
+	assert.True(t, tr.Modified())
+	assert.Equal(t, []string{"lines"}, tr.FieldMask())
+	indices, all := tr.ModifiedIndices(lines)
+	assert.Equal(t, []int{1}, indices)
+	assert.False(t, all)
+
+	// The trackers of the items follow the items when they are shifted.
+	first, second, third := tr.NestedAt(lines, 0), tr.NestedAt(lines, 1), tr.NestedAt(lines, 2)
+	assert.True(t, second == tr.NestedAt(lines, 1))
+	tr.Insert(lines, 0, 1)
+	tr.Remove(lines, 1, 1)
+	tr.Reset()
+	second.SetPrimitive(quantity, FromInt32(2))
+	third.Nested(address).SetReference(city, FromString("Bergen"))
+	indices, all = tr.ModifiedIndices(lines)
+	assert.Equal(t, []int{1, 2}, indices)
+	assert.False(t, all)
+	assert.Equal(t, int32(2), quantity.GetPrimitive(lines.GetReferenceAt(e, 1).Entity).ToInt32())
+
+	// The trackers of the removed items no longer record their changes.
+	tr.Reset()
+	first.SetPrimitive(quantity, FromInt32(3))
+	assert.False(t, tr.Modified())
+}
@@ -0,0 +1,172 @@
+package validation
+
+import (
+	"time"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+// A value of the expression, the type of which cannot be told until the
+// expression is evaluated, like the one of a field of the nested entity, the
+// message definition of which hasn't been built yet.
+type unknownValue struct{}
+
+// Check resolves the fields the expression refers against the message
+// definition and checks whether the operators and the functions are applied to
+// the values of the right types, so the expression could be evaluated against
+// the entities of the message definition. The fields of the nested entities,
+// the message definitions of which haven't been built yet, are not checked.
+// If the check fails, the method returns an error of *ExpressionError type.
+func (x *Expression) Check(md *MessageDef) error {
+	_, err := x.root.check(md)
+	return err
+}
+
+// -----------------------------------------------------------------------------
+// Nodes
+
+// The check method of the nodes gets a sample value of the type the node
+// evaluates to, so the operators and the functions could be checked by
+// evaluating them against the sample values of their operands.
+
+func (n *literalNode) check(*MessageDef) (interface{}, error) { return n.value, nil }
+
+func (n *fieldNode) check(md *MessageDef) (interface{}, error) {
+	if n.target != nil {
+		v, err := n.target.check(md)
+		if err != nil || v == nil {
+			return v, err
+		}
+		switch v := v.(type) {
+		case unknownValue:
+			return v, nil
+		case entityValue:
+			md = v.md
+		default:
+			return nil, newError(n.start, "cannot get field %q of %s", n.name, typeName(v))
+		}
+	}
+	f, ok := md.TryGetFieldByName(n.name)
+	if !ok {
+		return nil, newError(n.start, "unknown field %q of %s", n.name, md.QualifiedName())
+	}
+	v := sampleValue(md, f)
+	if f.Repeated {
+		return []interface{}{v}, nil
+	}
+	return v, nil
+}
+
+func (n *indexNode) check(md *MessageDef) (interface{}, error) {
+	target, err := n.target.check(md)
+	if err != nil || target == nil {
+		return target, err
+	}
+	index, err := n.index.check(md)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := index.(unknownValue); !ok {
+		if _, ok := index.(int64); !ok {
+			return nil, newError(n.index.pos(), "index must be int, but got %s", typeName(index))
+		}
+	}
+	switch target := target.(type) {
+	case unknownValue:
+		return target, nil
+	case []interface{}:
+		return target[0], nil
+	}
+	return nil, newError(n.start, "cannot index %s", typeName(target))
+}
+
+func (n *unaryNode) check(md *MessageDef) (interface{}, error) {
+	v, err := n.operand.check(md)
+	if err != nil || isUnknown(v) {
+		return v, err
+	}
+	return (&unaryNode{op: n.op, start: n.start, operand: sampleNode(v, n.operand)}).eval(nil)
+}
+
+func (n *binaryNode) check(md *MessageDef) (interface{}, error) {
+	left, err := n.left.check(md)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.check(md)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		for _, operand := range []struct {
+			v interface{}
+			n node
+		}{{left, n.left}, {right, n.right}} {
+			if _, ok := operand.v.(bool); !ok && !isUnknown(operand.v) {
+				return nil, newError(operand.n.pos(),
+					"operand of %s must be bool, but got %s", n.op, typeName(operand.v))
+			}
+		}
+		return true, nil
+	}
+	if isUnknown(left) || isUnknown(right) {
+		return unknownValue{}, nil
+	}
+	sample := &binaryNode{op: n.op, start: n.start, left: sampleNode(left, n.left), right: sampleNode(right, n.right)}
+	return sample.eval(nil)
+}
+
+func (n *callNode) check(md *MessageDef) (interface{}, error) {
+	v, err := n.arg.check(md)
+	if err != nil {
+		return nil, err
+	}
+	if n.name == "has" || isUnknown(v) {
+		return true, nil
+	}
+	return (&callNode{name: n.name, fn: n.fn, arg: sampleNode(v, n.arg), start: n.start}).eval(nil)
+}
+
+// -----------------------------------------------------------------------------
+// Sample values
+
+// sampleValue gets a sample of the value of the expression, which represents a
+// single value of the field.
+func sampleValue(md *MessageDef, f *MessageFieldDef) interface{} {
+	switch f.DataType {
+	case DtInt32, DtInt64, DtUint32, DtUint64:
+		return int64(1)
+	case DtFloat32, DtFloat64:
+		return float64(1)
+	case DtBool:
+		return true
+	case DtString:
+		return ""
+	case DtBytes:
+		return []byte{}
+	}
+	def := md.Registry.GetMessageDef(f.DataType)
+	switch {
+	case def == nil:
+		return unknownValue{}
+	case def.IsWellKnown(WellKnownTimestamp):
+		return time.Time{}
+	case def.IsWellKnown(WellKnownDuration):
+		return time.Duration(1)
+	case def.Namespace == WellKnownNamespace && wrapperNames[def.Name]:
+		return sampleValue(def, def.GetField(protobuf.TagWrapperValue))
+	}
+	return entityValue{md: def}
+}
+
+// sampleNode gets the literal node, which evaluates to the sample value at the
+// position of the node, so the errors are reported at the same positions.
+func sampleNode(v interface{}, n node) node {
+	return &literalNode{value: v, start: n.pos()}
+}
+
+func isUnknown(v interface{}) bool {
+	_, ok := v.(unknownValue)
+	return ok
+}
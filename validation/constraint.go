@@ -0,0 +1,394 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"unicode/utf8"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// The constraints of a single field, which are stored as an extension
+	// of the field definition.
+	constraints struct {
+		required bool
+		// The limits of the number of items of a repeated field, or
+		// negative values if there are no limits.
+		minItems, maxItems int
+		// The checks applied to each of the values of the field.
+		checks []check
+	}
+
+	// Checks a single value of the field, returning the description of
+	// the violation or an empty string if the value is valid.
+	check func(f *MessageFieldDef, p Primitive, r Reference) string
+)
+
+var marker ExtensionMarker
+
+func init() {
+	marker = RegisterExtension()
+}
+
+// -----------------------------------------------------------------------------
+// Extensions
+
+// Required produces a function to extend the message field definition,
+// indicating that the field must be set. The reference fields must not be
+// null, the repeated fields must have at least one item, and the primitive
+// fields must have a value other than zero or false.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func Required() func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		ensureConstraints(f).required = true
+	}
+}
+
+// Min produces a function to extend the message field definition of a numeric
+// type, indicating that the values must not be less than specified one. The
+// bound must be a number of a Go integer type for the fields of integer types,
+// so the values are compared exactly, while the fields of floating point types
+// accept the numbers of any numeric type.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func Min(min interface{}) func(*MessageFieldDef) {
+	return withBound(min, func(c int) bool { return c < 0 }, "must be at least %v")
+}
+
+// Max produces a function to extend the message field definition of a numeric
+// type, indicating that the values must not be greater than specified one. See
+// Min for the types of the bound.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func Max(max interface{}) func(*MessageFieldDef) {
+	return withBound(max, func(c int) bool { return c > 0 }, "must be at most %v")
+}
+
+// MinLength produces a function to extend the message field definition of the
+// string or bytes type, indicating that the values must have at least
+// specified number of characters or bytes respectively.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func MinLength(n int) func(*MessageFieldDef) {
+	return withCheck(isText, func(f *MessageFieldDef, _ Primitive, r Reference) string {
+		if getLength(f.DataType, r) < n {
+			return fmt.Sprintf("length must be at least %d", n)
+		}
+		return ""
+	})
+}
+
+// MaxLength produces a function to extend the message field definition of the
+// string or bytes type, indicating that the values must have at most specified
+// number of characters or bytes respectively.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func MaxLength(n int) func(*MessageFieldDef) {
+	return withCheck(isText, func(f *MessageFieldDef, _ Primitive, r Reference) string {
+		if getLength(f.DataType, r) > n {
+			return fmt.Sprintf("length must be at most %d", n)
+		}
+		return ""
+	})
+}
+
+// Pattern produces a function to extend the message field definition of the
+// string type, indicating that the values must match the regular expression.
+// If the expression cannot be parsed, the function will panic.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func Pattern(expr string) func(*MessageFieldDef) {
+	re := regexp.MustCompile(expr)
+	return withCheck(
+		func(dt DataType) bool { return dt == DtString },
+		func(_ *MessageFieldDef, _ Primitive, r Reference) string {
+			if !re.MatchString(r.ToString()) {
+				return fmt.Sprintf("must match %q", expr)
+			}
+			return ""
+		})
+}
+
+// In produces a function to extend the message field definition of a numeric,
+// string or boolean type, indicating that the values must be equal to one of
+// specified values. The values must be of Go types, which correspond to the
+// data type of the field, though the numbers of any numeric type are accepted.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func In(values ...interface{}) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		set := make(map[interface{}]struct{}, len(values))
+		for _, v := range values {
+			key, ok := normalize(f.DataType, v)
+			if !ok {
+				panic(fmt.Sprintf("value %v of type %T cannot be compared with data type %d", v, v, f.DataType))
+			}
+			set[key] = struct{}{}
+		}
+		withCheck(
+			func(dt DataType) bool { return !dt.IsEntity() && dt != DtBytes },
+			func(f *MessageFieldDef, p Primitive, r Reference) string {
+				if _, ok := set[getKey(f.DataType, p, r)]; !ok {
+					return fmt.Sprintf("must be one of %v", values)
+				}
+				return ""
+			})(f)
+	}
+}
+
+// MinItems produces a function to extend the message field definition of a
+// repeated field, indicating that the field must have at least specified
+// number of items.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func MinItems(n int) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		mustBeRepeated(f)
+		ensureConstraints(f).minItems = n
+	}
+}
+
+// MaxItems produces a function to extend the message field definition of a
+// repeated field, indicating that the field must have at most specified number
+// of items.
+//
+// Use this function with the ExtendField method of MessageDefBuilder.
+func MaxItems(n int) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		mustBeRepeated(f)
+		ensureConstraints(f).maxItems = n
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Helper functions
+
+func withCheck(supports func(DataType) bool, c check) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		if !supports(f.DataType) {
+			panic(fmt.Sprintf("field is of an invalid type %d", f.DataType))
+		}
+		cs := ensureConstraints(f)
+		cs.checks = append(cs.checks, c)
+	}
+}
+
+// withBound produces a function to extend the message field definition of a
+// numeric type with the check, which reports a violation if the result of the
+// comparison of the value with the bound is rejected.
+func withBound(bound interface{}, rejects func(c int) bool, format string) func(*MessageFieldDef) {
+	return func(f *MessageFieldDef) {
+		if !isNumeric(f.DataType) {
+			panic(fmt.Sprintf("field is of an invalid type %d", f.DataType))
+		}
+		compare, ok := newComparer(f.DataType, bound)
+		if !ok {
+			panic(fmt.Sprintf("bound %v of type %T cannot be compared with data type %d", bound, bound, f.DataType))
+		}
+		withCheck(isNumeric, func(f *MessageFieldDef, p Primitive, _ Reference) string {
+			if rejects(compare(p)) {
+				return fmt.Sprintf(format, bound)
+			}
+			return ""
+		})(f)
+	}
+}
+
+func mustBeRepeated(f *MessageFieldDef) {
+	if !f.Repeated {
+		panic(fmt.Sprintf("field %q is not repeated", f.Name))
+	}
+}
+
+func tryGetConstraints(f *MessageFieldDef) (*constraints, bool) {
+	if cs, ok := f.TryGetExtension(marker); ok {
+		return cs.(*constraints), true
+	}
+	return nil, false
+}
+
+func ensureConstraints(f *MessageFieldDef) *constraints {
+	cs, ok := f.TryGetExtension(marker)
+	if !ok {
+		cs = &constraints{minItems: -1, maxItems: -1}
+		f.SetExtension(marker, cs)
+	}
+	return cs.(*constraints)
+}
+
+func isNumeric(dt DataType) bool {
+	switch dt {
+	case DtInt32, DtInt64, DtUint32, DtUint64, DtFloat32, DtFloat64:
+		return true
+	default:
+		return false
+	}
+}
+
+func isText(dt DataType) bool { return dt == DtString || dt == DtBytes }
+
+func toFloat(dt DataType, p Primitive) float64 {
+	switch dt {
+	case DtInt32:
+		return float64(p.ToInt32())
+	case DtInt64:
+		return float64(p.ToInt64())
+	case DtUint32:
+		return float64(p.ToUint32())
+	case DtUint64:
+		return float64(p.ToUint64())
+	case DtFloat32:
+		return float64(p.ToFloat32())
+	default:
+		return p.ToFloat64()
+	}
+}
+
+// newComparer produces a function, which compares the values of the data type
+// with the bound, returning a negative number, zero or a positive number if
+// the value is less than, equal to or greater than the bound respectively. The
+// integer values are compared with the integer bounds by their signs and
+// magnitudes, so no precision is lost.
+func newComparer(dt DataType, bound interface{}) (func(Primitive) int, bool) {
+	if dt == DtFloat32 || dt == DtFloat64 {
+		key, ok := normalize(dt, bound)
+		if !ok {
+			return nil, false
+		}
+		b := key.(float64)
+		return func(p Primitive) int {
+			switch v := toFloat(dt, p); {
+			case v < b:
+				return -1
+			case v > b:
+				return 1
+			default:
+				return 0
+			}
+		}, true
+	}
+	var bneg bool
+	var bmag uint64
+	rv := reflect.ValueOf(bound)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bneg, bmag = signMagnitude(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		bmag = rv.Uint()
+	default:
+		return nil, false
+	}
+	return func(p Primitive) int {
+		var neg bool
+		var mag uint64
+		switch dt {
+		case DtInt32:
+			neg, mag = signMagnitude(int64(p.ToInt32()))
+		case DtInt64:
+			neg, mag = signMagnitude(p.ToInt64())
+		case DtUint32:
+			mag = uint64(p.ToUint32())
+		default:
+			mag = p.ToUint64()
+		}
+		switch {
+		case neg != bneg && neg:
+			return -1
+		case neg != bneg:
+			return 1
+		case mag == bmag:
+			return 0
+		case (mag < bmag) != neg:
+			return -1
+		default:
+			return 1
+		}
+	}, true
+}
+
+func signMagnitude(x int64) (neg bool, mag uint64) {
+	if x < 0 {
+		return true, -uint64(x)
+	}
+	return false, uint64(x)
+}
+
+func getLength(dt DataType, r Reference) int {
+	if dt == DtString {
+		return utf8.RuneCount(r.ToBytes())
+	}
+	return len(r.ToBytes())
+}
+
+// getKey gets the value of the field in the form, which is produced by the
+// normalize function.
+func getKey(dt DataType, p Primitive, r Reference) interface{} {
+	switch dt {
+	case DtInt32:
+		return int64(p.ToInt32())
+	case DtInt64:
+		return p.ToInt64()
+	case DtUint32:
+		return uint64(p.ToUint32())
+	case DtUint64:
+		return p.ToUint64()
+	case DtFloat32:
+		return float64(p.ToFloat32())
+	case DtFloat64:
+		return p.ToFloat64()
+	case DtBool:
+		return p.ToBool()
+	default:
+		return r.ToString()
+	}
+}
+
+// normalize converts the Go value to the type, which corresponds to the data
+// type of the field, so the values could be compared.
+func normalize(dt DataType, v interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	switch dt {
+	case DtInt32, DtInt64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(rv.Uint()), true
+		}
+	case DtUint32, DtUint64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return uint64(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), true
+		}
+	case DtFloat32, DtFloat64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(rv.Uint()), true
+		case reflect.Float32:
+			// The values are compared as float32, which is how they
+			// are stored.
+			return float64(float32(rv.Float())), true
+		case reflect.Float64:
+			if dt == DtFloat32 {
+				return float64(float32(rv.Float())), true
+			}
+			return rv.Float(), true
+		}
+	case DtBool:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), true
+		}
+	case DtString:
+		if rv.Kind() == reflect.String {
+			return rv.String(), true
+		}
+	}
+	return nil, false
+}
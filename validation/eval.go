@@ -0,0 +1,515 @@
+package validation
+
+import (
+	"bytes"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/lexer"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+type (
+	// The entity the expression is evaluated against.
+	evalContext struct {
+		e  *Entity
+		md *MessageDef
+	}
+
+	// A value of the expression, which represents a nested entity.
+	entityValue struct {
+		e  *Entity
+		md *MessageDef
+	}
+
+	// A function, which can be called from the expression.
+	function func(ec *evalContext, n *callNode) (interface{}, error)
+
+	literalNode struct {
+		value interface{}
+		start int
+	}
+
+	// A field of the entity, which is either the entity the expression is
+	// evaluated against or the value of the target node.
+	fieldNode struct {
+		target node
+		name   string
+		start  int
+	}
+
+	indexNode struct {
+		target, index node
+		start         int
+	}
+
+	unaryNode struct {
+		op      string
+		operand node
+		start   int
+	}
+
+	binaryNode struct {
+		op          string
+		left, right node
+		start       int
+	}
+
+	callNode struct {
+		name  string
+		fn    function
+		arg   node
+		start int
+	}
+)
+
+// The names of the well-known wrapper types, which are represented by the
+// values they wrap.
+var wrapperNames = map[string]bool{
+	WellKnownDoubleValue: true,
+	WellKnownFloatValue:  true,
+	WellKnownInt64Value:  true,
+	WellKnownUInt64Value: true,
+	WellKnownInt32Value:  true,
+	WellKnownUInt32Value: true,
+	WellKnownBoolValue:   true,
+	WellKnownStringValue: true,
+	WellKnownBytesValue:  true,
+}
+
+// Evaluate evaluates the expression against the entity of the message
+// definition. The result is either nil, or a value of one of the bool, int64,
+// float64, string, []byte, time.Time or time.Duration types, or an *Entity,
+// or a []interface{} of any of them. If the expression cannot be evaluated,
+// the method returns an error of *ExpressionError type.
+func (x *Expression) Evaluate(e *Entity, md *MessageDef) (interface{}, error) {
+	v, err := x.root.eval(&evalContext{e: e, md: md})
+	if err != nil {
+		return nil, err
+	}
+	return export(v), nil
+}
+
+func export(v interface{}) interface{} {
+	switch v := v.(type) {
+	case entityValue:
+		return v.e
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = export(item)
+		}
+		return items
+	}
+	return v
+}
+
+// -----------------------------------------------------------------------------
+// Nodes
+
+func (n *literalNode) eval(*evalContext) (interface{}, error) { return n.value, nil }
+func (n *literalNode) pos() int                               { return n.start }
+
+func (n *fieldNode) eval(ec *evalContext) (interface{}, error) {
+	ev, f, err := n.resolve(ec)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	if !f.Repeated {
+		return getFieldValue(ev.e, ev.md, f), nil
+	}
+	items := make([]interface{}, f.Len(ev.e))
+	for i := range items {
+		if f.DataType.IsRefType() {
+			items[i] = getValue(ev.md, f, GetDefaultPrimitive(), f.GetReferenceAt(ev.e, i))
+		} else {
+			items[i] = getValue(ev.md, f, f.GetPrimitiveAt(ev.e, i), GetDefaultReference())
+		}
+	}
+	return items, nil
+}
+
+func (n *fieldNode) pos() int { return n.start }
+
+// resolve gets the entity, which contains the field, along with the field
+// definition. If the entity is null, the field definition is nil.
+func (n *fieldNode) resolve(ec *evalContext) (ev entityValue, f *MessageFieldDef, err error) {
+	ev = entityValue{e: ec.e, md: ec.md}
+	if n.target != nil {
+		var v interface{}
+		if v, err = n.target.eval(ec); err != nil || v == nil {
+			return
+		}
+		var ok bool
+		if ev, ok = v.(entityValue); !ok {
+			err = newError(n.start, "cannot get field %q of %s", n.name, typeName(v))
+			return
+		}
+	}
+	var ok bool
+	if f, ok = ev.md.TryGetFieldByName(n.name); !ok {
+		err = newError(n.start, "unknown field %q of %s", n.name, ev.md.QualifiedName())
+	}
+	return
+}
+
+func (n *indexNode) eval(ec *evalContext) (interface{}, error) {
+	target, err := n.target.eval(ec)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	items, ok := target.([]interface{})
+	if !ok {
+		return nil, newError(n.start, "cannot index %s", typeName(target))
+	}
+	index, err := n.index.eval(ec)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := index.(int64)
+	if !ok {
+		return nil, newError(n.index.pos(), "index must be int, but got %s", typeName(index))
+	}
+	if i < 0 || i >= int64(len(items)) {
+		return nil, newError(n.index.pos(), "index %d is out of range of list of %d items", i, len(items))
+	}
+	return items[i], nil
+}
+
+func (n *indexNode) pos() int { return n.target.pos() }
+
+func (n *unaryNode) eval(ec *evalContext) (interface{}, error) {
+	v, err := n.operand.eval(ec)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if n.op == "!" {
+			return !v, nil
+		}
+	case int64:
+		if n.op == "-" {
+			return -v, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -v, nil
+		}
+	case time.Duration:
+		if n.op == "-" {
+			return -v, nil
+		}
+	}
+	return nil, newError(n.start, "operator %s is not defined for %s", n.op, typeName(v))
+}
+
+func (n *unaryNode) pos() int { return n.start }
+
+func (n *binaryNode) eval(ec *evalContext) (interface{}, error) {
+	left, err := n.left.eval(ec)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, newError(n.left.pos(), "operand of %s must be bool, but got %s", n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(ec)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, newError(n.right.pos(), "operand of %s must be bool, but got %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+	right, err := n.right.eval(ec)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==", "!=":
+		eq, ok := equal(left, right)
+		if !ok {
+			return nil, n.undefined(left, right)
+		}
+		return eq == (n.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		c, ok := compare(left, right)
+		if !ok {
+			return nil, n.undefined(left, right)
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+	return n.arithmetic(left, right)
+}
+
+func (n *binaryNode) pos() int { return n.start }
+
+func (n *binaryNode) arithmetic(left, right interface{}) (interface{}, error) {
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "*":
+				return l * r, nil
+			}
+			if r == 0 {
+				return nil, newError(n.start, "division by zero")
+			}
+			if n.op == "/" {
+				return l / r, nil
+			}
+			return l % r, nil
+		}
+	}
+	if l, ok := toNumber(left); ok {
+		if r, ok := toNumber(right); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "*":
+				return l * r, nil
+			case "/":
+				return l / r, nil
+			}
+		}
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok && n.op == "+" {
+			return l + r, nil
+		}
+	case time.Time:
+		switch r := right.(type) {
+		case time.Time:
+			if n.op == "-" {
+				return l.Sub(r), nil
+			}
+		case time.Duration:
+			switch n.op {
+			case "+":
+				return l.Add(r), nil
+			case "-":
+				return l.Add(-r), nil
+			}
+		}
+	case time.Duration:
+		if r, ok := right.(time.Duration); ok {
+			switch n.op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			}
+		}
+	}
+	return nil, n.undefined(left, right)
+}
+
+func (n *binaryNode) undefined(left, right interface{}) error {
+	return newError(n.start, "operator %s is not defined for %s and %s", n.op, typeName(left), typeName(right))
+}
+
+func (n *callNode) eval(ec *evalContext) (interface{}, error) { return n.fn(ec, n) }
+func (n *callNode) pos() int                                  { return n.start }
+
+// -----------------------------------------------------------------------------
+// Functions
+
+func evalSize(ec *evalContext, n *callNode) (interface{}, error) {
+	v, err := n.arg.eval(ec)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return int64(0), nil
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case []byte:
+		return int64(len(v)), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	}
+	return nil, newError(n.arg.pos(), "size() is not defined for %s", typeName(v))
+}
+
+func evalHas(ec *evalContext, n *callNode) (interface{}, error) {
+	ev, f, err := n.arg.(*fieldNode).resolve(ec)
+	if err != nil || f == nil {
+		return false, err
+	}
+	switch {
+	case f.Repeated:
+		return f.Len(ev.e) > 0, nil
+	case f.DataType.IsRefType():
+		return f.GetReference(ev.e).Entity != nil, nil
+	default:
+		return f.GetPrimitive(ev.e) != GetDefaultPrimitive(), nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Values
+
+// getFieldValue gets the value of the expression, which represents the value of
+// the field, which is not repeated.
+func getFieldValue(e *Entity, md *MessageDef, f *MessageFieldDef) interface{} {
+	if f.DataType.IsRefType() {
+		return getValue(md, f, GetDefaultPrimitive(), f.GetReference(e))
+	}
+	return getValue(md, f, f.GetPrimitive(e), GetDefaultReference())
+}
+
+// getValue gets the value of the expression, which represents a single value of
+// the field.
+func getValue(md *MessageDef, f *MessageFieldDef, p Primitive, r Reference) interface{} {
+	switch f.DataType {
+	case DtInt32:
+		return int64(p.ToInt32())
+	case DtInt64:
+		return p.ToInt64()
+	case DtUint32:
+		return int64(p.ToUint32())
+	case DtUint64:
+		if v := p.ToUint64(); v <= 1<<63-1 {
+			return int64(v)
+		}
+		return float64(p.ToUint64())
+	case DtFloat32:
+		return float64(p.ToFloat32())
+	case DtFloat64:
+		return p.ToFloat64()
+	case DtBool:
+		return p.ToBool()
+	case DtString:
+		return r.ToString()
+	case DtBytes:
+		return r.ToBytes()
+	}
+	if r.Entity == nil {
+		return nil
+	}
+	def := md.Registry.GetMessageDef(f.DataType)
+	switch {
+	case def.IsWellKnown(WellKnownTimestamp):
+		return protobuf.ToTime(r.Entity, def)
+	case def.IsWellKnown(WellKnownDuration):
+		return protobuf.ToDuration(r.Entity, def)
+	case def.Namespace == WellKnownNamespace && wrapperNames[def.Name]:
+		return getFieldValue(r.Entity, def, def.GetField(protobuf.TagWrapperValue))
+	}
+	return entityValue{e: r.Entity, md: def}
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal gets a value indicating whether the values are equal, and whether the
+// values can be compared at all. The null can be compared with any value.
+func equal(left, right interface{}) (eq bool, ok bool) {
+	if left == nil || right == nil {
+		return left == right, true
+	}
+	switch l := left.(type) {
+	case bool:
+		if r, ok := right.(bool); ok {
+			return l == r, true
+		}
+	case []byte:
+		if r, ok := right.([]byte); ok {
+			return bytes.Equal(l, r), true
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return l.Equal(r), true
+		}
+	}
+	c, ok := compare(left, right)
+	return c == 0, ok
+}
+
+// compare gets the sign of the difference between the values, and whether the
+// values can be ordered at all.
+func compare(left, right interface{}) (c int, ok bool) {
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			return lexer.Sign(l < r, l > r), true
+		}
+	}
+	if l, ok := toNumber(left); ok {
+		if r, ok := toNumber(right); ok {
+			return lexer.Sign(l < r, l > r), true
+		}
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return lexer.Sign(l < r, l > r), true
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return lexer.Sign(l.Before(r), l.After(r)), true
+		}
+	case time.Duration:
+		if r, ok := right.(time.Duration); ok {
+			return lexer.Sign(l < r, l > r), true
+		}
+	}
+	return 0, false
+}
+
+func typeName(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case time.Time:
+		return "timestamp"
+	case time.Duration:
+		return "duration"
+	case []interface{}:
+		return "list"
+	case entityValue:
+		return v.md.QualifiedName()
+	}
+	return "unknown"
+}
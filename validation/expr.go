@@ -0,0 +1,286 @@
+package validation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/internal/lexer"
+)
+
+type (
+	// Represents a compiled expression, which is evaluated against an
+	// entity. See the CompileExpression function for the syntax of the
+	// expressions.
+	Expression struct {
+		src  string
+		root node
+	}
+
+	// An error, which occurred when compiling or evaluating the expression.
+	ExpressionError struct {
+		Pos     int    // The position in the expression, starting from 1
+		Message string // The description of the error
+	}
+
+	// A node of the syntax tree of the expression.
+	node interface {
+		eval(ec *evalContext) (interface{}, error)
+		// check checks the node against the message definition of
+		// the entity, which the expression is evaluated against.
+		check(md *MessageDef) (interface{}, error)
+		// pos gets the position of the node in the expression, which
+		// is reported by the errors.
+		pos() int
+	}
+
+	// Produces the syntax tree of the expression from its tokens.
+	parser struct {
+		*lexer.Scanner
+	}
+)
+
+// The operators ordered so that the longer ones go first.
+var operators = []string{
+	"||", "&&", "==", "!=", "<=", ">=",
+	"!", "<", ">", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ",",
+}
+
+// CompileExpression compiles the expression, which can be evaluated then
+// against the entities. The expressions are composed of the following:
+//
+//	42, 1.5e3, "text", true, false, null literals
+//	name, nested.name, items[0].name     fields of the entity
+//	! - * / % + -                        arithmetic and negation
+//	== != < <= > >=                      comparisons
+//	&& ||                                boolean logic
+//	size(x)                              the length of a string, bytes or list
+//	has(field)                           whether the field has been set
+//
+// The fields of integer types are represented by 64-bit integers, and the
+// floating point ones by 64-bit floats, while the integers and floats may be
+// compared and combined with each other. The repeated fields are represented
+// by lists, and the fields of well-known Timestamp, Duration and wrapper types
+// by the values they represent, so the timestamps and durations may be
+// compared and subtracted. The fields of null entities evaluate to null.
+func CompileExpression(src string) (*Expression, error) {
+	scanner, err := lexer.NewScanner(src, operators, "end of expression")
+	if err != nil {
+		return nil, toExpressionError(err)
+	}
+	p := parser{scanner}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, toExpressionError(err)
+	}
+	if t := p.Peek(); t.Kind != lexer.Eof {
+		return nil, toExpressionError(p.Unexpected(t))
+	}
+	return &Expression{src: src, root: root}, nil
+}
+
+// MustCompileExpression compiles the expression just like CompileExpression,
+// but panics if the expression cannot be compiled.
+func MustCompileExpression(src string) *Expression {
+	x, err := CompileExpression(src)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func (x *Expression) String() string { return x.src }
+
+func (err *ExpressionError) Error() string {
+	return fmt.Sprintf("dymessage: (%d): %s", err.Pos, err.Message)
+}
+
+func newError(pos int, format string, a ...interface{}) *ExpressionError {
+	return toExpressionError(lexer.NewError(pos, format, a...))
+}
+
+// toExpressionError converts the error of the lexer to the error, which is
+// reported by the package.
+func toExpressionError(err error) *ExpressionError {
+	switch err := err.(type) {
+	case *ExpressionError:
+		return err
+	case *lexer.Error:
+		return &ExpressionError{Pos: err.Pos, Message: err.Message}
+	}
+	panic(fmt.Sprintf("unexpected error %v", err))
+}
+
+// -----------------------------------------------------------------------------
+// Parser
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.TryOperator("==", "!=", "<", "<=", ">", ">="); ok {
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: t.Text, start: t.Start, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseBinary(next func() (node, error), ops ...string) (node, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.TryOperator(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.Text, start: t.Start, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if t, ok := p.TryOperator("!", "-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.Text, start: t.Start, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (n node, err error) {
+	if n, err = p.parsePrimary(); err != nil {
+		return
+	}
+	for {
+		if t, ok := p.TryOperator("."); ok {
+			name := p.Next()
+			if name.Kind != lexer.Ident {
+				return nil, p.Unexpected(name)
+			}
+			n = &fieldNode{target: n, name: name.Text, start: t.Start}
+		} else if t, ok := p.TryOperator("["); ok {
+			var index node
+			if index, err = p.parseOr(); err != nil {
+				return
+			}
+			if err = p.AcceptOperator("]"); err != nil {
+				return
+			}
+			n = &indexNode{target: n, index: index, start: t.Start}
+		} else {
+			return
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.Next()
+	switch t.Kind {
+	case lexer.Number:
+		return parseNumber(t)
+	case lexer.String:
+		s, err := strconv.Unquote(t.Text)
+		if err != nil {
+			return nil, newError(t.Start, "invalid string %s", t.Text)
+		}
+		return &literalNode{value: s, start: t.Start}, nil
+	case lexer.Ident:
+		switch t.Text {
+		case "true", "false":
+			return &literalNode{value: t.Text == "true", start: t.Start}, nil
+		case "null":
+			return &literalNode{value: nil, start: t.Start}, nil
+		}
+		if _, ok := p.TryOperator("("); ok {
+			return p.parseCall(t)
+		}
+		return &fieldNode{name: t.Text, start: t.Start}, nil
+	case lexer.Operator:
+		if t.Text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.AcceptOperator(")")
+		}
+	}
+	return nil, p.Unexpected(t)
+}
+
+func (p *parser) parseCall(name lexer.Token) (node, error) {
+	var args []node
+	if _, ok := p.TryOperator(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.TryOperator(","); !ok {
+				break
+			}
+		}
+		if err := p.AcceptOperator(")"); err != nil {
+			return nil, err
+		}
+	}
+	var fn function
+	switch name.Text {
+	case "size":
+		fn = evalSize
+	case "has":
+		if len(args) == 1 {
+			if _, ok := args[0].(*fieldNode); !ok {
+				return nil, newError(args[0].pos(), "argument of has() must be a field")
+			}
+		}
+		fn = evalHas
+	default:
+		return nil, newError(name.Start, "unknown function %s()", name.Text)
+	}
+	if len(args) != 1 {
+		return nil, newError(name.Start, "%s() expects 1 argument, but got %d", name.Text, len(args))
+	}
+	return &callNode{name: name.Text, fn: fn, arg: args[0], start: name.Start}, nil
+}
+
+func parseNumber(t lexer.Token) (node, error) {
+	if strings.IndexByte(t.Text, '.') < 0 {
+		if v, err := strconv.ParseInt(t.Text, 10, 64); err == nil {
+			return &literalNode{value: v, start: t.Start}, nil
+		}
+	}
+	v, err := strconv.ParseFloat(t.Text, 64)
+	if err != nil {
+		return nil, newError(t.Start, "invalid number %s", t.Text)
+	}
+	return &literalNode{value: v, start: t.Start}, nil
+}
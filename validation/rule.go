@@ -0,0 +1,73 @@
+package validation
+
+import (
+	"errors"
+
+	. "github.com/umk/go-dymessage"
+)
+
+// A rule of the message definition, which must hold for its entities.
+type rule struct {
+	x       *Expression
+	message string
+}
+
+var rulesMarker ExtensionMarker
+
+func init() {
+	rulesMarker = RegisterExtension()
+}
+
+// Rule produces a function to extend the message definition with a rule, which
+// is an expression that must evaluate to true for each of the entities of the
+// message definition, like "!has(end_time) || end_time > start_time". See the
+// CompileExpression function for the syntax of the expressions. The message
+// describes the violation of the rule, and defaults to the expression itself.
+// If the expression cannot be compiled, or its fields cannot be resolved
+// against the message definition, or it doesn't evaluate to bool, the function
+// will panic. The rule must be added after the fields, which it refers.
+//
+// Use this function with the ExtendMessage method of MessageDefBuilder.
+func Rule(expr, message string) func(*MessageDef) {
+	x := MustCompileExpression(expr)
+	if message == "" {
+		message = "must satisfy " + expr
+	}
+	return func(md *MessageDef) {
+		v, err := x.root.check(md)
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := v.(bool); !ok && !isUnknown(v) {
+			panic(newError(x.root.pos(), "rule must evaluate to bool, but got %s", typeName(v)))
+		}
+		rules, _ := md.TryGetExtension(rulesMarker)
+		rs, _ := rules.([]rule)
+		md.SetExtension(rulesMarker, append(rs, rule{x: x, message: message}))
+	}
+}
+
+// validateRules evaluates the rules of the message definition against the
+// entity, reporting the violated rules and the rules, which could not be
+// evaluated.
+func (vs *Violations) validateRules(e *Entity, md *MessageDef, path string) {
+	rules, ok := md.TryGetExtension(rulesMarker)
+	if !ok {
+		return
+	}
+	for _, r := range rules.([]rule) {
+		v, err := r.x.Evaluate(e, md)
+		if err != nil {
+			var xerr *ExpressionError
+			if errors.As(err, &xerr) {
+				vs.add(path, "cannot evaluate "+r.x.String()+": "+xerr.Message)
+			} else {
+				vs.add(path, "cannot evaluate "+r.x.String()+": "+err.Error())
+			}
+		} else if result, ok := v.(bool); !ok {
+			vs.add(path, "rule "+r.x.String()+" must evaluate to bool, but got "+typeName(v))
+		} else if !result {
+			vs.add(path, r.message)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package validation checks the entities against the constraints, which are
+// attached to the fields of the message definitions:
+//
+//	mb.WithField("Name", 1, DtString).
+//		ExtendField(validation.Required()).
+//		ExtendField(validation.MaxLength(64))
+//
+// The message definitions may also be extended with the rules, which involve
+// several fields, using the expressions:
+//
+//	mb.ExtendMessage(validation.Rule("end_time > start_time", "must end after start"))
+//
+// The Validate function reports all of the violations found in the entity
+// along with the paths to the fields. The nested entities are validated
+// recursively, and the values of the fields of logical types are validated by
+// the logical types. The function may be provided to the WithValidation
+// options of the protobuf and json packages to validate the entities right
+// after they are decoded.
+package validation
+
+import (
+	"strconv"
+	"strings"
+
+	. "github.com/umk/go-dymessage"
+)
+
+type (
+	// Describes a single violation of the constraints of a field.
+	Violation struct {
+		Path    string // The path to the field, like "Items[2].Name", or an empty string for the root
+		Message string // The description of the violation
+	}
+
+	// An error returned by the Validate function, which contains all of
+	// the violations found in the entity.
+	Violations []Violation
+)
+
+// Validate checks the entity against the constraints of the fields of the
+// message definition and its nested definitions. If any of the constraints
+// are violated, the function returns an error of Violations type.
+func Validate(e *Entity, md *MessageDef) error {
+	var vs Violations
+	vs.validate(e, md, "")
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs
+}
+
+func (vs Violations) Error() string {
+	var sb strings.Builder
+	sb.WriteString("dymessage: validation failed: ")
+	for i, v := range vs {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		if v.Path != "" {
+			sb.WriteString(v.Path)
+			sb.WriteString(": ")
+		}
+		sb.WriteString(v.Message)
+	}
+	return sb.String()
+}
+
+// -----------------------------------------------------------------------------
+// Implementation
+
+func (vs *Violations) validate(e *Entity, md *MessageDef, path string) {
+	for _, f := range md.Fields {
+		p := f.Name
+		if path != "" {
+			p = path + "." + f.Name
+		}
+		cs, _ := tryGetConstraints(f)
+		if f.Repeated {
+			n := f.Len(e)
+			if cs != nil {
+				vs.validateItems(n, cs, p)
+			}
+			for i := 0; i < n; i++ {
+				pi := p + "[" + strconv.Itoa(i) + "]"
+				if f.DataType.IsRefType() {
+					vs.validateReference(f.GetReferenceAt(e, i), md, f, cs, pi)
+				} else {
+					vs.validatePrimitive(f.GetPrimitiveAt(e, i), f, cs, pi)
+				}
+			}
+		} else if f.DataType.IsRefType() {
+			r := f.GetReference(e)
+			if r.Entity == nil {
+				if cs != nil && cs.required {
+					vs.add(p, "is required")
+				}
+				continue
+			}
+			vs.validateReference(r, md, f, cs, p)
+		} else {
+			value := f.GetPrimitive(e)
+			if value == GetDefaultPrimitive() && cs != nil && cs.required {
+				vs.add(p, "is required")
+				continue
+			}
+			vs.validatePrimitive(value, f, cs, p)
+		}
+	}
+	vs.validateRules(e, md, path)
+}
+
+func (vs *Violations) validateItems(n int, cs *constraints, path string) {
+	switch {
+	case cs.required && n == 0:
+		vs.add(path, "is required")
+	case cs.minItems >= 0 && n < cs.minItems:
+		vs.add(path, "must have at least "+strconv.Itoa(cs.minItems)+" items")
+	case cs.maxItems >= 0 && n > cs.maxItems:
+		vs.add(path, "must have at most "+strconv.Itoa(cs.maxItems)+" items")
+	}
+}
+
+func (vs *Violations) validateReference(
+	r Reference, md *MessageDef, f *MessageFieldDef, cs *constraints, path string) {
+	if r.Entity == nil {
+		// The null items of the repeated fields are not validated.
+		return
+	}
+	if f.DataType.IsEntity() {
+		vs.validate(r.ToEntity(), md.Registry.GetMessageDef(f.DataType), path)
+		return
+	}
+	if lt, ok := f.TryGetLogicalType(); ok {
+		if err := lt.Validate(ReferenceValue(f.DataType, r)); err != nil {
+			vs.add(path, err.Error())
+			return
+		}
+	}
+	if cs != nil {
+		vs.check(f, GetDefaultPrimitive(), r, cs, path)
+	}
+}
+
+func (vs *Violations) validatePrimitive(p Primitive, f *MessageFieldDef, cs *constraints, path string) {
+	if lt, ok := f.TryGetLogicalType(); ok {
+		if err := lt.Validate(PrimitiveValue(f.DataType, p)); err != nil {
+			vs.add(path, err.Error())
+			return
+		}
+	}
+	if cs != nil {
+		vs.check(f, p, GetDefaultReference(), cs, path)
+	}
+}
+
+// check applies the checks of the field to the value, reporting the first of
+// the violations, if any.
+func (vs *Violations) check(f *MessageFieldDef, p Primitive, r Reference, cs *constraints, path string) {
+	for _, c := range cs.checks {
+		if message := c(f, p, r); message != "" {
+			vs.add(path, message)
+			return
+		}
+	}
+}
+
+func (vs *Violations) add(path, message string) {
+	*vs = append(*vs, Violation{Path: path, Message: message})
+}
@@ -0,0 +1,272 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/umk/go-dymessage"
+	"github.com/umk/go-dymessage/json"
+	"github.com/umk/go-dymessage/logical"
+	"github.com/umk/go-dymessage/protobuf"
+)
+
+func createTestDef() *MessageDef {
+	rb := NewRegistryBuilder()
+	item := rb.ForMessageDef("item").
+		WithName("Item").
+		WithField("Name", 1, DtString).
+		ExtendField(Required()).
+		ExtendField(MinLength(2)).
+		ExtendField(MaxLength(4)).
+		WithField("Code", 2, DtString).
+		ExtendField(Pattern(`^[A-Z]+$`)).
+		WithField("Id", 3, DtBytes).
+		ExtendField(WithLogicalType(logical.UUID)).
+		Build()
+	def := rb.ForMessageDef("message").
+		WithName("Message").
+		WithField("Count", 1, DtInt32).
+		ExtendField(Required()).
+		ExtendField(Min(1)).
+		ExtendField(Max(10)).
+		WithField("Ratio", 2, DtFloat64).
+		ExtendField(Min(-0.5)).
+		WithField("Kind", 3, DtString).
+		ExtendField(In("a", "b")).
+		WithArrayField("Sizes", 4, DtUint32).
+		ExtendField(MaxItems(2)).
+		ExtendField(In(1, 2, 3)).
+		WithArrayField("Items", 5, item.DataType).
+		ExtendField(MinItems(1)).
+		WithField("Main", 6, item.DataType).
+		ExtendField(Required()).
+		WithField("Serial", 7, DtInt64).
+		ExtendField(Min(-1<<60)).
+		ExtendField(Max(1<<53+1)).
+		WithField("Total", 8, DtUint64).
+		ExtendField(Min(-1)).
+		ExtendField(Max(uint64(1<<64 - 2))).
+		Build()
+	rb.Build()
+	return def
+}
+
+func TestValidate(t *testing.T) {
+	def := createTestDef()
+	e, err := json.DecodeNew([]byte(`{"Count":5,"Ratio":0,"Kind":"a","Sizes":[1,3],`+
+		`"Items":[{"Name":"abc","Code":"AB"}],"Main":{"Name":"ab"},"Serial":9007199254740993,`+
+		`"Total":18446744073709551614}`), def)
+	require.NoError(t, err)
+	assert.NoError(t, Validate(e, def))
+
+	e, err = json.DecodeNew([]byte(`{"Count":11,"Ratio":-1,"Kind":"c","Sizes":[1,4,2],`+
+		`"Items":[{"Name":"abcde","Code":"ab"},null,{}],"Serial":9007199254740994,`+
+		`"Total":18446744073709551615}`), def)
+	require.NoError(t, err)
+	// The JSON decoder rejects the invalid UUIDs, so the value is set directly.
+	items := def.GetFieldByName("Items")
+	item := items.GetReferenceAt(e, 2).ToEntity()
+	idField := def.Registry.GetMessageDef(items.DataType).GetFieldByName("Id")
+	idField.SetReference(item, FromBytes([]byte{1, 2}, false))
+	err = Validate(e, def)
+	require.IsType(t, Violations{}, err)
+	assert.Equal(t, Violations{
+		{"Count", "must be at most 10"},
+		{"Ratio", "must be at least -0.5"},
+		{"Kind", "must be one of [a b]"},
+		{"Sizes", "must have at most 2 items"},
+		{"Sizes[1]", "must be one of [1 2 3]"},
+		{"Items[0].Name", "length must be at most 4"},
+		{"Items[0].Code", `must match "^[A-Z]+$"`},
+		{"Items[2].Name", "is required"},
+		{"Items[2].Id", "expected 16 bytes of UUID, but got 2"},
+		{"Main", "is required"},
+		{"Serial", "must be at most 9007199254740993"},
+		{"Total", "must be at most 18446744073709551614"},
+	}, err)
+
+	err = Validate(def.NewEntity(), def)
+	assert.EqualError(t, err, "dymessage: validation failed: Count: is required; Items: must have at least 1 items; Main: is required")
+}
+
+func TestDecodeWithValidation(t *testing.T) {
+	def := createTestDef()
+	e := def.NewEntity()
+	def.GetField(1).SetPrimitive(e, FromInt32(20))
+	data, err := protobuf.Encode(e, def)
+	require.NoError(t, err)
+	_, err = protobuf.DecodeNew(data, def)
+	assert.NoError(t, err)
+	_, err = protobuf.DecodeNew(data, def, protobuf.WithValidation(Validate))
+	assert.IsType(t, Violations{}, err)
+
+	_, err = json.DecodeNew([]byte(`{"Count":20}`), def, json.WithValidation(Validate))
+	assert.IsType(t, Violations{}, err)
+}
+
+func TestInvalidConstraints(t *testing.T) {
+	mb := NewRegistryBuilder().ForMessageDef("message")
+	assert.Panics(t, func() { mb.WithField("A", 1, DtString).ExtendField(Min(1)) })
+	assert.Panics(t, func() { mb.WithField("B", 2, DtInt32).ExtendField(Pattern(".")) })
+	assert.Panics(t, func() { mb.WithField("C", 3, DtString).ExtendField(Pattern("(")) })
+	assert.Panics(t, func() { mb.WithField("D", 4, DtInt32).ExtendField(In("a")) })
+	assert.Panics(t, func() { mb.WithField("E", 5, DtInt32).ExtendField(MaxItems(1)) })
+	assert.Panics(t, func() { mb.WithField("F", 6, DtInt64).ExtendField(Max(0.5)) })
+}
+
+func createRulesDef() *MessageDef {
+	rb := NewRegistryBuilder()
+	ts := protobuf.ForTimestamp(rb)
+	item := rb.ForMessageDef("item").
+		WithName("Item").
+		WithField("price", 1, DtFloat64).
+		WithField("quantity", 2, DtInt32).
+		ExtendMessage(Rule("price * quantity <= 1000", "must not cost more than 1000")).
+		Build()
+	def := rb.ForMessageDef("order").
+		WithNamespace("koala").
+		WithName("Order").
+		WithField("status", 1, DtString).
+		WithField("start_time", 2, ts).
+		WithField("end_time", 3, ts).
+		WithArrayField("items", 4, item.DataType).
+		WithField("note", 5, protobuf.ForWrapper(rb, DtString)).
+		ExtendMessage(Rule(`status != "CLOSED" || end_time > start_time`, "must end after start")).
+		ExtendMessage(Rule(`size(items) > 0 && items[0].quantity > 0`, "")).
+		Build()
+	rb.Build()
+	return def
+}
+
+func TestExpression(t *testing.T) {
+	def := createRulesDef()
+	e, err := json.DecodeNew([]byte(`{"status":"CLOSED","start_time":"2020-01-01T00:00:00Z",`+
+		`"end_time":"2020-01-01T01:30:00Z","items":[{"price":1.5,"quantity":2},{"quantity":3}],"note":"hi"}`), def)
+	require.NoError(t, err)
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{`1 + 2 * 3 - 4 / 2`, int64(5)},
+		{`7 % 4 + 0.5`, 3.5},
+		{`2e3 / 1E+3 - 1`, 1.0},
+		{`-(1 + 2)`, int64(-3)},
+		{`"ab" + "c" == "abc"`, true},
+		{`!(1 < 2) || 2 >= 2 && 1 != 1.5`, true},
+		{`items[0].price * items[0].quantity`, 3.0},
+		{`size(items) + size(status) + size(null)`, int64(8)},
+		{`end_time - start_time`, 90 * time.Minute},
+		{`end_time - start_time > end_time - end_time`, true},
+		{`note`, "hi"},
+		{`has(note) && has(items) && !has(items[1].price) && has(items[1].quantity)`, true},
+		{`missing.value == null`, false},
+	}
+	for _, tt := range tests {
+		x, err := CompileExpression(tt.expr)
+		require.NoError(t, err, tt.expr)
+		if tt.expr == `missing.value == null` {
+			_, err = x.Evaluate(e, def)
+			assert.EqualError(t, err, `dymessage: (1): unknown field "missing" of koala.Order`)
+			continue
+		}
+		v, err := x.Evaluate(e, def)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.expected, v, tt.expr)
+	}
+
+	errors := []struct {
+		expr, message string
+	}{
+		{`status == 1`, `dymessage: (8): operator == is not defined for string and int`},
+		{`items[2].price`, `dymessage: (7): index 2 is out of range of list of 2 items`},
+		{`status.value`, `dymessage: (7): cannot get field "value" of string`},
+		{`1 / (2 - 2)`, `dymessage: (3): division by zero`},
+		{`status && true`, `dymessage: (1): operand of && must be bool, but got string`},
+	}
+	for _, tt := range errors {
+		_, err := MustCompileExpression(tt.expr).Evaluate(e, def)
+		assert.EqualError(t, err, tt.message, tt.expr)
+	}
+
+	compileErrors := []struct {
+		expr, message string
+	}{
+		{`1 +`, `dymessage: (4): unexpected end of expression`},
+		{`(1 + 2`, `dymessage: (7): expected ")", but got end of expression`},
+		{`size(1, 2)`, `dymessage: (1): size() expects 1 argument, but got 2`},
+		{`has(1)`, `dymessage: (5): argument of has() must be a field`},
+		{`len(items)`, `dymessage: (1): unknown function len()`},
+		{`status # 1`, `dymessage: (8): unexpected character '#'`},
+		{`"abc`, `dymessage: (1): unterminated string`},
+	}
+	for _, tt := range compileErrors {
+		_, err := CompileExpression(tt.expr)
+		assert.EqualError(t, err, tt.message, tt.expr)
+	}
+	assert.Panics(t, func() { Rule("1 +", "") })
+}
+
+func TestRules(t *testing.T) {
+	def := createRulesDef()
+	e, err := json.DecodeNew([]byte(`{"status":"OPEN","items":[{"price":1.5,"quantity":2}]}`), def)
+	require.NoError(t, err)
+	assert.NoError(t, Validate(e, def))
+
+	e, err = json.DecodeNew([]byte(`{"status":"CLOSED","start_time":"2020-01-01T00:00:00Z",`+
+		`"end_time":"2019-01-01T00:00:00Z","items":[{"quantity":0},{"price":500,"quantity":3}]}`), def)
+	require.NoError(t, err)
+	assert.Equal(t, Violations{
+		{"items[1]", "must not cost more than 1000"},
+		{"", "must end after start"},
+		{"", "must satisfy size(items) > 0 && items[0].quantity > 0"},
+	}, Validate(e, def))
+
+	// The ordering of the null timestamps is not defined.
+	e, err = json.DecodeNew([]byte(`{"status":"CLOSED","items":[{"quantity":1}]}`), def)
+	require.NoError(t, err)
+	assert.EqualError(t, Validate(e, def), "dymessage: validation failed: cannot evaluate "+
+		`status != "CLOSED" || end_time > start_time: operator > is not defined for null and null`)
+}
+
+func TestCheckExpression(t *testing.T) {
+	def := createRulesDef()
+	for _, expr := range []string{
+		`status != "CLOSED" || end_time > start_time`,
+		`items[size(items) - 1].price * 2 > 1.5 && has(note) && note + "!" != ""`,
+		`end_time - start_time > end_time - end_time`,
+		`items[0].unknown == null`,
+	} {
+		if expr == `items[0].unknown == null` {
+			assert.EqualError(t, MustCompileExpression(expr).Check(def),
+				`dymessage: (9): unknown field "unknown" of Item`)
+			continue
+		}
+		assert.NoError(t, MustCompileExpression(expr).Check(def), expr)
+	}
+
+	errors := []struct {
+		expr, message string
+	}{
+		{`missing > 0`, `dymessage: (1): unknown field "missing" of koala.Order`},
+		{`status == 1`, `dymessage: (8): operator == is not defined for string and int`},
+		{`status.value`, `dymessage: (7): cannot get field "value" of string`},
+		{`status[0]`, `dymessage: (7): cannot index string`},
+		{`items["a"]`, `dymessage: (7): index must be int, but got string`},
+		{`size(end_time)`, `dymessage: (6): size() is not defined for timestamp`},
+		{`status && true`, `dymessage: (1): operand of && must be bool, but got string`},
+		{`-status`, `dymessage: (1): operator - is not defined for string`},
+	}
+	for _, tt := range errors {
+		assert.EqualError(t, MustCompileExpression(tt.expr).Check(def), tt.message, tt.expr)
+	}
+
+	mb := NewRegistryBuilder().ForMessageDef("message").WithField("count", 1, DtInt32)
+	assert.Panics(t, func() { mb.ExtendMessage(Rule("missing > 0", "")) })
+	assert.Panics(t, func() { mb.ExtendMessage(Rule(`count == "a"`, "")) })
+	assert.Panics(t, func() { mb.ExtendMessage(Rule("count + 1", "")) })
+	assert.NotPanics(t, func() { mb.ExtendMessage(Rule("count > 0", "")) })
+}
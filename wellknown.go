@@ -0,0 +1,45 @@
+package dymessage
+
+// The namespace of the well-known types of protocol buffers. The message
+// definitions in this namespace, which have the names of the well-known types,
+// are treated specially by the encoders and the generator of .proto files. Use
+// the functions of the protobuf package to add such definitions to the
+// registry.
+const WellKnownNamespace = "google.protobuf"
+
+// Names of the well-known types of protocol buffers.
+const (
+	WellKnownAny       = "Any"
+	WellKnownTimestamp = "Timestamp"
+	WellKnownDuration  = "Duration"
+
+	WellKnownDoubleValue = "DoubleValue"
+	WellKnownFloatValue  = "FloatValue"
+	WellKnownInt64Value  = "Int64Value"
+	WellKnownUInt64Value = "UInt64Value"
+	WellKnownInt32Value  = "Int32Value"
+	WellKnownUInt32Value = "UInt32Value"
+	WellKnownBoolValue   = "BoolValue"
+	WellKnownStringValue = "StringValue"
+	WellKnownBytesValue  = "BytesValue"
+
+	WellKnownStruct    = "Struct"
+	WellKnownValue     = "Value"
+	WellKnownListValue = "ListValue"
+)
+
+// QualifiedName gets the qualified name of the message definition as it would
+// be generated by a Protocol Buffers code on the client side when using the
+// .proto definitions, autogenerated from dynamic message definitions.
+func (md *MessageDef) QualifiedName() string {
+	if len(md.Namespace) == 0 {
+		return md.Name
+	}
+	return md.Namespace + "." + md.Name
+}
+
+// IsWellKnown gets a value indicating whether the message definition represents
+// the well-known type of protocol buffers with specified name.
+func (md *MessageDef) IsWellKnown(name string) bool {
+	return md.Namespace == WellKnownNamespace && md.Name == name
+}